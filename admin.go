@@ -0,0 +1,177 @@
+package raindrop
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// InitAdmin 根据配置连接数据库并校验表结构版本，不分配worker，供运维工具调用 worker 管理接口。
+// 已调用 Init 的进程无需调用，管理接口作用于配置的服务命名空间及数据中心
+func InitAdmin(ctx context.Context, conf config.RainDropConfig) error {
+	initLogger(ctx, &conf)
+
+	err := config.CheckConfig(ctx, &conf)
+	if err != nil {
+		return fmt.Errorf("config check fail: %w", err)
+	}
+	err = initDb(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("init db fail: %w", err)
+	}
+	err = db.InitAdmin(ctx, conf.DatacenterId)
+	if err != nil {
+		return fmt.Errorf("init admin fail: %w", err)
+	}
+	return nil
+}
+
+// ListWorkers 获取全部worker及其租约状态，包含已删除及已停用的，按id排序
+func ListWorkers(ctx context.Context) ([]model.WorkerLease, error) {
+	if db.Db == nil {
+		return nil, consts.ErrMsgDatabaseInitFail
+	}
+	now, err := db.Db.GetNowTime(ctx)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := db.Db.ListWorkers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]model.WorkerLease, 0, len(workers))
+	for _, w := range workers {
+		lease := model.WorkerLease{RaindropWorker: w, Status: consts.WorkerStatusFree}
+		switch {
+		case w.DelFlag == consts.WorkerDelFlagDeleted:
+			lease.Status = consts.WorkerStatusDeleted
+		case w.DelFlag == consts.WorkerDelFlagDisabled:
+			lease.Status = consts.WorkerStatusDisabled
+		case !w.HeartbeatTime.Before(worker.FreeHeartbeatTime(now, w.TimeUnit)) && w.Code == "":
+			lease.Status = consts.WorkerStatusReleased
+		case !w.HeartbeatTime.Before(worker.FreeHeartbeatTime(now, w.TimeUnit)):
+			lease.Status = consts.WorkerStatusLeased
+		}
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// DisableWorker 停用版本号为 version 的worker，停用后不会被分配，对账时也不会恢复。
+// 持有该worker的节点下次心跳时失去租约并停止生成id
+func DisableWorker(ctx context.Context, id int64, version int64) error {
+	if db.Db == nil {
+		return consts.ErrMsgDatabaseInitFail
+	}
+	previous := findWorker(ctx, id)
+	err := db.Db.DisableWorker(ctx, id, version)
+	if err == nil {
+		log.Info(ctx, "disable worker over. id: "+strconv.FormatInt(id, 10)+", version: "+strconv.FormatInt(version, 10))
+		recordWorkerEvent(ctx, previous, id, version+1, consts.WorkerEventDisable)
+	}
+	return err
+}
+
+// EnableWorker 启用版本号为 version 的已停用worker
+func EnableWorker(ctx context.Context, id int64, version int64) error {
+	if db.Db == nil {
+		return consts.ErrMsgDatabaseInitFail
+	}
+	err := db.Db.EnableWorker(ctx, id, version)
+	if err == nil {
+		log.Info(ctx, "enable worker over. id: "+strconv.FormatInt(id, 10)+", version: "+strconv.FormatInt(version, 10))
+	}
+	return err
+}
+
+// ForceRelease 强制释放版本号为 version 的worker。版本号递增作为隔离令牌，原持有节点下次心跳时失去租约并停止生成id；
+// 心跳时间置为当前时间，原持有节点的租约过期后（4 倍心跳间隔）才可被其他节点分配，避免与仍在生成 id 的原持有节点重复
+func ForceRelease(ctx context.Context, id int64, version int64) error {
+	if db.Db == nil {
+		return consts.ErrMsgDatabaseInitFail
+	}
+	previous := findWorker(ctx, id)
+	err := db.Db.ReleaseWorker(ctx, id, version)
+	if err == nil {
+		log.Info(ctx, "force release worker over. id: "+strconv.FormatInt(id, 10)+", version: "+strconv.FormatInt(version, 10))
+		recordWorkerEvent(ctx, previous, id, version+1, consts.WorkerEventRelease)
+	}
+	return err
+}
+
+// DeleteRange 删除 [beginId, endId] 范围内已停用的worker，每行按读取时的版本号删除，返回删除数量。
+// 未停用的worker不会被删除；读取后被修改的worker跳过，并返回 consts.ErrMsgWorkerVersionConflict。
+// 配置范围内被删除的worker会在下次启动对账时重新插入，需长期下线时应保持停用
+func DeleteRange(ctx context.Context, beginId int64, endId int64) (int64, error) {
+	if db.Db == nil {
+		return 0, consts.ErrMsgDatabaseInitFail
+	}
+	if beginId > endId {
+		return 0, errors.New("endId must be greater than beginId")
+	}
+	workers, err := db.Db.ListWorkers(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	var conflict error
+	for _, w := range workers {
+		if w.Id < beginId || w.Id > endId || w.DelFlag != consts.WorkerDelFlagDisabled {
+			continue
+		}
+		err = db.Db.DeleteWorker(ctx, w.Id, w.Version)
+		if errors.Is(err, consts.ErrMsgWorkerVersionConflict) {
+			conflict = err
+			continue
+		} else if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	log.Info(ctx, "delete workers over. range: "+strconv.FormatInt(beginId, 10)+"-"+strconv.FormatInt(endId, 10)+", deleted: "+strconv.FormatInt(deleted, 10))
+	return deleted, conflict
+}
+
+// findWorker 获取worker，用于记录分配历史，不存在或查询失败时返回空
+func findWorker(ctx context.Context, id int64) *model.RaindropWorker {
+	workers, err := db.Db.ListWorkers(ctx)
+	if err != nil {
+		return nil
+	}
+	for _, w := range workers {
+		if w.Id == id {
+			return &w
+		}
+	}
+	return nil
+}
+
+// recordWorkerEvent 记录worker被释放或停用的历史，节点自此失去worker。记录失败不影响操作结果，仅输出错误日志
+func recordWorkerEvent(ctx context.Context, previous *model.RaindropWorker, id int64, version int64, event string) {
+	history := &model.WorkerHistory{
+		WorkerId: id,
+		Version:  version,
+		Event:    event,
+	}
+	if previous != nil {
+		history.PreviousCode = previous.Code
+		history.Layout = previous.Layout
+	}
+	now, err := db.Db.GetNowTime(ctx)
+	if err == nil {
+		history.EventTime = now
+		err = db.Db.AddWorkerHistory(ctx, history)
+	}
+	if err != nil {
+		log.Error(ctx, "record worker history fail. id: "+strconv.FormatInt(id, 10)+", event: "+event+", error: "+err.Error(), err)
+	}
+}
@@ -0,0 +1,108 @@
+// raindrop-layout 计算 id 结构变更计划，输出新结构生成的 id 是否均大于旧结构的 id、最早的安全切换时间、需要调整的起始时间以及溢出时间。
+//
+//	raindrop-layout -old old.json -new new.json -max-id 123456789
+//
+// old.json、new.json 为 JSON 格式的 config.RainDropConfig，-now 指定计算时刻（RFC3339），默认为当前时间。
+// 计划安全时退出码为 0，否则为 1
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/model"
+)
+
+func main() {
+	oldPath := flag.String("old", "", "old layout config file (json)")
+	newPath := flag.String("new", "", "new layout config file (json)")
+	maxId := flag.Int64("max-id", 0, "maximum id currently stored")
+	now := flag.String("now", "", "plan time in RFC3339, default is now")
+	flag.Parse()
+
+	if *oldPath == "" || *newPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	oldConf, err := loadConfig(*oldPath)
+	if err != nil {
+		exit(err)
+	}
+	newConf, err := loadConfig(*newPath)
+	if err != nil {
+		exit(err)
+	}
+	if *now != "" {
+		t, err := time.Parse(time.RFC3339, *now)
+		if err != nil {
+			exit(fmt.Errorf("parse now fail: %w", err))
+		}
+		oldConf.Clock = fixedClock(t)
+		newConf.Clock = fixedClock(t)
+	}
+
+	plan, err := raindrop.PlanLayoutChange(context.Background(), oldConf, newConf, *maxId)
+	if err != nil {
+		exit(err)
+	}
+	printPlan(plan)
+	if !plan.Safe {
+		os.Exit(1)
+	}
+}
+
+// printPlan 输出变更计划，时间可能超出 JSON 支持的年份范围，因此按文本输出
+func printPlan(plan *model.LayoutPlan) {
+	fmt.Printf("safe: %t\n", plan.Safe)
+	fmt.Printf("maxIssuedId: %d\n", plan.MaxIssuedId)
+	fmt.Printf("oldMaxIdNow: %d\n", plan.OldMaxIdNow)
+	fmt.Printf("newMinIdNow: %d\n", plan.NewMinIdNow)
+	fmt.Printf("cutoverTime: %s\n", formatTime(plan.CutoverTime))
+	fmt.Printf("epochAdjustment: %d\n", plan.EpochAdjustment)
+	fmt.Printf("suggestedStartTimeStamp: %s\n", formatTime(plan.SuggestedStartTimeStamp))
+	fmt.Printf("overflowTime: %s\n", formatTime(plan.OverflowTime))
+	for _, warning := range plan.Warnings {
+		fmt.Printf("warning: %s\n", warning)
+	}
+}
+
+// formatTime 格式化时间，零值输出 -
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// fixedClock 固定时间的时钟，用于按 -now 指定的时刻计算
+type fixedClock time.Time
+
+// Now 获取固定的时间
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// loadConfig 读取 JSON 格式的配置文件
+func loadConfig(path string) (config.RainDropConfig, error) {
+	var conf config.RainDropConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return conf, fmt.Errorf("read config %s fail: %w", path, err)
+	}
+	err = json.Unmarshal(data, &conf)
+	if err != nil {
+		return conf, fmt.Errorf("parse config %s fail: %w", path, err)
+	}
+	return conf, nil
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err.Error())
+	os.Exit(2)
+}
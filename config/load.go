@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/treeyh/raindrop/consts"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// keyAliases 配置文件中兼容的字段名及对应的字段名
+	keyAliases = map[string]string{
+		// Deprecated: 与 EndBitsLength 的字段名一致的写法，使用 endBitValue
+		"endBitsValue": "endBitValue",
+	}
+)
+
+// Load 从 YAML 或 JSON 文件加载配置，JSON 按 YAML 解析，字段名与 json 标签相同，存在未知的字段时返回错误。
+// TimeUnit 支持名称（如 millisecond）或数值，StartTimeStamp 为 RFC3339 格式（如 2023-03-01T00:00:00Z），时长支持 30s、24h 等格式。
+// Logger、Observer 等无法序列化的配置需在加载后设置，未设置的配置项由 CheckConfig 设置默认值
+func Load(path string) (RainDropConfig, error) {
+	var conf RainDropConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return conf, fmt.Errorf("read config file %s fail: %w", path, err)
+	}
+	var node yaml.Node
+	err = yaml.Unmarshal(data, &node)
+	if err == nil && len(node.Content) > 0 {
+		replaceKeyAliases(node.Content[0])
+		// json.Marshal 将时长编码为纳秒数，转换为 yaml 支持的时长格式
+		normalizeDurations(node.Content[0], reflect.TypeOf(conf))
+		data, err = yaml.Marshal(&node)
+	}
+	if err == nil {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&conf)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return conf, fmt.Errorf("%w. parse config file %s fail: %w", consts.ErrMsgConfigInvalid, path, err)
+	}
+	return conf, nil
+}
+
+// replaceKeyAliases 将兼容的字段名替换为对应的字段名，同时设置时按重复的字段返回错误
+func replaceKeyAliases(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if name, ok := keyAliases[node.Content[i].Value]; ok {
+			node.Content[i].Value = name
+		}
+	}
+}
+
+// normalizeDurations 将结构体 t 中时长字段的整数值转换为以纳秒为单位的时长字符串，如 30000000000 转换为 30000000000ns
+func normalizeDurations(node *yaml.Node, t reflect.Type) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		for j := 0; j < t.NumField(); j++ {
+			field := t.Field(j)
+			if strings.Split(field.Tag.Get("yaml"), ",")[0] != key.Value {
+				continue
+			}
+			if field.Type == durationType && value.Kind == yaml.ScalarNode && value.Tag == "!!int" {
+				value.Tag = "!!str"
+				value.Value += "ns"
+			} else if field.Type.Kind() == reflect.Struct {
+				normalizeDurations(value, field.Type)
+			}
+			break
+		}
+	}
+}
+
+// FromEnv 从环境变量加载配置，变量名为 {prefix}_{名称}，prefix 为空时使用 consts.EnvPrefix，如 RAINDROP_DB_URL、RAINDROP_TIME_UNIT，
+// 名称为配置项 env 标签的值，嵌套的配置以 _ 连接，如 RAINDROP_DB_RETRY_MAX_ATTEMPTS。参见 ApplyEnv
+func FromEnv(prefix string) (RainDropConfig, error) {
+	var conf RainDropConfig
+	err := ApplyEnv(&conf, prefix)
+	return conf, err
+}
+
+// ApplyEnv 以已设置的环境变量覆盖 conf 中的配置，可用于覆盖 Load 加载的配置。值的格式与 Load 相同，NtpServers 以逗号分隔，
+// 一次返回全部格式错误的环境变量，每项均为 consts.ConfigError
+func ApplyEnv(conf *RainDropConfig, prefix string) error {
+	if prefix == "" {
+		prefix = consts.EnvPrefix
+	}
+	return errors.Join(applyEnv(reflect.ValueOf(conf).Elem(), prefix)...)
+}
+
+// applyEnv 按 env 标签设置结构体 v 的字段，返回格式错误的环境变量
+func applyEnv(v reflect.Value, prefix string) []error {
+	var errs []error
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := t.Field(i).Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		name = prefix + "_" + name
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct && f.Type() != timeType {
+			errs = append(errs, applyEnv(f, name)...)
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		err := setEnvValue(f, strings.TrimSpace(value))
+		if err != nil {
+			errs = append(errs, &consts.ConfigError{Field: name, Value: value, Reason: "invalid environment variable", Err: err})
+		}
+	}
+	return errs
+}
+
+// setEnvValue 解析环境变量的值并设置到字段 f
+func setEnvValue(f reflect.Value, value string) error {
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", f.Type())
+	}
+	return nil
+}
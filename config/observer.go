@@ -0,0 +1,95 @@
+package config
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeyh/raindrop/model"
+)
+
+// Observer 租约、时钟、容量及数据库事件的观察者，在心跳、生成id及数据库调用中同步回调，回调需尽快返回，panic 会被恢复并输出错误日志。
+// 可嵌入 NopObserver 只实现关心的事件
+type Observer interface {
+	// WorkerAcquired 激活或接管worker，event 参见 consts.WorkerEventActivate、consts.WorkerEventTakeover
+	WorkerAcquired(ctx context.Context, worker model.RaindropWorker, event string)
+
+	// WorkerReleased 因时间偏差超过阈值释放worker
+	WorkerReleased(ctx context.Context, worker model.RaindropWorker)
+
+	// WorkerLost 心跳或降级运行恢复时发现worker已被停用、释放或被其他节点激活，租约丢失，每个租约只回调一次
+	WorkerLost(ctx context.Context, worker model.RaindropWorker, err error)
+
+	// HeartbeatFailed 心跳失败
+	HeartbeatFailed(ctx context.Context, worker model.RaindropWorker, err error)
+
+	// HeartbeatCompleted 每次心跳结束时回调，latency 为心跳耗时，err 为心跳的错误，成功时为空
+	HeartbeatCompleted(ctx context.Context, worker model.RaindropWorker, latency time.Duration, err error)
+
+	// IdGenerated 每次生成id结束时回调，idMode 参见 consts.IdModeSnowflake，idCode 为 NewIdByCode 的 code，NewId 时为空，
+	// latency 为生成耗时，err 为生成失败的错误
+	IdGenerated(ctx context.Context, idMode string, idCode string, latency time.Duration, err error)
+
+	// ClockBackwards 时钟回拨，incident 包含回拨前后的时间戳流水及时间回拨位
+	ClockBackwards(ctx context.Context, incident model.ClockIncident)
+
+	// DriftExceeded 与DB或NTP服务器的时间偏差超过阈值，incident.Kind 为 consts.ClockIncidentDbDrift 或 consts.ClockIncidentNtpDrift
+	DriftExceeded(ctx context.Context, incident model.ClockIncident)
+
+	// SeqExhausted 时间戳流水 timeSeq 的序列号耗尽，wait 为等待下一时间戳流水的时长，不等待直接返回错误时为 0
+	SeqExhausted(ctx context.Context, idCode string, timeSeq int64, wait time.Duration)
+
+	// TimestampExpiring 时间戳位将在 consts.LayoutOverflowWarnTime 内耗尽，初始化及每次心跳时回调
+	TimestampExpiring(ctx context.Context, overflowTime time.Time, remaining time.Duration)
+
+	// DbRetry 数据库调用 op 的第 attempt 次尝试因临时错误 err 失败，等待 wait 后重试
+	DbRetry(ctx context.Context, op string, attempt int, wait time.Duration, err error)
+
+	// DbRetryFailed 数据库调用 op 共尝试 attempts 次后仍因临时错误 err 失败，包括重试次数用尽、超出重试时长及 ctx 结束
+	DbRetryFailed(ctx context.Context, op string, attempts int, err error)
+
+	// SchemaMigrated 表 table 执行版本 version 的表结构迁移结束，err 为迁移的错误，成功时为空
+	SchemaMigrated(ctx context.Context, table string, version int, description string, err error)
+}
+
+// NopObserver 不处理任何事件的观察者
+type NopObserver struct {
+}
+
+func (NopObserver) WorkerAcquired(ctx context.Context, worker model.RaindropWorker, event string) {
+}
+
+func (NopObserver) WorkerReleased(ctx context.Context, worker model.RaindropWorker) {
+}
+
+func (NopObserver) WorkerLost(ctx context.Context, worker model.RaindropWorker, err error) {
+}
+
+func (NopObserver) HeartbeatFailed(ctx context.Context, worker model.RaindropWorker, err error) {
+}
+
+func (NopObserver) HeartbeatCompleted(ctx context.Context, worker model.RaindropWorker, latency time.Duration, err error) {
+}
+
+func (NopObserver) IdGenerated(ctx context.Context, idMode string, idCode string, latency time.Duration, err error) {
+}
+
+func (NopObserver) ClockBackwards(ctx context.Context, incident model.ClockIncident) {
+}
+
+func (NopObserver) DriftExceeded(ctx context.Context, incident model.ClockIncident) {
+}
+
+func (NopObserver) SeqExhausted(ctx context.Context, idCode string, timeSeq int64, wait time.Duration) {
+}
+
+func (NopObserver) TimestampExpiring(ctx context.Context, overflowTime time.Time, remaining time.Duration) {
+}
+
+func (NopObserver) DbRetry(ctx context.Context, op string, attempt int, wait time.Duration, err error) {
+}
+
+func (NopObserver) DbRetryFailed(ctx context.Context, op string, attempts int, err error) {
+}
+
+func (NopObserver) SchemaMigrated(ctx context.Context, table string, version int, description string, err error) {
+}
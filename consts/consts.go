@@ -1,5 +1,7 @@
 package consts
 
+import "time"
+
 type TimeUnit int
 
 const (
@@ -13,6 +15,9 @@ const (
 const (
 	ProjectName = "raindrop"
 
+	// EnvPrefix config.FromEnv 默认的环境变量前缀
+	EnvPrefix = "RAINDROP"
+
 	SkipHeartbeat = "skip_heartbeat"
 )
 
@@ -22,15 +27,42 @@ const (
 
 	IdBitLength       = 63
 	TimeBackBitLength = 1
+
+	// WorkIdLength 默认的工作节点 id 长度
+	WorkIdLength = 4
 )
 
 const (
 	DbTypeMySql = "mysql"
 
+	DbTypeTiDB = "tidb"
+
 	DbTypePostgreSQL = "postgresql"
 
+	DbTypeSQLite = "sqlite"
+
+	// DbTypeMemory 内存数据库，仅用于单进程或测试场景
+	DbTypeMemory = "memory"
+
+	// DbTableName 默认的worker表名
+	DbTableName = "soc_raindrop_worker"
+
 	DbMaxOpenConns = 3
 	DbMaxIdleConns = 2
+
+	// DbRetryMaxAttempts 数据库调用默认最大尝试次数
+	DbRetryMaxAttempts = 3
+	// DbRetryInitialBackoff 数据库调用首次重试默认等待时间
+	DbRetryInitialBackoff = 100 * time.Millisecond
+	// DbRetryMaxBackoff 数据库调用单次重试默认最大等待时间
+	DbRetryMaxBackoff = 2 * time.Second
+	// DbRetryMultiplier 数据库调用重试等待时间默认增长倍数
+	DbRetryMultiplier = 2.0
+	// DbRetryJitter 数据库调用重试等待时间默认抖动比例
+	DbRetryJitter = 0.2
+
+	// DbMigrateLockTimeout 表结构迁移等待数据库锁的超时时间，秒
+	DbMigrateLockTimeout = 60
 )
 
 const (
@@ -39,4 +71,92 @@ const (
 
 	// HeartbeatTimeInterval 数据库心跳时间间隔，秒
 	HeartbeatTimeInterval = 30
+
+	// LeaseReconnectInterval 降级运行时重连数据库的时间间隔
+	LeaseReconnectInterval = 5 * time.Second
+
+	// LayoutQuarantineTime worker 最近一次使用的 id 结构与当前不同时的默认隔离时长
+	LayoutQuarantineTime = 24 * time.Hour
+
+	// LayoutOverflowWarnTime id 结构在该时长内溢出时给出警告
+	LayoutOverflowWarnTime = 10 * 365 * 24 * time.Hour
+)
+
+const (
+	// WorkerDelFlagDeleted worker已删除，超出配置范围时由对账标记，回到范围内时恢复
+	WorkerDelFlagDeleted = 1
+
+	// WorkerDelFlagNormal worker正常
+	WorkerDelFlagNormal = 2
+
+	// WorkerDelFlagDisabled worker被停用，对账时不会恢复，需调用 EnableWorker 启用
+	WorkerDelFlagDisabled = 3
+)
+
+const (
+	// WorkerStatusLeased worker已被节点持有，心跳未过期
+	WorkerStatusLeased = "leased"
+
+	// WorkerStatusReleased worker已被强制释放，原持有节点的租约过期前不会被分配
+	WorkerStatusReleased = "released"
+
+	// WorkerStatusFree worker空闲，可被分配
+	WorkerStatusFree = "free"
+
+	// WorkerStatusDisabled worker已停用
+	WorkerStatusDisabled = "disabled"
+
+	// WorkerStatusDeleted worker超出配置范围已删除
+	WorkerStatusDeleted = "deleted"
+)
+
+const (
+	// WorkerEventActivate 节点激活空闲的worker
+	WorkerEventActivate = "activate"
+
+	// WorkerEventTakeover 节点接管其他节点租约已过期的worker
+	WorkerEventTakeover = "takeover"
+
+	// WorkerEventRelease worker被强制释放
+	WorkerEventRelease = "release"
+
+	// WorkerEventDisable worker被停用
+	WorkerEventDisable = "disable"
+)
+
+const (
+	// ClockIncidentBackwards 时钟回拨，时间回拨位取反
+	ClockIncidentBackwards = "backwards"
+
+	// ClockIncidentDbDrift 估算的服务器与DB时间偏差超过 DriftThreshold
+	ClockIncidentDbDrift = "db_drift"
+
+	// ClockIncidentNtpDrift 服务器与NTP服务器时间偏差超过 DriftThreshold
+	ClockIncidentNtpDrift = "ntp_drift"
+
+	// ClockIncidentSeqExhausted 同一时刻的序列号耗尽
+	ClockIncidentSeqExhausted = "seq_exhausted"
+
+	// ClockIncidentRingSize 内存中保留的最近时钟异常数量
+	ClockIncidentRingSize = 256
+
+	// ClockIncidentBurstInterval 序列号耗尽在该时长内只记录一次，期间的次数合并到下一条记录
+	ClockIncidentBurstInterval = time.Minute
+)
+
+const (
+	// DriftPolicyWarn 与DB时间的偏差超过阈值时仅输出错误日志并记录时钟异常
+	DriftPolicyWarn = "warn"
+
+	// DriftPolicyPause 与DB时间的偏差超过阈值时暂停生成id，偏差恢复后继续
+	DriftPolicyPause = "pause"
+
+	// DriftPolicyRelease 与DB时间的偏差超过阈值时释放worker并停止生成id，需重新初始化
+	DriftPolicyRelease = "release"
+
+	// NtpTimeout 查询NTP服务器的默认超时时间
+	NtpTimeout = 2 * time.Second
+
+	// DriftSmoothingFactor 与DB时间偏差的指数平滑系数，新采样的权重
+	DriftSmoothingFactor = 0.2
 )
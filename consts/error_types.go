@@ -0,0 +1,103 @@
+package consts
+
+import (
+	"fmt"
+	"time"
+)
+
+// ConfigError 配置校验失败，可通过 errors.Is(err, ErrMsgConfigInvalid) 判断，Err 不为空时同样匹配 Err
+type ConfigError struct {
+	// Field 校验失败的配置项
+	Field string
+
+	// Value 配置项的值
+	Value any
+
+	// Reason 失败原因
+	Reason string
+
+	// Err 对应的错误，如 ErrMsgStartTimeStampError，可为空
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s. %s: %v, %s", ErrMsgConfigInvalid.Error(), e.Field, e.Value, e.Reason)
+}
+
+func (e *ConfigError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{ErrMsgConfigInvalid}
+	}
+	return []error{ErrMsgConfigInvalid, e.Err}
+}
+
+// DbError 数据库操作失败，Err 为驱动返回的原始错误
+type DbError struct {
+	// Op 失败的操作，如 connect、migrate、activate worker
+	Op string
+
+	// Err 原始错误
+	Err error
+}
+
+func (e *DbError) Error() string {
+	return "Database " + e.Op + " failed: " + e.Err.Error()
+}
+
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+// LeaseError 分配或确认worker租约失败
+type LeaseError struct {
+	DatacenterId int64
+
+	// MinWorkerId 请求的worker id 范围下限
+	MinWorkerId int64
+
+	// MaxWorkerId 请求的worker id 范围上限
+	MaxWorkerId int64
+
+	// WorkerId 持有的worker id，分配失败时为 0
+	WorkerId int64
+
+	TimeUnit TimeUnit
+
+	// Err 对应的错误，如 ErrMsgWorkersNotAvailable、ErrMsgWorkerLayoutMismatch、ErrMsgWorkerLeaseLost
+	Err error
+}
+
+func (e *LeaseError) Error() string {
+	return fmt.Sprintf("%s. datacenterId: %d, range: %d-%d, workerId: %d, timeUnit: %d",
+		e.Err.Error(), e.DatacenterId, e.MinWorkerId, e.MaxWorkerId, e.WorkerId, int(e.TimeUnit))
+}
+
+func (e *LeaseError) Unwrap() error {
+	return e.Err
+}
+
+// ClockError 服务器时钟与DB或NTP服务器的偏差超过阈值
+type ClockError struct {
+	// Source 参照的时钟，参见 ClockIncidentDbDrift、ClockIncidentNtpDrift
+	Source string
+
+	// Offset 观测到的偏差，参照时钟减去服务器时钟
+	Offset time.Duration
+
+	// Threshold 允许的偏差阈值
+	Threshold time.Duration
+
+	TimeUnit TimeUnit
+
+	// Err 对应的错误，如 ErrMsgDatabaseServerTimeInterval、ErrMsgNtpOffsetExceeded
+	Err error
+}
+
+func (e *ClockError) Error() string {
+	return fmt.Sprintf("%s. source: %s, offset: %s, threshold: %s, timeUnit: %d",
+		e.Err.Error(), e.Source, e.Offset, e.Threshold, int(e.TimeUnit))
+}
+
+func (e *ClockError) Unwrap() error {
+	return e.Err
+}
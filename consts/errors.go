@@ -3,6 +3,9 @@ package consts
 import "errors"
 
 var (
+	// ErrMsgConfigInvalid 配置校验失败
+	ErrMsgConfigInvalid = errors.New("Invalid config")
+
 	// ErrMsgDatabaseInitFail 数据库初始化失败
 	ErrMsgDatabaseInitFail = errors.New("Database initialization failed")
 
@@ -30,6 +33,42 @@ var (
 	// ErrMsgIdSeqReachesMaxValueError 当前时刻id生成序列达到最大值
 	ErrMsgIdSeqReachesMaxValueError = errors.New("The current moment id generation sequence reaches its maximum value")
 
+	// ErrMsgWorkerLeaseLost worker租约已丢失，版本号已被其他节点修改
+	ErrMsgWorkerLeaseLost = errors.New("Worker lease lost")
+
+	// ErrMsgSchemaVersionOutdated 表结构版本落后，需要执行迁移
+	ErrMsgSchemaVersionOutdated = errors.New("Schema version is outdated, migration required")
+
+	// ErrMsgIdSpaceConflict id空间已被其他服务命名空间使用
+	ErrMsgIdSpaceConflict = errors.New("Id space is already used by another service")
+
+	// ErrMsgWorkerLayoutMismatch 空闲的worker最近被其他 id 结构使用，需先执行 id 结构变更
+	ErrMsgWorkerLayoutMismatch = errors.New("Workers were recently used by a different id layout, change layout first")
+
+	// ErrMsgLayoutChangeUnsafe 新 id 结构当前生成的 id 不大于已生成的最大 id
+	ErrMsgLayoutChangeUnsafe = errors.New("New id layout does not generate ids greater than the issued max id")
+
+	// ErrMsgWorkerVersionConflict worker不存在、状态不符或版本号已被修改
+	ErrMsgWorkerVersionConflict = errors.New("Worker not found or version conflict")
+
+	// ErrMsgIdOriginNotFound 没有与id匹配的worker分配历史
+	ErrMsgIdOriginNotFound = errors.New("No worker assignment history matches the id")
+
+	// ErrMsgClockDriftExceeded 与DB时间的偏差超过阈值，已停止生成id
+	ErrMsgClockDriftExceeded = errors.New("Clock drift from database exceeds threshold, id generation stopped")
+
+	// ErrMsgNtpOffsetExceeded 与NTP服务器的时间偏差超过阈值
+	ErrMsgNtpOffsetExceeded = errors.New("Clock offset from NTP servers exceeds threshold")
+
+	// ErrMsgNtpUnavailable 全部NTP服务器查询失败
+	ErrMsgNtpUnavailable = errors.New("No NTP server available")
+
+	// ErrMsgLeaseCacheInvalid 租约缓存不存在、已过期或与配置不符，无法降级启动
+	ErrMsgLeaseCacheInvalid = errors.New("Lease cache is missing, expired or does not match the config")
+
+	// ErrMsgLeaseExpired 降级运行时缓存的租约在数据库恢复前已过期，已停止生成id
+	ErrMsgLeaseExpired = errors.New("Cached worker lease expired before the database recovered, id generation stopped")
+
 	// ErrMsgGetCodeLockFail 获取编号锁失败
 	ErrMsgGetCodeLockFail = errors.New("Failed to get code lock")
 )
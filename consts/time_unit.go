@@ -0,0 +1,61 @@
+package consts
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// timeUnitNames 时间单位的名称
+var timeUnitNames = map[TimeUnit]string{
+	TimeUnitMillisecond: "millisecond",
+	TimeUnitSecond:      "second",
+	TimeUnitMinute:      "minute",
+	TimeUnitHour:        "hour",
+	TimeUnitDay:         "day",
+}
+
+// String 时间单位的名称，如 millisecond，未知的时间单位输出数值
+func (u TimeUnit) String() string {
+	if name, ok := timeUnitNames[u]; ok {
+		return name
+	}
+	return strconv.Itoa(int(u))
+}
+
+// MarshalText 编码为时间单位的名称
+func (u TimeUnit) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText 解析时间单位的名称（不区分大小写）或数值，如 millisecond、second、2
+func (u *TimeUnit) UnmarshalText(text []byte) error {
+	s := strings.ToLower(strings.TrimSpace(string(text)))
+	for unit, name := range timeUnitNames {
+		if s == name {
+			*u = unit
+			return nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("unknown time unit %q, needs to be millisecond, second, minute, hour or day", string(text))
+	}
+	*u = TimeUnit(v)
+	return nil
+}
+
+// UnmarshalJSON 解析时间单位的名称或数值，兼容以数值编码的时间单位
+func (u *TimeUnit) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return u.UnmarshalText([]byte(s))
+	}
+	var v int
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("unknown time unit %s, needs to be millisecond, second, minute, hour or day", string(data))
+	}
+	*u = TimeUnit(v)
+	return nil
+}
@@ -3,11 +3,14 @@ package db
 import (
 	"context"
 	"database/sql"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"io"
+	"strconv"
 	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/treeyh/raindrop/config"
 	"github.com/treeyh/raindrop/consts"
 	"github.com/treeyh/raindrop/logger"
@@ -17,20 +20,32 @@ import (
 var (
 	Db IDb
 
-	// Db  *MySqlDb
+	log logger.ILogger
 
-	_mysqlDb *sql.DB
+	tableName = consts.DbTableName
 
-	_pgDbPool *pgxpool.Pool
+	// schema 表所在的 schema，MySql 为数据库名
+	schema string
 
-	log logger.ILogger
+	// disableMigrate 初始化时不执行表结构迁移，仅校验版本
+	disableMigrate bool
+
+	// service 服务命名空间
+	service string
 
-	tableName = "soc_raindrop_worker"
+	// idSpace 当前服务占用的id空间，同一id空间只能被一个服务使用
+	idSpace string
+
+	// interceptor 包装IDb的拦截器，用于链路追踪等，为空时不包装
+	interceptor func(IDb) IDb
+
+	// rawDb UseDb 传入的IDb，未经重试及拦截器包装
+	rawDb IDb
 )
 
 type IDb interface {
-	// InitSql 初始化
-	InitSql(tableName string)
+	// InitSql 初始化，schema 为空时使用连接的默认 schema，service 和 datacenterId 为worker所属的服务命名空间及数据中心
+	InitSql(schema string, tableName string, service string, datacenterId int64)
 
 	// GetNowTime 获取数据库当前时间
 	GetNowTime(ctx context.Context) (time.Time, error)
@@ -38,8 +53,18 @@ type IDb interface {
 	// ExistTable 表是否存在
 	ExistTable(ctx context.Context) (bool, error)
 
-	// InitTableWorkers 初始化workers
-	InitTableWorkers(ctx context.Context, beginId int64, endId int64) error
+	// Migrate 执行表结构迁移
+	Migrate(ctx context.Context) error
+
+	// SchemaVersion 获取当前已执行的及最新的表结构版本
+	SchemaVersion(ctx context.Context) (int, int, error)
+
+	// RegisterService 登记当前服务占用的id空间，id空间已被其他服务占用时返回 consts.ErrMsgIdSpaceConflict
+	RegisterService(ctx context.Context, idSpace string) error
+
+	// ReconcileWorkers 补齐 [beginId, endId] 范围内缺失的worker；disableBefore 不为零值时，
+	// 将心跳早于该时间的范围外worker标记为删除，并恢复范围内已删除的worker
+	ReconcileWorkers(ctx context.Context, beginId int64, endId int64, disableBefore time.Time) (*model.WorkerReconcileResult, error)
 
 	// GetBeforeWorker 找到该节点之前的worker
 	GetBeforeWorker(ctx context.Context, code string) (*model.RaindropWorker, error)
@@ -47,83 +72,242 @@ type IDb interface {
 	// QueryFreeWorkers 查询空闲的workers
 	QueryFreeWorkers(ctx context.Context, heartbeatTime time.Time) ([]model.RaindropWorker, error)
 
-	// ActivateWorker 激活启用worker
-	ActivateWorker(ctx context.Context, id int64, code string, timeUnit int, version int64) (*model.RaindropWorker, error)
+	// ActivateWorker 激活启用worker，并记录当前的 id 结构指纹 layout
+	ActivateWorker(ctx context.Context, id int64, code string, timeUnit int, layout string, version int64) (*model.RaindropWorker, error)
+
+	// ChangeWorkersLayout 将心跳早于 heartbeatTime 且 id 结构指纹不是 layout 的worker改为 layout，返回修改数量
+	ChangeWorkersLayout(ctx context.Context, layout string, heartbeatTime time.Time) (int64, error)
 
 	// HeartbeatWorker 心跳
 	HeartbeatWorker(ctx context.Context, worker *model.RaindropWorker) (*model.RaindropWorker, error)
 
 	// GetWorkerById 根据id获取worker
 	GetWorkerById(ctx context.Context, id int64) (*model.RaindropWorker, error)
+
+	// ListWorkers 获取全部worker，包含已删除及已停用的，按id排序
+	ListWorkers(ctx context.Context) ([]model.RaindropWorker, error)
+
+	// DisableWorker 停用版本号为 version 的正常worker并递增版本号，不满足条件时返回 consts.ErrMsgWorkerVersionConflict
+	DisableWorker(ctx context.Context, id int64, version int64) error
+
+	// EnableWorker 启用版本号为 version 的已停用worker并递增版本号，不满足条件时返回 consts.ErrMsgWorkerVersionConflict
+	EnableWorker(ctx context.Context, id int64, version int64) error
+
+	// ReleaseWorker 释放版本号为 version 的正常worker：清空 code、心跳时间置为当前时间并递增版本号，租约过期后才可被分配，
+	// 不满足条件时返回 consts.ErrMsgWorkerVersionConflict
+	ReleaseWorker(ctx context.Context, id int64, version int64) error
+
+	// DeleteWorker 删除版本号为 version 的已停用worker，不满足条件时返回 consts.ErrMsgWorkerVersionConflict
+	DeleteWorker(ctx context.Context, id int64, version int64) error
+
+	// AddWorkerHistory 记录worker分配历史，服务命名空间及数据中心为当前所属范围
+	AddWorkerHistory(ctx context.Context, history *model.WorkerHistory) error
+
+	// QueryWorkerHistory 查询数据中心 datacenterId 的worker workerId 的分配历史，按事件发生时间排序
+	QueryWorkerHistory(ctx context.Context, datacenterId int64, workerId int64) ([]model.WorkerHistory, error)
+
+	// AddClockIncident 记录时钟异常，服务命名空间及数据中心为当前所属范围
+	AddClockIncident(ctx context.Context, incident *model.ClockIncident) error
+
+	// QueryClockIncidents 查询当前服务命名空间全部数据中心在 [begin, end) 内发生的时钟异常，按发生时间排序
+	QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error)
 }
 
 // InitMySqlDb 初始化MySql
 func InitMySqlDb(ctx context.Context, dbConfig config.RainDropDbConfig, l logger.ILogger) error {
+	dbConfig.DbType = consts.DbTypeMySql
+	return InitSqlDb(ctx, dbConfig, l)
+}
+
+// InitPostgreSqlDb 初始化PostgreSql
+func InitPostgreSqlDb(ctx context.Context, dbConfig config.RainDropDbConfig, l logger.ILogger) error {
+	dbConfig.DbType = consts.DbTypePostgreSQL
+	return InitSqlDb(ctx, dbConfig, l)
+}
+
+// InitSqlDb 根据 DbType 对应的方言初始化 database/sql 数据库
+func InitSqlDb(ctx context.Context, dbConfig config.RainDropDbConfig, l logger.ILogger) error {
 	log = l
 
-	if dbConfig.TableName != "" {
-		tableName = dbConfig.TableName
+	dialect, err := GetDialect(dbConfig.DbType)
+	if err != nil {
+		log.Error(ctx, consts.ErrMsgDatabaseInitFail.Error()+": "+err.Error(), err)
+		return err
 	}
 
-	var err error
-	_mysqlDb, err = sql.Open(dbConfig.DbType, dbConfig.DbUrl)
+	d, err := openSqlDb(ctx, dbConfig, dialect)
 	if err != nil {
 		log.Error(ctx, consts.ErrMsgDatabaseInitFail.Error(), err)
 		return err
 	}
+	d.statementTimeout = dbConfig.StatementTimeout
 
-	_mysqlDb.SetMaxOpenConns(consts.DbMaxOpenConns)
-	_mysqlDb.SetMaxIdleConns(consts.DbMaxIdleConns)
-
-	err = _mysqlDb.Ping()
+	_, err = retry(ctx, retryPolicy(dbConfig.Retry), observer, "Ping", 0, func() (bool, error) {
+		return true, d.db.PingContext(ctx)
+	})
 	if err != nil {
 		log.Error(ctx, consts.ErrMsgDatabaseInitFail.Error(), err)
+		d.Close()
 		return err
 	}
 
-	Db = &MySqlDb{}
-	Db.InitSql(tableName)
-	return nil
+	return UseDb(ctx, d, dbConfig, l)
 }
 
-// InitPostgreSqlDb 初始化PostgreSql
-func InitPostgreSqlDb(ctx context.Context, dbConfig config.RainDropDbConfig, l logger.ILogger) error {
+// openSqlDb 打开数据库连接，优先使用调用方提供的连接池，PostgreSql 未指定驱动时使用 pgxpool
+func openSqlDb(ctx context.Context, dbConfig config.RainDropDbConfig, dialect Dialect) (*SqlDb, error) {
+	if dbConfig.SqlDb != nil {
+		return NewSqlDb(dbConfig.SqlDb, dialect), nil
+	}
+
+	_, isPg := dialect.(PostgreSqlDialect)
+	if isPg && dbConfig.PgxPool != nil {
+		// 关闭 OpenDBFromPool 返回的 sql.DB 不会关闭 pgxpool
+		d := NewSqlDb(stdlib.OpenDBFromPool(dbConfig.PgxPool), dialect)
+		d.ownDb = true
+		return d, nil
+	}
+
+	if isPg && dbConfig.DriverName == "" {
+		dbUrl := strings.TrimSpace(dbConfig.DbUrl)
+		if !strings.HasPrefix(dbUrl, "postgres://") && !strings.HasPrefix(dbUrl, "postgresql://") {
+			dbUrl = "postgres://" + dbUrl
+		}
+		poolConfig, err := pgxpool.ParseConfig(dbUrl)
+		if err != nil {
+			return nil, err
+		}
+		poolConfig.MaxConns = int32(dbConfig.MaxOpenConns)
+		if dbConfig.ConnMaxLifetime > 0 {
+			poolConfig.MaxConnLifetime = dbConfig.ConnMaxLifetime
+		}
+		if dbConfig.ConnMaxIdleTime > 0 {
+			poolConfig.MaxConnIdleTime = dbConfig.ConnMaxIdleTime
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+		if err != nil {
+			return nil, err
+		}
+		d := NewSqlDb(stdlib.OpenDBFromPool(pool), dialect)
+		d.ownDb = true
+		d.ownPool = pool
+		return d, nil
+	}
+
+	driverName := dbConfig.DriverName
+	if driverName == "" {
+		driverName = dialect.DriverName()
+	}
+	sqlDb, err := sql.Open(driverName, dbConfig.DbUrl)
+	if err != nil {
+		return nil, err
+	}
+	sqlDb.SetMaxOpenConns(dbConfig.MaxOpenConns)
+	sqlDb.SetMaxIdleConns(dbConfig.MaxIdleConns)
+	sqlDb.SetConnMaxLifetime(dbConfig.ConnMaxLifetime)
+	sqlDb.SetConnMaxIdleTime(dbConfig.ConnMaxIdleTime)
+
+	d := NewSqlDb(sqlDb, dialect)
+	d.ownDb = true
+	return d, nil
+}
+
+// InitMemoryDb 初始化内存数据库
+func InitMemoryDb(ctx context.Context, dbConfig config.RainDropDbConfig, l logger.ILogger) error {
+	return UseDb(ctx, NewMemoryDb(nil), dbConfig, l)
+}
+
+// UseDb 使用调用方提供的IDb实现，之前由 raindrop 创建的连接池会被关闭
+func UseDb(ctx context.Context, d IDb, dbConfig config.RainDropDbConfig, l logger.ILogger) error {
 	log = l
 
 	if dbConfig.TableName != "" {
 		tableName = dbConfig.TableName
 	}
+	schema = dbConfig.Schema
+	disableMigrate = dbConfig.DisableMigrate
+	service = dbConfig.Service
+	idSpace = dbConfig.IdSpace
 
-	var err error
-	dbUrl := "postgres://" + strings.TrimSpace(dbConfig.DbUrl)
-	_pgDbPool, err = pgxpool.New(ctx, dbUrl)
-	if err != nil {
-		log.Error(ctx, consts.ErrMsgDatabaseInitFail.Error(), err)
-		return err
+	raw := unwrap(d)
+	if Db != nil && unwrap(Db) != raw {
+		Close(ctx)
 	}
-
-	err = _pgDbPool.Ping(ctx)
-	if err != nil {
-		log.Error(ctx, consts.ErrMsgDatabaseInitFail.Error(), err)
-		return err
+	if interceptor != nil || dbConfig.Retry.MaxAttempts > 1 {
+		// 拦截器在重试之内，每次尝试均经过拦截器
+		d = raw
+		if interceptor != nil {
+			d = interceptor(d)
+		}
+		if dbConfig.Retry.MaxAttempts > 1 {
+			d = NewRetryDb(d, dbConfig.Retry, observer)
+		}
+	}
+	if s, ok := raw.(*SqlDb); ok {
+		s.SetObserver(observer)
 	}
+	rawDb = raw
+	Db = d
+	Db.InitSql(schema, tableName, service, 0)
+	return nil
+}
 
-	Db = &PostgreSqlDb{}
-	Db.InitSql(tableName)
+// Close 关闭当前数据库中由 raindrop 创建的连接池，调用方提供的连接池不会被关闭
+func Close(ctx context.Context) error {
+	if c, ok := unwrap(Db).(io.Closer); ok {
+		return c.Close()
+	}
 	return nil
 }
 
-func InitTableWorkers(ctx context.Context, beginId int64, endId int64) error {
-	exist, err := Db.ExistTable(ctx)
+// SetInterceptor 设置包装IDb的拦截器，下次初始化数据库时生效，为空时不包装。拦截器在重试之内，每次尝试均经过拦截器
+func SetInterceptor(f func(IDb) IDb) {
+	interceptor = f
+}
+
+// unwrap 获取未经重试及拦截器包装的IDb
+func unwrap(d IDb) IDb {
+	if d == Db && rawDb != nil {
+		return rawDb
+	}
+	if r, ok := d.(*RetryDb); ok {
+		return r.Unwrap()
+	}
+	return d
+}
+
+// InitAdmin 切换到数据中心 datacenterId 的worker并校验表结构版本，不执行迁移及对账，用于管理worker
+func InitAdmin(ctx context.Context, datacenterId int64) error {
+	Db.InitSql(schema, tableName, service, datacenterId)
+	return CheckSchemaVersion(ctx)
+}
+
+// InitTableWorkers 执行表结构迁移（DisableMigrate 时仅校验版本），登记服务的id空间，并按配置的范围对账数据中心 datacenterId 的worker，
+// disableBefore 参见 IDb.ReconcileWorkers
+func InitTableWorkers(ctx context.Context, datacenterId int64, beginId int64, endId int64, disableBefore time.Time) error {
+	Db.InitSql(schema, tableName, service, datacenterId)
+
+	var err error
+	if disableMigrate {
+		err = CheckSchemaVersion(ctx)
+	} else {
+		err = Db.Migrate(ctx)
+	}
 	if err != nil {
 		return err
 	}
-	if exist {
-		return nil
+
+	err = Db.RegisterService(ctx, idSpace)
+	if err != nil {
+		return err
 	}
-	err = Db.InitTableWorkers(ctx, beginId, endId)
+
+	result, err := Db.ReconcileWorkers(ctx, beginId, endId, disableBefore)
 	if err != nil {
 		return err
 	}
-	return err
+	log.Info(ctx, "reconcile workers over. datacenterId: "+strconv.FormatInt(datacenterId, 10)+", range: "+strconv.FormatInt(beginId, 10)+"-"+strconv.FormatInt(endId, 10)+
+		", inserted: "+strconv.FormatInt(result.Inserted, 10)+", disabled: "+strconv.FormatInt(result.Disabled, 10)+
+		", enabled: "+strconv.FormatInt(result.Enabled, 10))
+	return nil
 }
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/treeyh/raindrop/consts"
+)
+
+// Table 表名，Schema 为空时使用连接的默认 schema，MySql 中 Schema 为数据库名
+type Table struct {
+	Schema string
+	Name   string
+}
+
+// String 表的完整名称，用于日志及锁名
+func (t Table) String() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// indexName 基于表名生成索引名，避免同一个 schema 下多张表的索引名冲突
+func (t Table) indexName(suffix string) string {
+	return "idx_" + t.Name + "_" + suffix
+}
+
+// serviceTable 记录 id 空间所属服务命名空间的表，与worker表位于同一个 schema
+func (t Table) serviceTable() Table {
+	return Table{Schema: t.Schema, Name: t.Name + "_service"}
+}
+
+// historyTable 记录worker分配历史的表，与worker表位于同一个 schema
+func (t Table) historyTable() Table {
+	return Table{Schema: t.Schema, Name: t.Name + "_history"}
+}
+
+// clockIncidentTable 记录时钟异常的表，与worker表位于同一个 schema
+func (t Table) clockIncidentTable() Table {
+	return Table{Schema: t.Schema, Name: t.Name + "_clock_incident"}
+}
+
+// schemaVersionTable 记录迁移版本的表，与worker表位于同一个 schema
+func (t Table) schemaVersionTable() Table {
+	return Table{Schema: t.Schema, Name: t.Name + "_schema_version"}
+}
+
+// Dialect 数据库方言，屏蔽不同数据库在标识符引用、占位符、DDL等方面的差异
+type Dialect interface {
+	// DriverName 默认使用的 database/sql 驱动名
+	DriverName() string
+
+	// Quote 引用标识符，如表名、列名
+	Quote(name string) string
+
+	// QuoteTable 引用表名，Schema 不为空时带上 schema 前缀
+	QuoteTable(table Table) string
+
+	// Placeholder 第 index 个参数的占位符，index 从 1 开始
+	Placeholder(index int) string
+
+	// Now 获取数据库当前时间的表达式
+	Now() string
+
+	// ExistTableSql 查询表是否存在的语句及参数，查询结果为匹配的表数量
+	ExistTableSql(table Table) (string, []interface{})
+
+	// Migrations worker表的迁移脚本，按版本号递增排列，每个版本的语句需可重复执行
+	Migrations(table Table) []Migration
+
+	// CreateSchemaVersionTableSql 创建记录迁移版本的表的语句
+	CreateSchemaVersionTableSql(table Table) string
+
+	// Lock 在 conn 上获取名为 name 的数据库级咨询锁，阻塞直到获取成功或超时
+	Lock(ctx context.Context, conn *sql.Conn, name string) error
+
+	// Unlock 释放 conn 上名为 name 的咨询锁
+	Unlock(ctx context.Context, conn *sql.Conn, name string) error
+
+	// InsertIgnoreSql 批量插入 rowCount 行数据的语句，主键冲突的行忽略
+	InsertIgnoreSql(table Table, columns []string, rowCount int) string
+}
+
+var (
+	dialectLock sync.RWMutex
+
+	dialects = map[string]Dialect{
+		consts.DbTypeMySql:      MySqlDialect{},
+		consts.DbTypeTiDB:       MySqlDialect{},
+		consts.DbTypePostgreSQL: PostgreSqlDialect{},
+		consts.DbTypeSQLite:     SqliteDialect{},
+	}
+)
+
+// RegisterDialect 注册数据库方言，已存在的同名方言会被覆盖
+func RegisterDialect(dbType string, dialect Dialect) {
+	dialectLock.Lock()
+	defer dialectLock.Unlock()
+
+	dialects[strings.ToLower(dbType)] = dialect
+}
+
+// GetDialect 根据数据库类型获取方言
+func GetDialect(dbType string) (Dialect, error) {
+	dialectLock.RLock()
+	defer dialectLock.RUnlock()
+
+	if d, ok := dialects[strings.ToLower(dbType)]; ok {
+		return d, nil
+	}
+	return nil, errors.New("unsupported db type: " + dbType)
+}
+
+// quoteString 以单引号引用字符串字面量，用于无法使用参数的 DDL
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteTable 以 "." 连接 schema 和表名
+func quoteTable(d Dialect, table Table) string {
+	if table.Schema == "" {
+		return d.Quote(table.Name)
+	}
+	return d.Quote(table.Schema) + "." + d.Quote(table.Name)
+}
+
+// insertValuesSql 生成 "INSERT INTO t (c1, c2) VALUES (p1, p2), (p3, p4)" 形式的语句，verb 为 INSERT 关键字部分
+func insertValuesSql(d Dialect, verb string, table Table, columns []string, rowCount int) string {
+	var b strings.Builder
+	b.WriteString(verb + " " + d.QuoteTable(table) + " (")
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(d.Quote(c))
+	}
+	b.WriteString(") VALUES ")
+
+	index := 1
+	for r := 0; r < rowCount; r++ {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString("(")
+		for i := range columns {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(d.Placeholder(index))
+			index++
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
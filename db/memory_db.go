@@ -0,0 +1,436 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/utils"
+)
+
+// MemoryDb 内存实现的IDb，与数据库实现保持相同的乐观锁行为，用于单进程及测试场景
+type MemoryDb struct {
+	tableName    string
+	service      string
+	datacenterId int64
+	clock        utils.Clock
+
+	lock      sync.Mutex
+	exist     bool
+	workers   map[memoryWorkerKey]*model.RaindropWorker
+	idSpaces  map[string]string
+	histories []model.WorkerHistory
+
+	incidents []model.ClockIncident
+}
+
+// memoryWorkerKey worker 在内存数据库中的主键，与数据库表的主键一致
+type memoryWorkerKey struct {
+	service      string
+	datacenterId int64
+	id           int64
+}
+
+// NewMemoryDb 创建内存数据库，clock 为空时使用系统时钟
+func NewMemoryDb(clock utils.Clock) *MemoryDb {
+	if clock == nil {
+		clock = utils.SystemClock{}
+	}
+	return &MemoryDb{
+		clock:    clock,
+		workers:  make(map[memoryWorkerKey]*model.RaindropWorker),
+		idSpaces: make(map[string]string),
+	}
+}
+
+func (m *MemoryDb) InitSql(schema string, tableName string, service string, datacenterId int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.tableName = tableName
+	m.service = service
+	m.datacenterId = datacenterId
+}
+
+// GetNowTime 获取数据库当前时间
+func (m *MemoryDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	return m.clock.Now(), nil
+}
+
+// ExistTable 表是否存在
+func (m *MemoryDb) ExistTable(ctx context.Context) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.exist, nil
+}
+
+// Migrate 内存数据库没有表结构，仅标记表已存在
+func (m *MemoryDb) Migrate(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.exist = true
+	return nil
+}
+
+// SchemaVersion 获取当前及最新的表结构版本，内存数据库仅有一个版本
+func (m *MemoryDb) SchemaVersion(ctx context.Context) (int, int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.exist {
+		return 1, 1, nil
+	}
+	return 0, 1, nil
+}
+
+// RegisterService 登记当前服务占用的id空间
+func (m *MemoryDb) RegisterService(ctx context.Context, idSpace string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if s, ok := m.idSpaces[idSpace]; ok && s != m.service {
+		err := fmt.Errorf("%w. idSpace: %s, service: %s", consts.ErrMsgIdSpaceConflict, idSpace, s)
+		log.Error(ctx, err.Error(), err)
+		return err
+	}
+	m.idSpaces[idSpace] = m.service
+	return nil
+}
+
+// ReconcileWorkers 对账workers
+func (m *MemoryDb) ReconcileWorkers(ctx context.Context, beginId int64, endId int64, disableBefore time.Time) (*model.WorkerReconcileResult, error) {
+	if beginId > endId {
+		err := errors.New("endId must be greater than beginId")
+		log.Error(ctx, err.Error(), err)
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	result := &model.WorkerReconcileResult{
+		Inserted: m.insertWorkers(beginId, endId),
+	}
+	if disableBefore.IsZero() {
+		return result, nil
+	}
+
+	now := m.clock.Now()
+	for _, w := range m.sortedWorkers() {
+		inRange := w.Id >= beginId && w.Id <= endId
+		if !inRange && w.DelFlag == 2 && w.HeartbeatTime.Before(disableBefore) {
+			w.DelFlag = 1
+			result.Disabled++
+		} else if inRange && w.DelFlag == 1 {
+			w.DelFlag = 2
+			result.Enabled++
+		} else {
+			continue
+		}
+		w.Version += 1
+		w.UpdateTime = now
+	}
+	return result, nil
+}
+
+// GetBeforeWorker 找到该节点之前的worker
+func (m *MemoryDb) GetBeforeWorker(ctx context.Context, code string) (*model.RaindropWorker, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, w := range m.sortedWorkers() {
+		if w.DelFlag == 2 && w.Code == code {
+			worker := *w
+			return &worker, nil
+		}
+	}
+	return nil, nil
+}
+
+// QueryFreeWorkers 获取空闲的worker列表
+func (m *MemoryDb) QueryFreeWorkers(ctx context.Context, heartbeatTime time.Time) ([]model.RaindropWorker, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	workers := make([]model.RaindropWorker, 0)
+	for _, w := range m.sortedWorkers() {
+		if w.DelFlag == 2 && w.HeartbeatTime.Before(heartbeatTime) {
+			workers = append(workers, *w)
+		}
+	}
+	sort.SliceStable(workers, func(i, j int) bool {
+		return workers[i].HeartbeatTime.Before(workers[j].HeartbeatTime)
+	})
+	return workers, nil
+}
+
+// ActivateWorker 激活启用worker
+func (m *MemoryDb) ActivateWorker(ctx context.Context, id int64, code string, timeUnit int, layout string, version int64) (*model.RaindropWorker, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w, ok := m.workers[m.key(id)]
+	if !ok || w.DelFlag != 2 || w.Version != version {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.WorkerId(id), logger.Version(version), slog.Int64("count", 0))
+		return nil, nil
+	}
+
+	now := m.clock.Now()
+	w.Code = code
+	w.TimeUnit = consts.TimeUnit(timeUnit)
+	w.Layout = layout
+	w.Version += 1
+	w.HeartbeatTime = now
+	w.UpdateTime = now
+
+	worker := *w
+	return &worker, nil
+}
+
+// ChangeWorkersLayout 修改空闲worker的 id 结构指纹
+func (m *MemoryDb) ChangeWorkersLayout(ctx context.Context, layout string, heartbeatTime time.Time) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.clock.Now()
+	var count int64
+	for _, w := range m.sortedWorkers() {
+		if w.DelFlag != 2 || w.Layout == layout || !w.HeartbeatTime.Before(heartbeatTime) {
+			continue
+		}
+		w.Layout = layout
+		w.Version += 1
+		w.UpdateTime = now
+		count++
+	}
+	return count, nil
+}
+
+// HeartbeatWorker 心跳
+func (m *MemoryDb) HeartbeatWorker(ctx context.Context, worker *model.RaindropWorker) (*model.RaindropWorker, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w, ok := m.workers[m.key(worker.Id)]
+	if !ok || w.DelFlag != 2 || w.Version != worker.Version {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.WorkerId(worker.Id), logger.Version(worker.Version), slog.Int64("count", 0))
+		return nil, consts.ErrMsgWorkerLeaseLost
+	}
+
+	now := m.clock.Now()
+	w.Version += 1
+	w.HeartbeatTime = now
+	w.UpdateTime = now
+
+	result := *w
+	return &result, nil
+}
+
+// GetWorkerById 根据id获取worker
+func (m *MemoryDb) GetWorkerById(ctx context.Context, id int64) (*model.RaindropWorker, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w, ok := m.workers[m.key(id)]
+	if !ok || w.DelFlag != 2 {
+		err := errors.New("worker not found")
+		log.Error(ctx, "get worker by id fail. id: "+strconv.FormatInt(id, 10)+", error: "+err.Error(), err)
+		return nil, err
+	}
+	worker := *w
+	return &worker, nil
+}
+
+// ListWorkers 获取全部worker，包含已删除及已停用的
+func (m *MemoryDb) ListWorkers(ctx context.Context) ([]model.RaindropWorker, error) {
+	return m.Workers(), nil
+}
+
+// DisableWorker 停用worker
+func (m *MemoryDb) DisableWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "disable", id, version, consts.WorkerDelFlagNormal, func(w *model.RaindropWorker) {
+		w.DelFlag = consts.WorkerDelFlagDisabled
+	})
+}
+
+// EnableWorker 启用已停用的worker
+func (m *MemoryDb) EnableWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "enable", id, version, consts.WorkerDelFlagDisabled, func(w *model.RaindropWorker) {
+		w.DelFlag = consts.WorkerDelFlagNormal
+	})
+}
+
+// ReleaseWorker 释放worker，心跳时间置为当前时间，持有节点的租约过期前不会被分配
+func (m *MemoryDb) ReleaseWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "release", id, version, consts.WorkerDelFlagNormal, func(w *model.RaindropWorker) {
+		w.Code = ""
+		w.HeartbeatTime = m.clock.Now()
+	})
+}
+
+// DeleteWorker 删除已停用的worker
+func (m *MemoryDb) DeleteWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "delete", id, version, consts.WorkerDelFlagDisabled, func(w *model.RaindropWorker) {
+		delete(m.workers, m.key(id))
+	})
+}
+
+// AddWorkerHistory 记录worker分配历史
+func (m *MemoryDb) AddWorkerHistory(ctx context.Context, history *model.WorkerHistory) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	history.Service = m.service
+	history.DatacenterId = m.datacenterId
+	m.histories = append(m.histories, *history)
+	return nil
+}
+
+// QueryWorkerHistory 查询worker分配历史
+func (m *MemoryDb) QueryWorkerHistory(ctx context.Context, datacenterId int64, workerId int64) ([]model.WorkerHistory, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	histories := make([]model.WorkerHistory, 0)
+	for _, h := range m.histories {
+		if h.Service == m.service && h.DatacenterId == datacenterId && h.WorkerId == workerId {
+			histories = append(histories, h)
+		}
+	}
+	sort.SliceStable(histories, func(i, j int) bool {
+		return histories[i].EventTime.Before(histories[j].EventTime)
+	})
+	return histories, nil
+}
+
+// AddClockIncident 记录时钟异常
+func (m *MemoryDb) AddClockIncident(ctx context.Context, incident *model.ClockIncident) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	incident.Service = m.service
+	incident.DatacenterId = m.datacenterId
+	m.incidents = append(m.incidents, *incident)
+	return nil
+}
+
+// QueryClockIncidents 查询时钟异常
+func (m *MemoryDb) QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	incidents := make([]model.ClockIncident, 0)
+	for _, c := range m.incidents {
+		if c.Service == m.service && !c.EventTime.Before(begin) && c.EventTime.Before(end) {
+			incidents = append(incidents, c)
+		}
+	}
+	sort.SliceStable(incidents, func(i, j int) bool {
+		return incidents[i].EventTime.Before(incidents[j].EventTime)
+	})
+	return incidents, nil
+}
+
+// Workers 获取当前服务及数据中心全部worker的快照，按id排序
+func (m *MemoryDb) Workers() []model.RaindropWorker {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sorted := m.sortedWorkers()
+	workers := make([]model.RaindropWorker, 0, len(sorted))
+	for _, w := range sorted {
+		workers = append(workers, *w)
+	}
+	return workers
+}
+
+// StealWorker 模拟其他节点抢占worker：修改code、心跳时间并递增版本号
+func (m *MemoryDb) StealWorker(ctx context.Context, id int64, code string) (*model.RaindropWorker, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w, ok := m.workers[m.key(id)]
+	if !ok {
+		return nil, errors.New("worker not found")
+	}
+	now := m.clock.Now()
+	w.Code = code
+	w.Version += 1
+	w.HeartbeatTime = now
+	w.UpdateTime = now
+
+	worker := *w
+	return &worker, nil
+}
+
+// updateWorker 删除标记为 delFlag 且版本号为 version 时执行 f 并递增版本号
+func (m *MemoryDb) updateWorker(ctx context.Context, name string, id int64, version int64, delFlag int, f func(w *model.RaindropWorker)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w, ok := m.workers[m.key(id)]
+	if !ok || w.DelFlag != delFlag || w.Version != version {
+		err := fmt.Errorf("%w. id: %d, version: %d", consts.ErrMsgWorkerVersionConflict, id, version)
+		log.Error(ctx, name+" worker fail", logger.WorkerId(id), logger.Version(version), err)
+		return err
+	}
+	f(w)
+	w.Version += 1
+	w.UpdateTime = m.clock.Now()
+	return nil
+}
+
+// insertWorkers 插入缺失的worker，返回新增数量，调用方需持有锁
+func (m *MemoryDb) insertWorkers(beginId int64, endId int64) int64 {
+	now := m.clock.Now()
+	heartbeatTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local)
+	var count int64
+	for i := beginId; i <= endId; i++ {
+		if _, ok := m.workers[m.key(i)]; ok {
+			continue
+		}
+		m.workers[m.key(i)] = &model.RaindropWorker{
+			Service:       m.service,
+			DatacenterId:  m.datacenterId,
+			Id:            i,
+			Code:          "",
+			TimeUnit:      consts.TimeUnitSecond,
+			HeartbeatTime: heartbeatTime,
+			CreateTime:    now,
+			UpdateTime:    now,
+			Version:       1,
+			DelFlag:       2,
+		}
+		count++
+	}
+	return count
+}
+
+// key 当前服务及数据中心下 id 对应的主键
+func (m *MemoryDb) key(id int64) memoryWorkerKey {
+	return memoryWorkerKey{service: m.service, datacenterId: m.datacenterId, id: id}
+}
+
+// sortedWorkers 当前服务及数据中心按id排序的worker列表，调用方需持有锁
+func (m *MemoryDb) sortedWorkers() []*model.RaindropWorker {
+	workers := make([]*model.RaindropWorker, 0)
+	for k, w := range m.workers {
+		if k.service == m.service && k.datacenterId == m.datacenterId {
+			workers = append(workers, w)
+		}
+	}
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].Id < workers[j].Id
+	})
+	return workers
+}
@@ -0,0 +1,180 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+)
+
+// Migration 表结构迁移，Version 从 1 开始连续递增，已执行的版本记录在同一个 schema 下的 {TableName}_schema_version 表中
+type Migration struct {
+	// Version 版本号
+	Version int
+
+	// Description 描述
+	Description string
+
+	// Sql 迁移语句，需可重复执行
+	Sql []string
+}
+
+// Migrate 对当前数据库执行表结构迁移，适用于禁止运行时执行 DDL、需在部署流程中单独迁移的场景
+func Migrate(ctx context.Context) error {
+	if Db == nil {
+		return consts.ErrMsgDatabaseInitFail
+	}
+	return Db.Migrate(ctx)
+}
+
+// CheckSchemaVersion 校验当前数据库的表结构版本，落后时返回 consts.ErrMsgSchemaVersionOutdated
+func CheckSchemaVersion(ctx context.Context) error {
+	current, latest, err := Db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current < latest {
+		err = fmt.Errorf("%w. current: %d, latest: %d", consts.ErrMsgSchemaVersionOutdated, current, latest)
+		log.Error(ctx, err.Error(), err)
+		return err
+	}
+	return nil
+}
+
+// Migrate 在数据库咨询锁内执行未执行过的迁移，多个节点同时启动时只有一个节点执行
+func (m *SqlDb) Migrate(ctx context.Context) error {
+	migrations := m.dialect.Migrations(m.table)
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			err := errors.New("migration version must start from 1 and be continuous")
+			log.Error(ctx, err.Error(), err)
+			return err
+		}
+	}
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		log.Error(ctx, consts.ErrMsgDatabaseInitTableFail.Error()+": "+err.Error(), err)
+		return err
+	}
+	defer conn.Close()
+
+	lockName := "raindrop_migrate_" + m.table.String()
+	lockCtx, cancel := context.WithTimeout(ctx, time.Duration(consts.DbMigrateLockTimeout)*time.Second)
+	err = m.dialect.Lock(lockCtx, conn, lockName)
+	cancel()
+	if err != nil {
+		log.Error(ctx, "get migrate lock fail: "+err.Error(), err)
+		return err
+	}
+	defer func() {
+		// ctx 已取消时仍需释放锁，释放失败时丢弃该连接，连接关闭后锁随会话释放
+		if e := m.dialect.Unlock(context.Background(), conn, lockName); e != nil {
+			log.Error(ctx, "release migrate lock fail: "+e.Error(), e)
+			conn.Raw(func(interface{}) error {
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	versionTable := m.table.schemaVersionTable()
+	_, err = m.txExec(ctx, conn, m.dialect.CreateSchemaVersionTableSql(versionTable))
+	if err != nil {
+		log.Error(ctx, consts.ErrMsgDatabaseInitTableFail.Error()+": "+err.Error(), err)
+		return err
+	}
+
+	current, err := m.currentSchemaVersion(ctx, conn)
+	if err != nil {
+		log.Error(ctx, "get schema version fail: "+err.Error(), err)
+		return err
+	}
+	if current > len(migrations) {
+		log.Warn(ctx, "schema version "+strconv.Itoa(current)+" is newer than "+strconv.Itoa(len(migrations))+", skip migrate.")
+		return nil
+	}
+
+	for _, migration := range migrations[current:] {
+		err = m.applyMigration(ctx, conn, versionTable, migration)
+		notify(ctx, m.observer, "SchemaMigrated", func(o config.Observer) {
+			o.SchemaMigrated(ctx, m.table.String(), migration.Version, migration.Description, err)
+		})
+		if err != nil {
+			log.Error(ctx, consts.ErrMsgDatabaseInitTableFail.Error()+". version: "+strconv.Itoa(migration.Version)+", error: "+err.Error(), err)
+			return err
+		}
+		log.Info(ctx, "migrate "+m.table.String()+" to version "+strconv.Itoa(migration.Version)+": "+migration.Description)
+	}
+	return nil
+}
+
+// applyMigration 在事务内执行一个版本的迁移并记录版本号，MySql 的 DDL 会隐式提交，因此要求迁移语句可重复执行
+func (m *SqlDb) applyMigration(ctx context.Context, conn *sql.Conn, versionTable Table, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, s := range migration.Sql {
+		_, err = m.txExec(ctx, tx, s)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 没有咨询锁的数据库可能被多个节点同时迁移，版本号冲突时忽略
+	s := m.dialect.InsertIgnoreSql(versionTable, []string{"version", "description", "applied_time"}, 1)
+	_, err = m.txExec(ctx, tx, s, migration.Version, migration.Description, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion 获取当前已执行的迁移版本及最新的迁移版本，版本表不存在时当前版本为 0
+func (m *SqlDb) SchemaVersion(ctx context.Context) (int, int, error) {
+	latest := len(m.dialect.Migrations(m.table))
+
+	s, args := m.dialect.ExistTableSql(m.table.schemaVersionTable())
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := m.db.QueryRowContext(sctx, s, args...).Scan(&count)
+	if err != nil {
+		log.Error(ctx, "get schema version fail: "+err.Error(), err)
+		return 0, latest, err
+	}
+	if count == 0 {
+		return 0, latest, nil
+	}
+
+	current, err := m.currentSchemaVersion(ctx, m.db)
+	if err != nil {
+		log.Error(ctx, "get schema version fail: "+err.Error(), err)
+		return 0, latest, err
+	}
+	return current, latest, nil
+}
+
+// sqlQueryer sql.DB 和 sql.Conn 的公共接口
+type sqlQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// currentSchemaVersion 查询已执行的最大迁移版本
+func (m *SqlDb) currentSchemaVersion(ctx context.Context, conn sqlQueryer) (int, error) {
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var current int
+	err := conn.QueryRowContext(sctx, "SELECT COALESCE(MAX("+m.q("version")+"), 0) FROM "+m.dialect.QuoteTable(m.table.schemaVersionTable())).Scan(&current)
+	return current, err
+}
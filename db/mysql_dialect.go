@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/treeyh/raindrop/consts"
+)
+
+// MySqlDialect MySql、TiDB 方言
+type MySqlDialect struct {
+}
+
+func (d MySqlDialect) DriverName() string {
+	return "mysql"
+}
+
+func (d MySqlDialect) Quote(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (d MySqlDialect) QuoteTable(table Table) string {
+	return quoteTable(d, table)
+}
+
+func (d MySqlDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (d MySqlDialect) Now() string {
+	return "NOW()"
+}
+
+func (d MySqlDialect) ExistTableSql(table Table) (string, []interface{}) {
+	return "SELECT count(*) FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ? AND table_type = ?",
+		[]interface{}{table.Schema, table.Name, "BASE TABLE"}
+}
+
+func (d MySqlDialect) Migrations(table Table) []Migration {
+	return []Migration{
+		{Version: 1, Description: "create worker table", Sql: d.createTableSql(table)},
+		{Version: 2, Description: "add service namespace", Sql: d.addServiceSql(table)},
+		{Version: 3, Description: "add datacenter scope", Sql: d.addScopeColumnSql(table, "datacenter_id",
+			"smallint NOT NULL DEFAULT '0' AFTER `service`", "`service`, `datacenter_id`, `id`")},
+		{Version: 4, Description: "add layout fingerprint", Sql: d.addColumnSql(table, "layout",
+			"varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' AFTER `time_unit`")},
+		{Version: 5, Description: "add worker history", Sql: d.createHistoryTableSql(table)},
+		{Version: 6, Description: "add clock incident", Sql: d.createClockIncidentTableSql(table)},
+		{Version: 7, Description: "rename worker table indexes", Sql: append(
+			d.renameIndexSql(table, "idx_soc_raindrop_worker_heartbeat_time", table.indexName("heartbeat_time")),
+			d.renameIndexSql(table, "idx_soc_raindrop_worker_code", table.indexName("code"))...)},
+	}
+}
+
+// createHistoryTableSql 创建worker分配历史表
+func (d MySqlDialect) createHistoryTableSql(table Table) []string {
+	history := table.historyTable()
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(history) + " (\n" +
+		"\t`id` bigint NOT NULL AUTO_INCREMENT,\n" +
+		"\t`service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`datacenter_id` smallint NOT NULL DEFAULT '0',\n" +
+		"\t`worker_id` bigint NOT NULL,\n" +
+		"\t`version` bigint NOT NULL,\n" +
+		"\t`event` varchar(16) COLLATE utf8mb4_general_ci NOT NULL,\n" +
+		"\t`code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`previous_code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`layout` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`time_seq` bigint NOT NULL DEFAULT '0',\n" +
+		"\t`event_time` datetime(3) NOT NULL,\n" +
+		"\t`create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\tPRIMARY KEY (`id`),\n" +
+		"\tKEY " + d.Quote(history.indexName("worker")) + " (`service`, `datacenter_id`, `worker_id`, `event_time`)\n" +
+		"\t) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;"}
+}
+
+// createClockIncidentTableSql 创建时钟异常表
+func (d MySqlDialect) createClockIncidentTableSql(table Table) []string {
+	incident := table.clockIncidentTable()
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(incident) + " (\n" +
+		"\t`id` bigint NOT NULL AUTO_INCREMENT,\n" +
+		"\t`service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`datacenter_id` smallint NOT NULL DEFAULT '0',\n" +
+		"\t`worker_id` bigint NOT NULL,\n" +
+		"\t`code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`kind` varchar(16) COLLATE utf8mb4_general_ci NOT NULL,\n" +
+		"\t`id_code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`last_time_seq` bigint NOT NULL DEFAULT '0',\n" +
+		"\t`time_seq` bigint NOT NULL DEFAULT '0',\n" +
+		"\t`jump_ms` bigint NOT NULL DEFAULT '0',\n" +
+		"\t`time_back_before` tinyint NOT NULL DEFAULT '0',\n" +
+		"\t`time_back_after` tinyint NOT NULL DEFAULT '0',\n" +
+		"\t`drift_ms` bigint NOT NULL DEFAULT '0',\n" +
+		"\t`count` bigint NOT NULL DEFAULT '1',\n" +
+		"\t`event_time` datetime(3) NOT NULL,\n" +
+		"\t`create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\tPRIMARY KEY (`id`),\n" +
+		"\tKEY " + d.Quote(incident.indexName("time")) + " (`service`, `event_time`)\n" +
+		"\t) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;"}
+}
+
+// addServiceSql 增加 service 列并以 (service, id) 为主键
+func (d MySqlDialect) addServiceSql(table Table) []string {
+	return append(d.addScopeColumnSql(table, "service", "varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' FIRST", "`service`, `id`"),
+		"CREATE TABLE IF NOT EXISTS "+d.QuoteTable(table.serviceTable())+" (\n"+
+			"\t`id_space` varchar(128) COLLATE utf8mb4_general_ci NOT NULL,\n"+
+			"\t`service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n"+
+			"\t`create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n"+
+			"\tPRIMARY KEY (`id_space`)\n"+
+			"\t) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;")
+}
+
+// addScopeColumnSql 增加 worker 所属范围的列并重建主键
+func (d MySqlDialect) addScopeColumnSql(table Table, column string, definition string, primaryKey string) []string {
+	return d.addColumnSql(table, column, definition+", DROP PRIMARY KEY, ADD PRIMARY KEY ("+primaryKey+")")
+}
+
+// addColumnSql 增加列，definition 为列定义及同一语句中的其他修改。MySql 不支持 ADD COLUMN IF NOT EXISTS，列不存在时才执行
+func (d MySqlDialect) addColumnSql(table Table, column string, definition string) []string {
+	alter := "ALTER TABLE " + d.QuoteTable(table) + " ADD COLUMN " + d.Quote(column) + " " + definition
+	return []string{
+		"SET @raindrop_migrate_sql = IF((SELECT count(*) FROM information_schema.columns WHERE table_schema = COALESCE(NULLIF(" +
+			d.quoteString(table.Schema) + ", ''), DATABASE()) AND table_name = " + d.quoteString(table.Name) + " AND column_name = " +
+			d.quoteString(column) + ") = 0, " + d.quoteString(alter) + ", 'DO 0')",
+		"PREPARE raindrop_migrate_stmt FROM @raindrop_migrate_sql",
+		"EXECUTE raindrop_migrate_stmt",
+		"DEALLOCATE PREPARE raindrop_migrate_stmt",
+	}
+}
+
+// renameIndexSql 将 v1 建表时固定的索引名改为由表名生成的索引名。MySql 的 DDL 会隐式提交，原索引存在时才执行以便重复执行
+func (d MySqlDialect) renameIndexSql(table Table, from string, to string) []string {
+	if from == to {
+		return nil
+	}
+	rename := "ALTER TABLE " + d.QuoteTable(table) + " RENAME INDEX " + d.Quote(from) + " TO " + d.Quote(to)
+	return []string{
+		"SET @raindrop_migrate_sql = IF((SELECT count(*) FROM information_schema.statistics WHERE table_schema = COALESCE(NULLIF(" +
+			d.quoteString(table.Schema) + ", ''), DATABASE()) AND table_name = " + d.quoteString(table.Name) + " AND index_name = " +
+			d.quoteString(from) + ") > 0, " + d.quoteString(rename) + ", 'DO 0')",
+		"PREPARE raindrop_migrate_stmt FROM @raindrop_migrate_sql",
+		"EXECUTE raindrop_migrate_stmt",
+		"DEALLOCATE PREPARE raindrop_migrate_stmt",
+	}
+}
+
+// quoteString MySql 默认模式下反斜杠也是转义字符
+func (d MySqlDialect) quoteString(s string) string {
+	return quoteString(strings.ReplaceAll(s, "\\", "\\\\"))
+}
+
+func (d MySqlDialect) createTableSql(table Table) []string {
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(table) + " (\n" +
+		"\t`id` bigint NOT NULL,\n" +
+		"\t`code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`time_unit` tinyint NOT NULL DEFAULT '2',\n" +
+		"\t`heartbeat_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t`create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t`update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,\n" +
+		"\t`version` bigint NOT NULL DEFAULT '1',\n" +
+		"\t`del_flag` tinyint NOT NULL DEFAULT '2',\n" +
+		"\tPRIMARY KEY (`id`),\n" +
+		"\tKEY `idx_soc_raindrop_worker_heartbeat_time` (`heartbeat_time`),\n" +
+		"\tKEY `idx_soc_raindrop_worker_code` (`code`)\n" +
+		"\t) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;"}
+}
+
+func (d MySqlDialect) InsertIgnoreSql(table Table, columns []string, rowCount int) string {
+	return insertValuesSql(d, "INSERT IGNORE INTO", table, columns, rowCount)
+}
+
+func (d MySqlDialect) CreateSchemaVersionTableSql(table Table) string {
+	return "CREATE TABLE IF NOT EXISTS " + d.QuoteTable(table) + " (\n" +
+		"\t`version` int NOT NULL,\n" +
+		"\t`description` varchar(256) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',\n" +
+		"\t`applied_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\tPRIMARY KEY (`version`)\n" +
+		"\t) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;"
+}
+
+func (d MySqlDialect) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	var locked sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", d.lockName(name), consts.DbMigrateLockTimeout).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		return errors.New("get lock timeout: " + name)
+	}
+	return nil
+}
+
+func (d MySqlDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", d.lockName(name))
+	return err
+}
+
+// lockName GET_LOCK 的锁名最长 64 个字符，超长时使用摘要
+func (d MySqlDialect) lockName(name string) string {
+	if len(name) <= 64 {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	return "raindrop_" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/treeyh/raindrop/config"
+)
+
+var (
+	// observer 数据库事件的观察者，下次初始化数据库时传入 RetryDb 及 SqlDb
+	observer config.Observer
+)
+
+// SetObserver 设置数据库重试及表结构迁移事件的观察者，下次初始化数据库时生效，为空时不回调
+func SetObserver(o config.Observer) {
+	observer = o
+}
+
+// notify 同步回调观察者，回调 panic 时恢复并输出错误日志，o 为空时不回调
+func notify(ctx context.Context, o config.Observer, event string, f func(o config.Observer)) {
+	if o == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error(ctx, "observer panic", slog.String("event", event), slog.Any("panic", r))
+		}
+	}()
+	f(o)
+}
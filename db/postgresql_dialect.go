@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// PostgreSqlDialect PostgreSql 方言
+type PostgreSqlDialect struct {
+}
+
+func (d PostgreSqlDialect) DriverName() string {
+	return "pgx"
+}
+
+func (d PostgreSqlDialect) Quote(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
+
+func (d PostgreSqlDialect) QuoteTable(table Table) string {
+	return quoteTable(d, table)
+}
+
+func (d PostgreSqlDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+func (d PostgreSqlDialect) Now() string {
+	return "NOW()"
+}
+
+func (d PostgreSqlDialect) ExistTableSql(table Table) (string, []interface{}) {
+	return "select count(*) from \"pg_tables\" where \"schemaname\" = COALESCE(NULLIF($1, ''), current_schema()) and \"tablename\" = $2",
+		[]interface{}{table.Schema, table.Name}
+}
+
+func (d PostgreSqlDialect) Migrations(table Table) []Migration {
+	t := d.QuoteTable(table)
+	return []Migration{
+		{Version: 1, Description: "create worker table", Sql: d.createTableSql(table)},
+		{Version: 2, Description: "drop unused lang_code column and store del_flag as smallint", Sql: []string{
+			"ALTER TABLE " + t + " DROP COLUMN IF EXISTS \"lang_code\"",
+			"ALTER TABLE " + t + " ALTER COLUMN \"del_flag\" DROP DEFAULT",
+			// 早期的建表脚本中 del_flag 为 bool 类型，true 表示删除
+			"ALTER TABLE " + t + " ALTER COLUMN \"del_flag\" TYPE smallint USING (CASE WHEN \"del_flag\"::text IN ('true', '1') THEN 1 ELSE 2 END)",
+			"ALTER TABLE " + t + " ALTER COLUMN \"del_flag\" SET DEFAULT 2",
+		}},
+		{Version: 3, Description: "add service namespace", Sql: d.addServiceSql(table)},
+		{Version: 4, Description: "add datacenter scope", Sql: append([]string{
+			"ALTER TABLE " + t + " ADD COLUMN IF NOT EXISTS \"datacenter_id\" smallint not null default '0'",
+		}, d.replacePrimaryKeySql(table, "\"service\", \"datacenter_id\", \"id\"")...)},
+		{Version: 5, Description: "add layout fingerprint", Sql: []string{
+			"ALTER TABLE " + t + " ADD COLUMN IF NOT EXISTS \"layout\" varchar(64) not null default ''",
+		}},
+		{Version: 6, Description: "add worker history", Sql: d.createHistoryTableSql(table)},
+		{Version: 7, Description: "add clock incident", Sql: d.createClockIncidentTableSql(table)},
+		{Version: 8, Description: "rename worker table indexes", Sql: append(
+			d.renameIndexSql(table, "idx_soc_raindrop_worker_hb_time", "hb_time", "\"heartbeat_time\""),
+			d.renameIndexSql(table, "idx_soc_raindrop_worker_code", "code", "\"code\"")...)},
+	}
+}
+
+// createHistoryTableSql 创建worker分配历史表
+func (d PostgreSqlDialect) createHistoryTableSql(table Table) []string {
+	history := table.historyTable()
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(history) + " (\n" +
+		"\t\"id\"                   bigserial            not null,\n" +
+		"\t\"service\"              varchar(128)         not null default '',\n" +
+		"\t\"datacenter_id\"        smallint             not null default '0',\n" +
+		"\t\"worker_id\"            bigint               not null,\n" +
+		"\t\"version\"              bigint               not null,\n" +
+		"\t\"event\"                varchar(16)          not null,\n" +
+		"\t\"code\"                 varchar(128)         not null default '',\n" +
+		"\t\"previous_code\"        varchar(128)         not null default '',\n" +
+		"\t\"layout\"               varchar(64)          not null default '',\n" +
+		"\t\"time_seq\"             bigint               not null default '0',\n" +
+		"\t\"event_time\"           TIMESTAMP WITH TIME ZONE not null,\n" +
+		"\t\"create_time\"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n" +
+		"\tconstraint " + d.Quote("PK_"+history.Name) + " primary key (\"id\")\n" +
+		"\t)",
+		"CREATE INDEX IF NOT EXISTS " + d.Quote(history.indexName("worker")) + " on " + d.QuoteTable(history) + " (\n" +
+			"\t\"service\", \"datacenter_id\", \"worker_id\", \"event_time\"\n" +
+			"\t)",
+	}
+}
+
+// createClockIncidentTableSql 创建时钟异常表
+func (d PostgreSqlDialect) createClockIncidentTableSql(table Table) []string {
+	incident := table.clockIncidentTable()
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(incident) + " (\n" +
+		"\t\"id\"                   bigserial            not null,\n" +
+		"\t\"service\"              varchar(128)         not null default '',\n" +
+		"\t\"datacenter_id\"        smallint             not null default '0',\n" +
+		"\t\"worker_id\"            bigint               not null,\n" +
+		"\t\"code\"                 varchar(128)         not null default '',\n" +
+		"\t\"kind\"                 varchar(16)          not null,\n" +
+		"\t\"id_code\"              varchar(128)         not null default '',\n" +
+		"\t\"last_time_seq\"        bigint               not null default '0',\n" +
+		"\t\"time_seq\"             bigint               not null default '0',\n" +
+		"\t\"jump_ms\"              bigint               not null default '0',\n" +
+		"\t\"time_back_before\"     smallint             not null default '0',\n" +
+		"\t\"time_back_after\"      smallint             not null default '0',\n" +
+		"\t\"drift_ms\"             bigint               not null default '0',\n" +
+		"\t\"count\"                bigint               not null default '1',\n" +
+		"\t\"event_time\"           TIMESTAMP WITH TIME ZONE not null,\n" +
+		"\t\"create_time\"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n" +
+		"\tconstraint " + d.Quote("PK_"+incident.Name) + " primary key (\"id\")\n" +
+		"\t)",
+		"CREATE INDEX IF NOT EXISTS " + d.Quote(incident.indexName("time")) + " on " + d.QuoteTable(incident) + " (\n" +
+			"\t\"service\", \"event_time\"\n" +
+			"\t)",
+	}
+}
+
+// addServiceSql 增加 service 列并以 (service, id) 为主键
+func (d PostgreSqlDialect) addServiceSql(table Table) []string {
+	s := []string{"ALTER TABLE " + d.QuoteTable(table) + " ADD COLUMN IF NOT EXISTS \"service\" varchar(128) not null default ''"}
+	s = append(s, d.replacePrimaryKeySql(table, "\"service\", \"id\"")...)
+	return append(s, "CREATE TABLE IF NOT EXISTS "+d.QuoteTable(table.serviceTable())+" (\n"+
+		"\t\"id_space\"             varchar(128)         not null,\n"+
+		"\t\"service\"              varchar(128)         not null default '',\n"+
+		"\t\"create_time\"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n"+
+		"\tconstraint "+d.Quote("PK_"+table.serviceTable().Name)+" primary key (\"id_space\")\n"+
+		"\t)")
+}
+
+// renameIndexSql 将 v1 建表时固定的索引名改为由表名生成的索引名。索引名在 schema 内唯一，同一个 schema 下的其他表
+// 可能已占用原索引名，仅重命名属于该表的索引，并补建 v1 中因重名被跳过的索引
+func (d PostgreSqlDialect) renameIndexSql(table Table, from string, suffix string, columns string) []string {
+	to := table.indexName(suffix)
+	var s []string
+	if from != to {
+		s = append(s, "DO $$ BEGIN "+
+			"IF EXISTS (SELECT 1 FROM pg_indexes WHERE schemaname = COALESCE(NULLIF("+quoteString(table.Schema)+", ''), current_schema()) "+
+			"AND tablename = "+quoteString(table.Name)+" AND indexname = "+quoteString(from)+") THEN "+
+			"EXECUTE "+quoteString("ALTER INDEX "+d.QuoteTable(Table{Schema: table.Schema, Name: from})+" RENAME TO "+d.Quote(to))+"; END IF; END $$")
+	}
+	return append(s, "CREATE INDEX IF NOT EXISTS "+d.Quote(to)+" on "+d.QuoteTable(table)+" ("+columns+")")
+}
+
+// replacePrimaryKeySql 删除现有主键并以 columns 重建。早期的建表脚本中主键约束名与表名不一致，按类型查找主键约束
+func (d PostgreSqlDialect) replacePrimaryKeySql(table Table, columns string) []string {
+	t := d.QuoteTable(table)
+	return []string{
+		"DO $$ DECLARE c text; BEGIN " +
+			"SELECT conname INTO c FROM pg_constraint WHERE conrelid = " + quoteString(t) + "::regclass AND contype = 'p'; " +
+			"IF c IS NOT NULL THEN EXECUTE " + quoteString("ALTER TABLE "+t+" DROP CONSTRAINT ") + " || quote_ident(c); END IF; END $$",
+		"ALTER TABLE " + t + " ADD CONSTRAINT " + d.Quote("PK_"+table.Name) + " primary key (" + columns + ")",
+	}
+}
+
+func (d PostgreSqlDialect) createTableSql(table Table) []string {
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(table) + " (\n" +
+		"\t\"id\"                   bigint               not null,\n" +
+		"\t\"code\"                 varchar(128)         not null default '',\n" +
+		"\t\"time_unit\"            smallint             not null default '2',\n" +
+		"\t\"heartbeat_time\"       TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n" +
+		"\t\"create_time\"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n" +
+		"\t\"update_time\"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n" +
+		"\t\"version\"              bigint               not null default '1',\n" +
+		"\t\"del_flag\"             smallint                 not null default '2',\n" +
+		"\tconstraint " + d.Quote("PK_"+table.Name) + " primary key (\"id\")\n" +
+		"\t)",
+		"CREATE INDEX IF NOT EXISTS \"idx_soc_raindrop_worker_hb_time\" on " + d.QuoteTable(table) + " (\n" +
+			"\t\"heartbeat_time\"\n" +
+			"\t)",
+		"CREATE INDEX IF NOT EXISTS \"idx_soc_raindrop_worker_code\" on " + d.QuoteTable(table) + " (\n" +
+			"\t\"code\"\n" +
+			"\t)",
+	}
+}
+
+func (d PostgreSqlDialect) InsertIgnoreSql(table Table, columns []string, rowCount int) string {
+	return insertValuesSql(d, "INSERT INTO", table, columns, rowCount) + " ON CONFLICT DO NOTHING"
+}
+
+func (d PostgreSqlDialect) CreateSchemaVersionTableSql(table Table) string {
+	return "CREATE TABLE IF NOT EXISTS " + d.QuoteTable(table) + " (\n" +
+		"\t\"version\"              integer              not null,\n" +
+		"\t\"description\"          varchar(256)         not null default '',\n" +
+		"\t\"applied_time\"         TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,\n" +
+		"\tconstraint " + d.Quote("PK_"+table.Name) + " primary key (\"version\")\n" +
+		"\t)"
+}
+
+func (d PostgreSqlDialect) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", d.lockKey(name))
+	return err
+}
+
+func (d PostgreSqlDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", d.lockKey(name))
+	return err
+}
+
+// lockKey pg_advisory_lock 的锁为 bigint，由锁名的哈希值得到
+func (d PostgreSqlDialect) lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
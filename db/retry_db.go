@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+)
+
+const (
+	// heartbeatRetryWindow 心跳重试的总时长上限，远小于 worker 被视为空闲的心跳间隔，避免重试掩盖租约丢失
+	heartbeatRetryWindow = time.Duration(consts.HeartbeatTimeInterval) * time.Second / 2
+)
+
+// RetryDb 按重试策略重试IDb调用中的临时错误
+type RetryDb struct {
+	d      IDb
+	policy config.RetryPolicy
+	// observer 重试及重试后仍失败时回调的观察者，可为空
+	observer config.Observer
+}
+
+// NewRetryDb 创建带重试的IDb，policy.Retryable 为空时使用 IsRetryableError，o 为重试事件的观察者，可为空
+func NewRetryDb(d IDb, policy config.RetryPolicy, o config.Observer) *RetryDb {
+	return &RetryDb{
+		d:        d,
+		policy:   retryPolicy(policy),
+		observer: o,
+	}
+}
+
+// Unwrap 获取被包装的IDb
+func (r *RetryDb) Unwrap() IDb {
+	return r.d
+}
+
+// Close 关闭被包装的IDb
+func (r *RetryDb) Close() error {
+	if c, ok := r.d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (r *RetryDb) InitSql(schema string, tableName string, service string, datacenterId int64) {
+	r.d.InitSql(schema, tableName, service, datacenterId)
+}
+
+// GetNowTime 获取数据库当前时间
+func (r *RetryDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	return retry(ctx, r.policy, r.observer, "GetNowTime", 0, func() (time.Time, error) {
+		return r.d.GetNowTime(ctx)
+	})
+}
+
+// ExistTable 表是否存在
+func (r *RetryDb) ExistTable(ctx context.Context) (bool, error) {
+	return retry(ctx, r.policy, r.observer, "ExistTable", 0, func() (bool, error) {
+		return r.d.ExistTable(ctx)
+	})
+}
+
+// Migrate 表结构迁移，每个版本执行后才记录版本号且迁移语句可重复执行，可整体重试
+func (r *RetryDb) Migrate(ctx context.Context) error {
+	_, err := retry(ctx, r.policy, r.observer, "Migrate", 0, func() (bool, error) {
+		return true, r.d.Migrate(ctx)
+	})
+	return err
+}
+
+// SchemaVersion 获取当前及最新的表结构版本
+func (r *RetryDb) SchemaVersion(ctx context.Context) (int, int, error) {
+	var latest int
+	current, err := retry(ctx, r.policy, r.observer, "SchemaVersion", 0, func() (int, error) {
+		c, l, e := r.d.SchemaVersion(ctx)
+		latest = l
+		return c, e
+	})
+	return current, latest, err
+}
+
+// RegisterService 登记id空间，插入为幂等操作，可整体重试
+func (r *RetryDb) RegisterService(ctx context.Context, idSpace string) error {
+	_, err := retry(ctx, r.policy, r.observer, "RegisterService", 0, func() (bool, error) {
+		return true, r.d.RegisterService(ctx, idSpace)
+	})
+	return err
+}
+
+// ReconcileWorkers 对账workers，插入与更新均为幂等操作，可整体重试
+func (r *RetryDb) ReconcileWorkers(ctx context.Context, beginId int64, endId int64, disableBefore time.Time) (*model.WorkerReconcileResult, error) {
+	return retry(ctx, r.policy, r.observer, "ReconcileWorkers", 0, func() (*model.WorkerReconcileResult, error) {
+		return r.d.ReconcileWorkers(ctx, beginId, endId, disableBefore)
+	})
+}
+
+// GetBeforeWorker 找到该节点之前的worker
+func (r *RetryDb) GetBeforeWorker(ctx context.Context, code string) (*model.RaindropWorker, error) {
+	return retry(ctx, r.policy, r.observer, "GetBeforeWorker", 0, func() (*model.RaindropWorker, error) {
+		return r.d.GetBeforeWorker(ctx, code)
+	})
+}
+
+// QueryFreeWorkers 查询空闲的workers
+func (r *RetryDb) QueryFreeWorkers(ctx context.Context, heartbeatTime time.Time) ([]model.RaindropWorker, error) {
+	return retry(ctx, r.policy, r.observer, "QueryFreeWorkers", 0, func() ([]model.RaindropWorker, error) {
+		return r.d.QueryFreeWorkers(ctx, heartbeatTime)
+	})
+}
+
+// ActivateWorker 激活启用worker，上次尝试可能已在数据库生效，重试前先确认
+func (r *RetryDb) ActivateWorker(ctx context.Context, id int64, code string, timeUnit int, layout string, version int64) (*model.RaindropWorker, error) {
+	attempt := 0
+	return retry(ctx, r.policy, r.observer, "ActivateWorker", 0, func() (*model.RaindropWorker, error) {
+		attempt++
+		w, err := r.d.ActivateWorker(ctx, id, code, timeUnit, layout, version)
+		if w == nil && err == nil && attempt > 1 {
+			return r.appliedWorker(ctx, id, code, version)
+		}
+		return w, err
+	})
+}
+
+// ChangeWorkersLayout 修改worker的 id 结构指纹，条件更新可整体重试
+func (r *RetryDb) ChangeWorkersLayout(ctx context.Context, layout string, heartbeatTime time.Time) (int64, error) {
+	return retry(ctx, r.policy, r.observer, "ChangeWorkersLayout", 0, func() (int64, error) {
+		return r.d.ChangeWorkersLayout(ctx, layout, heartbeatTime)
+	})
+}
+
+// HeartbeatWorker 心跳，重试总时长不超过 heartbeatRetryWindow，上次尝试可能已在数据库生效，重试前先确认
+func (r *RetryDb) HeartbeatWorker(ctx context.Context, worker *model.RaindropWorker) (*model.RaindropWorker, error) {
+	attempt := 0
+	return retry(ctx, r.policy, r.observer, "HeartbeatWorker", heartbeatRetryWindow, func() (*model.RaindropWorker, error) {
+		attempt++
+		w, err := r.d.HeartbeatWorker(ctx, worker)
+		if errors.Is(err, consts.ErrMsgWorkerLeaseLost) && attempt > 1 {
+			if applied, e := r.appliedWorker(ctx, worker.Id, worker.Code, worker.Version); e == nil && applied != nil {
+				return applied, nil
+			}
+		}
+		return w, err
+	})
+}
+
+// GetWorkerById 根据id获取worker
+func (r *RetryDb) GetWorkerById(ctx context.Context, id int64) (*model.RaindropWorker, error) {
+	return retry(ctx, r.policy, r.observer, "GetWorkerById", 0, func() (*model.RaindropWorker, error) {
+		return r.d.GetWorkerById(ctx, id)
+	})
+}
+
+// ListWorkers 获取全部worker
+func (r *RetryDb) ListWorkers(ctx context.Context) ([]model.RaindropWorker, error) {
+	return retry(ctx, r.policy, r.observer, "ListWorkers", 0, func() ([]model.RaindropWorker, error) {
+		return r.d.ListWorkers(ctx)
+	})
+}
+
+// DisableWorker 停用worker，上次尝试已生效时重试返回 consts.ErrMsgWorkerVersionConflict，需重新获取worker确认
+func (r *RetryDb) DisableWorker(ctx context.Context, id int64, version int64) error {
+	_, err := retry(ctx, r.policy, r.observer, "DisableWorker", 0, func() (bool, error) {
+		return true, r.d.DisableWorker(ctx, id, version)
+	})
+	return err
+}
+
+// EnableWorker 启用worker，上次尝试已生效时重试返回 consts.ErrMsgWorkerVersionConflict，需重新获取worker确认
+func (r *RetryDb) EnableWorker(ctx context.Context, id int64, version int64) error {
+	_, err := retry(ctx, r.policy, r.observer, "EnableWorker", 0, func() (bool, error) {
+		return true, r.d.EnableWorker(ctx, id, version)
+	})
+	return err
+}
+
+// ReleaseWorker 释放worker，上次尝试已生效时重试返回 consts.ErrMsgWorkerVersionConflict，需重新获取worker确认
+func (r *RetryDb) ReleaseWorker(ctx context.Context, id int64, version int64) error {
+	_, err := retry(ctx, r.policy, r.observer, "ReleaseWorker", 0, func() (bool, error) {
+		return true, r.d.ReleaseWorker(ctx, id, version)
+	})
+	return err
+}
+
+// DeleteWorker 删除worker，上次尝试已生效时重试返回 consts.ErrMsgWorkerVersionConflict，需重新获取worker确认
+func (r *RetryDb) DeleteWorker(ctx context.Context, id int64, version int64) error {
+	_, err := retry(ctx, r.policy, r.observer, "DeleteWorker", 0, func() (bool, error) {
+		return true, r.d.DeleteWorker(ctx, id, version)
+	})
+	return err
+}
+
+// AddWorkerHistory 记录worker分配历史，上次尝试已生效时重试会重复记录，不影响查询结果
+func (r *RetryDb) AddWorkerHistory(ctx context.Context, history *model.WorkerHistory) error {
+	_, err := retry(ctx, r.policy, r.observer, "AddWorkerHistory", 0, func() (bool, error) {
+		return true, r.d.AddWorkerHistory(ctx, history)
+	})
+	return err
+}
+
+// QueryWorkerHistory 查询worker分配历史
+func (r *RetryDb) QueryWorkerHistory(ctx context.Context, datacenterId int64, workerId int64) ([]model.WorkerHistory, error) {
+	return retry(ctx, r.policy, r.observer, "QueryWorkerHistory", 0, func() ([]model.WorkerHistory, error) {
+		return r.d.QueryWorkerHistory(ctx, datacenterId, workerId)
+	})
+}
+
+// AddClockIncident 记录时钟异常，上次尝试已生效时重试会重复记录
+func (r *RetryDb) AddClockIncident(ctx context.Context, incident *model.ClockIncident) error {
+	_, err := retry(ctx, r.policy, r.observer, "AddClockIncident", 0, func() (bool, error) {
+		return true, r.d.AddClockIncident(ctx, incident)
+	})
+	return err
+}
+
+// QueryClockIncidents 查询时钟异常
+func (r *RetryDb) QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error) {
+	return retry(ctx, r.policy, r.observer, "QueryClockIncidents", 0, func() ([]model.ClockIncident, error) {
+		return r.d.QueryClockIncidents(ctx, begin, end)
+	})
+}
+
+// appliedWorker 判断上一次失败的更新是否已经生效：code 一致且版本号恰好加 1
+func (r *RetryDb) appliedWorker(ctx context.Context, id int64, code string, version int64) (*model.RaindropWorker, error) {
+	w, err := r.d.GetWorkerById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if w != nil && w.Code == code && w.Version == version+1 {
+		return w, nil
+	}
+	return nil, nil
+}
+
+// retryPolicy 未配置重试时返回只尝试一次的策略
+func retryPolicy(policy config.RetryPolicy) config.RetryPolicy {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.Retryable == nil {
+		policy.Retryable = IsRetryableError
+	}
+	return policy
+}
+
+// retry 按策略执行 f，window 大于 0 时限制重试的总时长，重试及临时错误最终失败时回调观察者 o
+func retry[T any](ctx context.Context, policy config.RetryPolicy, o config.Observer, name string, window time.Duration, f func() (T, error)) (T, error) {
+	start := time.Now()
+	backoff := policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		v, err := f()
+		if err == nil || !policy.Retryable(err) {
+			return v, err
+		}
+		if attempt >= policy.MaxAttempts || ctx.Err() != nil {
+			notifyRetryFailed(ctx, o, name, attempt, err)
+			return v, err
+		}
+
+		wait := jitter(backoff, policy.Jitter)
+		if window > 0 && time.Since(start)+wait > window {
+			notifyRetryFailed(ctx, o, name, attempt, err)
+			return v, err
+		}
+		log.Warn(ctx, "db "+name+" fail, retry", slog.Int("attempt", attempt+1), slog.Duration("wait", wait), err)
+		notify(ctx, o, "DbRetry", func(o config.Observer) {
+			o.DbRetry(ctx, name, attempt, wait, err)
+		})
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			notifyRetryFailed(ctx, o, name, attempt, err)
+			return v, err
+		case <-timer.C:
+		}
+
+		backoff = time.Duration(float64(backoff) * policy.Multiplier)
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// notifyRetryFailed 临时错误在尝试 attempts 次后仍失败时回调观察者
+func notifyRetryFailed(ctx context.Context, o config.Observer, name string, attempts int, err error) {
+	notify(ctx, o, "DbRetryFailed", func(o config.Observer) {
+		o.DbRetryFailed(ctx, name, attempts, err)
+	})
+}
+
+// jitter 在 d 的基础上增加 ±ratio 比例的随机抖动
+func jitter(d time.Duration, ratio float64) time.Duration {
+	if ratio <= 0 {
+		return d
+	}
+	delta := float64(d) * ratio
+	return time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+}
+
+// IsRetryableError 判断是否为可重试的临时错误：死锁、锁等待超时、序列化失败、连接断开或重置
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, consts.ErrMsgWorkerLeaseLost) || errors.Is(err, consts.ErrMsgWorkerVersionConflict) {
+		return false
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case 1205, 1213, 1040, 1053, 2006, 2013:
+			// 锁等待超时、死锁、连接数过多、服务关闭中、服务已断开、查询中断开连接
+			return true
+		}
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "40001", "40P01", "55P03", "57P01", "57P02", "57P03", "53300":
+			// 序列化失败、死锁、锁不可用、管理员关闭、崩溃关闭、暂不可连接、连接数过多
+			return true
+		}
+		// 08 类为连接异常
+		return len(pgErr.Code) == 5 && pgErr.Code[:2] == "08"
+	}
+
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
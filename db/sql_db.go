@@ -0,0 +1,663 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/model"
+)
+
+const (
+	// insertBatchSize 初始化worker时每批插入的行数
+	insertBatchSize = 200
+)
+
+var (
+	// workerColumns worker表的列
+	workerColumns = []string{"service", "datacenter_id", "id", "code", "time_unit", "layout", "heartbeat_time", "create_time", "update_time", "version", "del_flag"}
+
+	// historyColumns worker分配历史表的列，不含自增的 id 及 create_time
+	historyColumns = []string{"service", "datacenter_id", "worker_id", "version", "event", "code", "previous_code", "layout", "time_seq", "event_time"}
+	// clockIncidentColumns 时钟异常表的列，不含自增的 id 及 create_time
+	clockIncidentColumns = []string{"service", "datacenter_id", "worker_id", "code", "kind", "id_code", "last_time_seq", "time_seq",
+		"jump_ms", "time_back_before", "time_back_after", "drift_ms", "count", "event_time"}
+
+	// initHeartbeatTime 初始化worker的心跳时间
+	initHeartbeatTime = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// SqlDb 基于 database/sql 和方言实现的IDb
+type SqlDb struct {
+	db      *sql.DB
+	dialect Dialect
+
+	// ownDb db 是否由 raindrop 创建，仅关闭 raindrop 创建的连接池
+	ownDb bool
+	// ownPool raindrop 创建的 pgxpool
+	ownPool *pgxpool.Pool
+	// statementTimeout 单条语句超时时间，0 不限制
+	statementTimeout time.Duration
+	// observer 表结构迁移事件的观察者，可为空
+	observer config.Observer
+
+	table        Table
+	service      string
+	datacenterId int64
+	// selectSql 查询所属范围内的全部worker，preSelectSql 仅查询正常的worker
+	selectSql    string
+	preSelectSql string
+}
+
+// NewSqlDb 创建基于 database/sql 的IDb，db 由调用方负责关闭
+func NewSqlDb(db *sql.DB, dialect Dialect) *SqlDb {
+	return &SqlDb{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// SetStatementTimeout 设置单条语句的超时时间，0 不限制
+func (m *SqlDb) SetStatementTimeout(timeout time.Duration) {
+	m.statementTimeout = timeout
+}
+
+// SetObserver 设置表结构迁移事件的观察者，为空时不回调
+func (m *SqlDb) SetObserver(o config.Observer) {
+	m.observer = o
+}
+
+// Close 关闭由 raindrop 创建的连接池，调用方提供的连接池不会被关闭
+func (m *SqlDb) Close() error {
+	var err error
+	if m.ownDb {
+		err = m.db.Close()
+	}
+	if m.ownPool != nil {
+		m.ownPool.Close()
+	}
+	return err
+}
+
+func (m *SqlDb) InitSql(schema string, tableName string, service string, datacenterId int64) {
+	m.table = Table{Schema: schema, Name: tableName}
+	m.service = service
+	m.datacenterId = datacenterId
+	m.selectSql = "SELECT "
+	for i, c := range workerColumns {
+		if i > 0 {
+			m.selectSql += ", "
+		}
+		m.selectSql += m.q(c)
+	}
+	// 第 1、2 个参数为 worker 所属范围
+	m.selectSql += " FROM " + m.dialect.QuoteTable(m.table) + " WHERE " + m.scopeSql(1) + " "
+	m.preSelectSql = m.selectSql + "AND " + m.q("del_flag") + " = 2 "
+}
+
+// scopeSql 限定 worker 所属的服务命名空间及数据中心，占用第 index、index+1 个参数，参数为 scopeArgs
+func (m *SqlDb) scopeSql(index int) string {
+	return m.q("service") + " = " + m.p(index) + " AND " + m.q("datacenter_id") + " = " + m.p(index+1)
+}
+
+// scopeArgs scopeSql 的参数
+func (m *SqlDb) scopeArgs(args ...interface{}) []interface{} {
+	return append(args, m.service, m.datacenterId)
+}
+
+// q 引用标识符
+func (m *SqlDb) q(name string) string {
+	return m.dialect.Quote(name)
+}
+
+// p 第 index 个参数占位符
+func (m *SqlDb) p(index int) string {
+	return m.dialect.Placeholder(index)
+}
+
+// withTimeout 为单条语句设置超时时间
+func (m *SqlDb) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.statementTimeout > 0 {
+		return context.WithTimeout(ctx, m.statementTimeout)
+	}
+	return ctx, func() {}
+}
+
+// GetNowTime 获取数据库当前时间
+func (m *SqlDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var now time.Time
+	err := m.db.QueryRowContext(sctx, "SELECT "+m.dialect.Now()).Scan(dbTime{&now})
+
+	if err != nil {
+		log.Error(ctx, consts.ErrMsgDatabaseGetNowTimeFail.Error()+": "+err.Error(), err)
+		return time.Now(), err
+	}
+	return now, err
+}
+
+// ExistTable 表是否存在
+func (m *SqlDb) ExistTable(ctx context.Context) (bool, error) {
+	s, args := m.dialect.ExistTableSql(m.table)
+
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	err := m.db.QueryRowContext(sctx, s, args...).Scan(&count)
+	if err != nil {
+		log.Error(ctx, err.Error(), err)
+		return false, err
+	}
+
+	return count == 1, nil
+}
+
+// RegisterService 登记当前服务占用的id空间，id空间已登记时不覆盖，登记的服务与当前服务不一致时返回 consts.ErrMsgIdSpaceConflict
+func (m *SqlDb) RegisterService(ctx context.Context, idSpace string) error {
+	serviceTable := m.table.serviceTable()
+	s := m.dialect.InsertIgnoreSql(serviceTable, []string{"id_space", "service", "create_time"}, 1)
+	_, err := m.exec(ctx, s, idSpace, m.service, time.Now().UTC())
+	if err != nil {
+		log.Error(ctx, "register service fail: "+err.Error(), err)
+		return err
+	}
+
+	s = "SELECT " + m.q("service") + " FROM " + m.dialect.QuoteTable(serviceTable) + " WHERE " + m.q("id_space") + " = " + m.p(1)
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var service string
+	err = m.db.QueryRowContext(sctx, s, idSpace).Scan(&service)
+	if err != nil {
+		log.Error(ctx, "register service fail: "+err.Error(), err)
+		return err
+	}
+	if service != m.service {
+		err = fmt.Errorf("%w. idSpace: %s, service: %s", consts.ErrMsgIdSpaceConflict, idSpace, service)
+		log.Error(ctx, err.Error(), err)
+		return err
+	}
+	return nil
+}
+
+// ReconcileWorkers 对账workers，范围内的worker已齐全时不执行插入；各语句均为幂等的条件更新，多个节点同时启动时是安全的
+func (m *SqlDb) ReconcileWorkers(ctx context.Context, beginId int64, endId int64, disableBefore time.Time) (*model.WorkerReconcileResult, error) {
+	if beginId > endId {
+		err := errors.New("endId must be greater than beginId")
+		log.Error(ctx, err.Error(), err)
+		return nil, err
+	}
+
+	result := &model.WorkerReconcileResult{}
+
+	s := "SELECT count(*) FROM " + m.dialect.QuoteTable(m.table) + " WHERE " + m.q("id") + " >= " + m.p(1) + " AND " + m.q("id") + " <= " + m.p(2) +
+		" AND " + m.scopeSql(3)
+	sctx, cancel := m.withTimeout(ctx)
+	var count int64
+	err := m.db.QueryRowContext(sctx, s, m.scopeArgs(beginId, endId)...).Scan(&count)
+	cancel()
+	if err != nil {
+		log.Error(ctx, "count workers fail: "+err.Error(), err)
+		return nil, err
+	}
+	if count < endId-beginId+1 {
+		result.Inserted, err = m.insertWorkers(ctx, m.db, beginId, endId)
+		if err != nil {
+			log.Error(ctx, "insert workers fail: "+err.Error(), err)
+			return nil, err
+		}
+	}
+
+	if disableBefore.IsZero() {
+		return result, nil
+	}
+
+	// 仅标记心跳已过期的范围外worker，避免影响仍在使用旧配置运行的节点
+	s = "UPDATE " + m.dialect.QuoteTable(m.table) + " SET " + m.q("del_flag") + " = 1, " + m.q("version") + " = " + m.q("version") + " + 1, " +
+		m.q("update_time") + " = " + m.dialect.Now() + " WHERE (" + m.q("id") + " < " + m.p(1) + " OR " + m.q("id") + " > " + m.p(2) + ") AND " +
+		m.q("del_flag") + " = 2 AND " + m.q("heartbeat_time") + " < " + m.p(3) + " AND " + m.scopeSql(4)
+	r, err := m.exec(ctx, s, m.scopeArgs(beginId, endId, disableBefore.UTC())...)
+	if err == nil {
+		result.Disabled, err = r.RowsAffected()
+	}
+	if err != nil {
+		log.Error(ctx, "disable workers fail: "+err.Error(), err)
+		return nil, err
+	}
+
+	s = "UPDATE " + m.dialect.QuoteTable(m.table) + " SET " + m.q("del_flag") + " = 2, " + m.q("version") + " = " + m.q("version") + " + 1, " +
+		m.q("update_time") + " = " + m.dialect.Now() + " WHERE " + m.q("id") + " >= " + m.p(1) + " AND " + m.q("id") + " <= " + m.p(2) + " AND " +
+		m.q("del_flag") + " = 1 AND " + m.scopeSql(3)
+	r, err = m.exec(ctx, s, m.scopeArgs(beginId, endId)...)
+	if err == nil {
+		result.Enabled, err = r.RowsAffected()
+	}
+	if err != nil {
+		log.Error(ctx, "enable workers fail: "+err.Error(), err)
+		return nil, err
+	}
+	return result, nil
+}
+
+// insertWorkers 分批插入 [beginId, endId] 范围内的worker，已存在的忽略，返回新增数量
+func (m *SqlDb) insertWorkers(ctx context.Context, e sqlExecer, beginId int64, endId int64) (int64, error) {
+	columns := []string{"service", "datacenter_id", "id", "heartbeat_time"}
+	var inserted int64
+	for start := beginId; start <= endId; start += insertBatchSize {
+		end := start + insertBatchSize - 1
+		if end > endId {
+			end = endId
+		}
+		args := make([]interface{}, 0, (end-start+1)*4)
+		for i := start; i <= end; i++ {
+			args = append(args, m.service, m.datacenterId, i, initHeartbeatTime)
+		}
+		r, err := m.txExec(ctx, e, m.dialect.InsertIgnoreSql(m.table, columns, int(end-start+1)), args...)
+		if err != nil {
+			return inserted, err
+		}
+		count, err := r.RowsAffected()
+		if err != nil {
+			return inserted, err
+		}
+		inserted += count
+	}
+	return inserted, nil
+}
+
+// GetBeforeWorker 找到该节点之前的worker
+func (m *SqlDb) GetBeforeWorker(ctx context.Context, code string) (*model.RaindropWorker, error) {
+	s := m.preSelectSql + "AND " + m.q("code") + " = " + m.p(3) + " ORDER BY " + m.q("id") + " ASC "
+	workers, err := m.queryWorkers(ctx, s, append(m.scopeArgs(), code)...)
+	if err != nil {
+		log.Error(ctx, "find before worker fail: "+err.Error(), err)
+		return nil, err
+	}
+	if len(workers) == 0 {
+		return nil, nil
+	}
+	return &workers[0], nil
+}
+
+// QueryFreeWorkers 获取空闲的worker列表
+func (m *SqlDb) QueryFreeWorkers(ctx context.Context, heartbeatTime time.Time) ([]model.RaindropWorker, error) {
+	s := m.preSelectSql + "AND " + m.q("heartbeat_time") + " < " + m.p(3) + " ORDER BY " + m.q("heartbeat_time") + " ASC "
+	workers, err := m.queryWorkers(ctx, s, append(m.scopeArgs(), heartbeatTime.UTC())...)
+	if err != nil {
+		log.Error(ctx, "query workers fail: "+err.Error(), err)
+		return nil, err
+	}
+	return workers, nil
+}
+
+// ActivateWorker 激活启用worker
+func (m *SqlDb) ActivateWorker(ctx context.Context, id int64, code string, timeUnit int, layout string, version int64) (*model.RaindropWorker, error) {
+	s := "UPDATE " + m.dialect.QuoteTable(m.table) + " SET " + m.q("code") + " = " + m.p(1) + ", " + m.q("time_unit") + " = " + m.p(2) + ", " +
+		m.q("layout") + " = " + m.p(3) + ", " + m.q("version") + " = " + m.q("version") + " + 1, " + m.q("heartbeat_time") + " = " + m.p(4) + ", " +
+		m.q("update_time") + " = " + m.dialect.Now() + " WHERE " + m.q("id") + " = " + m.p(5) + " AND " + m.q("version") + " = " + m.p(6) +
+		" AND " + m.scopeSql(7)
+
+	result, err := m.exec(ctx, s, m.scopeArgs(code, timeUnit, layout, time.Now().UTC(), id, version)...)
+	if err != nil {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.Table(m.table.String()), err)
+		return nil, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.Table(m.table.String()), err)
+		return nil, err
+	}
+	if count != 1 {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.WorkerId(id), logger.Version(version), slog.Int64("count", count))
+		return nil, nil
+	}
+
+	worker, err := m.GetWorkerById(ctx, id)
+	if err != nil {
+		log.Error(ctx, err.Error(), err)
+		return &model.RaindropWorker{
+			Service:       m.service,
+			DatacenterId:  m.datacenterId,
+			Id:            id,
+			Code:          code,
+			TimeUnit:      consts.TimeUnit(timeUnit),
+			Layout:        layout,
+			HeartbeatTime: time.Now(),
+			CreateTime:    time.Now(),
+			UpdateTime:    time.Now(),
+			Version:       version + 1,
+			DelFlag:       2,
+		}, err
+	}
+
+	return worker, nil
+}
+
+// ChangeWorkersLayout 修改空闲worker的 id 结构指纹，并递增版本号
+func (m *SqlDb) ChangeWorkersLayout(ctx context.Context, layout string, heartbeatTime time.Time) (int64, error) {
+	s := "UPDATE " + m.dialect.QuoteTable(m.table) + " SET " + m.q("layout") + " = " + m.p(1) + ", " + m.q("version") + " = " + m.q("version") + " + 1, " +
+		m.q("update_time") + " = " + m.dialect.Now() + " WHERE " + m.q("layout") + " <> " + m.p(2) + " AND " + m.q("heartbeat_time") + " < " + m.p(3) +
+		" AND " + m.q("del_flag") + " = 2 AND " + m.scopeSql(4)
+
+	result, err := m.exec(ctx, s, m.scopeArgs(layout, layout, heartbeatTime.UTC())...)
+	if err != nil {
+		log.Error(ctx, "change workers layout fail: "+err.Error(), err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// HeartbeatWorker 心跳
+func (m *SqlDb) HeartbeatWorker(ctx context.Context, worker *model.RaindropWorker) (*model.RaindropWorker, error) {
+	s := "UPDATE " + m.dialect.QuoteTable(m.table) + " SET " + m.q("version") + " = " + m.q("version") + " + 1, " +
+		m.q("heartbeat_time") + " = " + m.p(1) + ", " + m.q("update_time") + " = " + m.dialect.Now() +
+		" WHERE " + m.q("id") + " = " + m.p(2) + " AND " + m.q("version") + " = " + m.p(3) + " AND " + m.scopeSql(4)
+
+	result, err := m.exec(ctx, s, m.scopeArgs(time.Now().UTC(), worker.Id, worker.Version)...)
+	if err != nil {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.Table(m.table.String()), err)
+		return nil, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.Table(m.table.String()), err)
+		return nil, err
+	}
+	if count != 1 {
+		log.Error(ctx, "heartbeat worker fail!!!", logger.WorkerId(worker.Id), logger.Version(worker.Version), slog.Int64("count", count))
+		return nil, consts.ErrMsgWorkerLeaseLost
+	}
+
+	w, _ := m.GetWorkerById(ctx, worker.Id)
+
+	if w != nil {
+		return w, nil
+	}
+	worker.Version += 1
+	return worker, nil
+}
+
+// GetWorkerById 根据id获取worker
+func (m *SqlDb) GetWorkerById(ctx context.Context, id int64) (*model.RaindropWorker, error) {
+	s := m.preSelectSql + "AND " + m.q("id") + " = " + m.p(3)
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var worker model.RaindropWorker
+	err := scanWorker(m.db.QueryRowContext(sctx, s, append(m.scopeArgs(), id)...), &worker)
+	if err != nil {
+		log.Error(ctx, "get worker by id fail. id: "+strconv.FormatInt(id, 10)+", error: "+err.Error(), err)
+		return nil, err
+	}
+
+	return &worker, nil
+}
+
+// ListWorkers 获取全部worker，包含已删除及已停用的
+func (m *SqlDb) ListWorkers(ctx context.Context) ([]model.RaindropWorker, error) {
+	s := m.selectSql + "ORDER BY " + m.q("id") + " ASC "
+	workers, err := m.queryWorkers(ctx, s, m.scopeArgs()...)
+	if err != nil {
+		log.Error(ctx, "list workers fail: "+err.Error(), err)
+		return nil, err
+	}
+	return workers, nil
+}
+
+// DisableWorker 停用worker，持有该worker的节点下次心跳时因版本号变化失去租约
+func (m *SqlDb) DisableWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "disable", m.q("del_flag")+" = 3", m.q("del_flag")+" = 2", id, version)
+}
+
+// EnableWorker 启用已停用的worker
+func (m *SqlDb) EnableWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "enable", m.q("del_flag")+" = 2", m.q("del_flag")+" = 3", id, version)
+}
+
+// ReleaseWorker 释放worker，递增的版本号作为隔离令牌，持有该worker的节点下次心跳时失去租约。
+// 心跳时间置为当前时间，持有节点的租约过期前不会被分配
+func (m *SqlDb) ReleaseWorker(ctx context.Context, id int64, version int64) error {
+	return m.updateWorker(ctx, "release", m.q("code")+" = '', "+m.q("heartbeat_time")+" = "+m.p(1), m.q("del_flag")+" = 2", id, version, time.Now().UTC())
+}
+
+// DeleteWorker 删除已停用的worker
+func (m *SqlDb) DeleteWorker(ctx context.Context, id int64, version int64) error {
+	s := "DELETE FROM " + m.dialect.QuoteTable(m.table) + " WHERE " + m.q("del_flag") + " = 3 AND " + m.q("id") + " = " + m.p(1) +
+		" AND " + m.q("version") + " = " + m.p(2) + " AND " + m.scopeSql(3)
+	result, err := m.exec(ctx, s, m.scopeArgs(id, version)...)
+	return m.checkWorkerUpdated(ctx, "delete", result, err, id, version)
+}
+
+// AddWorkerHistory 记录worker分配历史
+func (m *SqlDb) AddWorkerHistory(ctx context.Context, history *model.WorkerHistory) error {
+	history.Service = m.service
+	history.DatacenterId = m.datacenterId
+	s := insertValuesSql(m.dialect, "INSERT INTO", m.table.historyTable(), historyColumns, 1)
+	_, err := m.exec(ctx, s, history.Service, history.DatacenterId, history.WorkerId, history.Version, history.Event, history.Code,
+		history.PreviousCode, history.Layout, history.TimeSeq, history.EventTime.UTC())
+	if err != nil {
+		log.Error(ctx, "add worker history fail: "+err.Error(), err)
+		return err
+	}
+	return nil
+}
+
+// QueryWorkerHistory 查询worker分配历史
+func (m *SqlDb) QueryWorkerHistory(ctx context.Context, datacenterId int64, workerId int64) ([]model.WorkerHistory, error) {
+	s := "SELECT "
+	for i, c := range historyColumns {
+		if i > 0 {
+			s += ", "
+		}
+		s += m.q(c)
+	}
+	s += " FROM " + m.dialect.QuoteTable(m.table.historyTable()) + " WHERE " + m.q("service") + " = " + m.p(1) + " AND " +
+		m.q("datacenter_id") + " = " + m.p(2) + " AND " + m.q("worker_id") + " = " + m.p(3) + " ORDER BY " + m.q("event_time") + " ASC, " + m.q("id") + " ASC "
+
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	rows, err := m.db.QueryContext(sctx, s, m.service, datacenterId, workerId)
+	if err != nil {
+		log.Error(ctx, "query worker history fail: "+err.Error(), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	histories := make([]model.WorkerHistory, 0)
+	for rows.Next() {
+		var h model.WorkerHistory
+		err = rows.Scan(&h.Service, &h.DatacenterId, &h.WorkerId, &h.Version, &h.Event, &h.Code, &h.PreviousCode, &h.Layout, &h.TimeSeq, dbTime{&h.EventTime})
+		if err != nil {
+			log.Error(ctx, "query worker history fail: "+err.Error(), err)
+			return nil, err
+		}
+		histories = append(histories, h)
+	}
+	return histories, rows.Err()
+}
+
+// AddClockIncident 记录时钟异常
+func (m *SqlDb) AddClockIncident(ctx context.Context, incident *model.ClockIncident) error {
+	incident.Service = m.service
+	incident.DatacenterId = m.datacenterId
+	s := insertValuesSql(m.dialect, "INSERT INTO", m.table.clockIncidentTable(), clockIncidentColumns, 1)
+	_, err := m.exec(ctx, s, incident.Service, incident.DatacenterId, incident.WorkerId, incident.Code, incident.Kind, incident.IdCode,
+		incident.LastTimeSeq, incident.TimeSeq, incident.Jump.Milliseconds(), incident.TimeBackBefore, incident.TimeBackAfter,
+		incident.Drift.Milliseconds(), incident.Count, incident.EventTime.UTC())
+	if err != nil {
+		log.Error(ctx, "add clock incident fail: "+err.Error(), err)
+		return err
+	}
+	return nil
+}
+
+// QueryClockIncidents 查询时钟异常
+func (m *SqlDb) QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error) {
+	s := "SELECT "
+	for i, c := range clockIncidentColumns {
+		if i > 0 {
+			s += ", "
+		}
+		s += m.q(c)
+	}
+	s += " FROM " + m.dialect.QuoteTable(m.table.clockIncidentTable()) + " WHERE " + m.q("service") + " = " + m.p(1) + " AND " +
+		m.q("event_time") + " >= " + m.p(2) + " AND " + m.q("event_time") + " < " + m.p(3) + " ORDER BY " + m.q("event_time") + " ASC, " + m.q("id") + " ASC "
+
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+	rows, err := m.db.QueryContext(sctx, s, m.service, begin.UTC(), end.UTC())
+	if err != nil {
+		log.Error(ctx, "query clock incidents fail: "+err.Error(), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	incidents := make([]model.ClockIncident, 0)
+	for rows.Next() {
+		var c model.ClockIncident
+		var jump, drift int64
+		err = rows.Scan(&c.Service, &c.DatacenterId, &c.WorkerId, &c.Code, &c.Kind, &c.IdCode, &c.LastTimeSeq, &c.TimeSeq,
+			&jump, &c.TimeBackBefore, &c.TimeBackAfter, &drift, &c.Count, dbTime{&c.EventTime})
+		if err != nil {
+			log.Error(ctx, "query clock incidents fail: "+err.Error(), err)
+			return nil, err
+		}
+		c.Jump = time.Duration(jump) * time.Millisecond
+		c.Drift = time.Duration(drift) * time.Millisecond
+		incidents = append(incidents, c)
+	}
+	return incidents, rows.Err()
+}
+
+// updateWorker 按乐观锁更新worker并递增版本号，set、where 中的参数从第 1 个开始，参数为 args
+func (m *SqlDb) updateWorker(ctx context.Context, name string, set string, where string, id int64, version int64, args ...interface{}) error {
+	n := len(args)
+	s := "UPDATE " + m.dialect.QuoteTable(m.table) + " SET " + set + ", " + m.q("version") + " = " + m.q("version") + " + 1, " +
+		m.q("update_time") + " = " + m.dialect.Now() + " WHERE " + where + " AND " + m.q("id") + " = " + m.p(n+1) +
+		" AND " + m.q("version") + " = " + m.p(n+2) + " AND " + m.scopeSql(n+3)
+	result, err := m.exec(ctx, s, m.scopeArgs(append(args, id, version)...)...)
+	return m.checkWorkerUpdated(ctx, name, result, err, id, version)
+}
+
+// checkWorkerUpdated 校验按乐观锁更新的worker恰好为 1 行
+func (m *SqlDb) checkWorkerUpdated(ctx context.Context, name string, result sql.Result, err error, id int64, version int64) error {
+	var count int64
+	if err == nil {
+		count, err = result.RowsAffected()
+	}
+	if err != nil {
+		log.Error(ctx, name+" worker fail", logger.WorkerId(id), logger.Version(version), err)
+		return err
+	}
+	if count != 1 {
+		err = fmt.Errorf("%w. id: %d, version: %d", consts.ErrMsgWorkerVersionConflict, id, version)
+		log.Error(ctx, name+" worker fail", logger.WorkerId(id), logger.Version(version), err)
+		return err
+	}
+	return nil
+}
+
+// queryWorkers 查询worker列表
+func (m *SqlDb) queryWorkers(ctx context.Context, s string, args ...interface{}) ([]model.RaindropWorker, error) {
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(sctx, s, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := make([]model.RaindropWorker, 0)
+	for rows.Next() {
+		var worker model.RaindropWorker
+		err = scanWorker(rows, &worker)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, worker)
+	}
+	return workers, rows.Err()
+}
+
+// exec 执行单条语句
+func (m *SqlDb) exec(ctx context.Context, s string, args ...interface{}) (sql.Result, error) {
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.db.ExecContext(sctx, s, args...)
+}
+
+// sqlExecer sql.DB 和 sql.Tx 的公共接口
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// txExec 在事务或连接池上执行单条语句
+func (m *SqlDb) txExec(ctx context.Context, tx sqlExecer, s string, args ...interface{}) (sql.Result, error) {
+	sctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return tx.ExecContext(sctx, s, args...)
+}
+
+// rowScanner sql.Row 和 sql.Rows 的公共接口
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanWorker 按 workerColumns 的顺序读取worker
+func scanWorker(row rowScanner, worker *model.RaindropWorker) error {
+	return row.Scan(&worker.Service, &worker.DatacenterId, &worker.Id, &worker.Code, &worker.TimeUnit, &worker.Layout, dbTime{&worker.HeartbeatTime},
+		dbTime{&worker.CreateTime}, dbTime{&worker.UpdateTime}, &worker.Version, &worker.DelFlag)
+}
+
+// dbTime 兼容驱动以 time.Time 或字符串形式返回的时间列
+type dbTime struct {
+	t *time.Time
+}
+
+var dbTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+func (d dbTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case time.Time:
+		*d.t = v
+		return nil
+	case []byte:
+		return d.parse(string(v))
+	case string:
+		return d.parse(v)
+	case nil:
+		*d.t = time.Time{}
+		return nil
+	}
+	return errors.New("unsupported time value type")
+}
+
+func (d dbTime) parse(s string) error {
+	for _, layout := range dbTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			*d.t = t
+			return nil
+		}
+	}
+	return errors.New("unsupported time value: " + s)
+}
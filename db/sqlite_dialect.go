@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// SqliteDialect SQLite 方言，驱动需由调用方引入，默认驱动名为 sqlite
+type SqliteDialect struct {
+}
+
+func (d SqliteDialect) DriverName() string {
+	return "sqlite"
+}
+
+func (d SqliteDialect) Quote(name string) string {
+	return "\"" + strings.ReplaceAll(name, "\"", "\"\"") + "\""
+}
+
+func (d SqliteDialect) QuoteTable(table Table) string {
+	return quoteTable(d, table)
+}
+
+func (d SqliteDialect) Placeholder(index int) string {
+	return "?"
+}
+
+func (d SqliteDialect) Now() string {
+	return "CURRENT_TIMESTAMP"
+}
+
+// ExistTableSql Schema 为 ATTACH 的数据库名
+func (d SqliteDialect) ExistTableSql(table Table) (string, []interface{}) {
+	return "SELECT count(*) FROM " + d.QuoteTable(Table{Schema: table.Schema, Name: "sqlite_master"}) + " WHERE type = ? AND name = ?",
+		[]interface{}{"table", table.Name}
+}
+
+func (d SqliteDialect) Migrations(table Table) []Migration {
+	return []Migration{
+		{Version: 1, Description: "create worker table", Sql: d.createTableSql(table)},
+		{Version: 2, Description: "add service namespace", Sql: append(d.rebuildTableSql(table, []string{"service"}),
+			"CREATE TABLE IF NOT EXISTS "+d.QuoteTable(table.serviceTable())+" (\n"+
+				"\t\"id_space\"    VARCHAR(128) NOT NULL PRIMARY KEY,\n"+
+				"\t\"service\"     VARCHAR(128) NOT NULL DEFAULT '',\n"+
+				"\t\"create_time\" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP\n"+
+				"\t)")},
+		{Version: 3, Description: "add datacenter scope", Sql: d.rebuildTableSql(table, []string{"service", "datacenter_id"})},
+		{Version: 4, Description: "add layout fingerprint", Sql: []string{
+			"ALTER TABLE " + d.QuoteTable(table) + " ADD COLUMN \"layout\" VARCHAR(64) NOT NULL DEFAULT ''",
+		}},
+		{Version: 5, Description: "add worker history", Sql: d.createHistoryTableSql(table)},
+		{Version: 6, Description: "add clock incident", Sql: d.createClockIncidentTableSql(table)},
+	}
+}
+
+// createHistoryTableSql 创建worker分配历史表
+func (d SqliteDialect) createHistoryTableSql(table Table) []string {
+	history := table.historyTable()
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(history) + " (\n" +
+		"\t\"id\"             INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,\n" +
+		"\t\"service\"        VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"datacenter_id\"  INTEGER      NOT NULL DEFAULT 0,\n" +
+		"\t\"worker_id\"      INTEGER      NOT NULL,\n" +
+		"\t\"version\"        BIGINT       NOT NULL,\n" +
+		"\t\"event\"          VARCHAR(16)  NOT NULL,\n" +
+		"\t\"code\"           VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"previous_code\"  VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"layout\"         VARCHAR(64)  NOT NULL DEFAULT '',\n" +
+		"\t\"time_seq\"       BIGINT       NOT NULL DEFAULT 0,\n" +
+		"\t\"event_time\"     DATETIME     NOT NULL,\n" +
+		"\t\"create_time\"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP\n" +
+		"\t)",
+		"CREATE INDEX IF NOT EXISTS " + d.QuoteTable(Table{Schema: table.Schema, Name: history.indexName("worker")}) + " ON " + d.Quote(history.Name) +
+			" (\"service\", \"datacenter_id\", \"worker_id\", \"event_time\")",
+	}
+}
+
+// createClockIncidentTableSql 创建时钟异常表
+func (d SqliteDialect) createClockIncidentTableSql(table Table) []string {
+	incident := table.clockIncidentTable()
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(incident) + " (\n" +
+		"\t\"id\"               INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,\n" +
+		"\t\"service\"          VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"datacenter_id\"    INTEGER      NOT NULL DEFAULT 0,\n" +
+		"\t\"worker_id\"        INTEGER      NOT NULL,\n" +
+		"\t\"code\"             VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"kind\"             VARCHAR(16)  NOT NULL,\n" +
+		"\t\"id_code\"          VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"last_time_seq\"    BIGINT       NOT NULL DEFAULT 0,\n" +
+		"\t\"time_seq\"         BIGINT       NOT NULL DEFAULT 0,\n" +
+		"\t\"jump_ms\"          BIGINT       NOT NULL DEFAULT 0,\n" +
+		"\t\"time_back_before\" INTEGER      NOT NULL DEFAULT 0,\n" +
+		"\t\"time_back_after\"  INTEGER      NOT NULL DEFAULT 0,\n" +
+		"\t\"drift_ms\"         BIGINT       NOT NULL DEFAULT 0,\n" +
+		"\t\"count\"            BIGINT       NOT NULL DEFAULT 1,\n" +
+		"\t\"event_time\"       DATETIME     NOT NULL,\n" +
+		"\t\"create_time\"      DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP\n" +
+		"\t)",
+		"CREATE INDEX IF NOT EXISTS " + d.QuoteTable(Table{Schema: table.Schema, Name: incident.indexName("time")}) + " ON " + d.Quote(incident.Name) +
+			" (\"service\", \"event_time\")",
+	}
+}
+
+// sqliteScopeColumns worker 所属范围（服务命名空间、数据中心）的列定义
+var sqliteScopeColumns = map[string]string{
+	"service":       "\t\"service\"        VARCHAR(128) NOT NULL DEFAULT '',\n",
+	"datacenter_id": "\t\"datacenter_id\"  INTEGER      NOT NULL DEFAULT 0,\n",
+}
+
+// rebuildTableSql 以 scopes 加 id 为主键重建表，SQLite 不支持修改主键。scopes 的最后一列为新增列，其余列从原表复制
+func (d SqliteDialect) rebuildTableSql(table Table, scopes []string) []string {
+	tmp := Table{Schema: table.Schema, Name: table.Name + "_tmp"}
+	columns := "\"id\", \"code\", \"time_unit\", \"heartbeat_time\", \"create_time\", \"update_time\", \"version\", \"del_flag\""
+	create := "CREATE TABLE " + d.QuoteTable(tmp) + " (\n"
+	primaryKey := ""
+	for i, scope := range scopes {
+		create += sqliteScopeColumns[scope]
+		primaryKey += d.Quote(scope) + ", "
+		if i < len(scopes)-1 {
+			columns = d.Quote(scope) + ", " + columns
+		}
+	}
+	create += "\t\"id\"             INTEGER      NOT NULL,\n" +
+		"\t\"code\"           VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"time_unit\"      SMALLINT     NOT NULL DEFAULT 2,\n" +
+		"\t\"heartbeat_time\" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t\"create_time\"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t\"update_time\"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t\"version\"        BIGINT       NOT NULL DEFAULT 1,\n" +
+		"\t\"del_flag\"       SMALLINT     NOT NULL DEFAULT 2,\n" +
+		"\tPRIMARY KEY (" + primaryKey + "\"id\")\n" +
+		"\t)"
+	return append([]string{
+		"DROP TABLE IF EXISTS " + d.QuoteTable(tmp),
+		create,
+		"INSERT INTO " + d.QuoteTable(tmp) + " (" + columns + ") SELECT " + columns + " FROM " + d.QuoteTable(table),
+		"DROP TABLE " + d.QuoteTable(table),
+		"ALTER TABLE " + d.QuoteTable(tmp) + " RENAME TO " + d.Quote(table.Name),
+	}, d.createTableSql(table)[1:]...)
+}
+
+func (d SqliteDialect) createTableSql(table Table) []string {
+	return []string{"CREATE TABLE IF NOT EXISTS " + d.QuoteTable(table) + " (\n" +
+		"\t\"id\"             INTEGER      NOT NULL PRIMARY KEY,\n" +
+		"\t\"code\"           VARCHAR(128) NOT NULL DEFAULT '',\n" +
+		"\t\"time_unit\"      SMALLINT     NOT NULL DEFAULT 2,\n" +
+		"\t\"heartbeat_time\" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t\"create_time\"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t\"update_time\"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,\n" +
+		"\t\"version\"        BIGINT       NOT NULL DEFAULT 1,\n" +
+		"\t\"del_flag\"       SMALLINT     NOT NULL DEFAULT 2\n" +
+		"\t)",
+		// SQLite 的索引与表位于同一个数据库，schema 前缀加在索引名上
+		"CREATE INDEX IF NOT EXISTS " + d.QuoteTable(Table{Schema: table.Schema, Name: table.indexName("hb_time")}) + " ON " + d.Quote(table.Name) + " (\"heartbeat_time\")",
+		"CREATE INDEX IF NOT EXISTS " + d.QuoteTable(Table{Schema: table.Schema, Name: table.indexName("code")}) + " ON " + d.Quote(table.Name) + " (\"code\")",
+	}
+}
+
+func (d SqliteDialect) InsertIgnoreSql(table Table, columns []string, rowCount int) string {
+	return insertValuesSql(d, "INSERT OR IGNORE INTO", table, columns, rowCount)
+}
+
+func (d SqliteDialect) CreateSchemaVersionTableSql(table Table) string {
+	return "CREATE TABLE IF NOT EXISTS " + d.QuoteTable(table) + " (\n" +
+		"\t\"version\"      INTEGER      NOT NULL PRIMARY KEY,\n" +
+		"\t\"description\"  VARCHAR(256) NOT NULL DEFAULT '',\n" +
+		"\t\"applied_time\" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP\n" +
+		"\t)"
+}
+
+// Lock SQLite 没有咨询锁，写操作本身是串行的，迁移语句均可重复执行
+func (d SqliteDialect) Lock(ctx context.Context, conn *sql.Conn, name string) error {
+	return nil
+}
+
+func (d SqliteDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	return nil
+}
@@ -5,6 +5,7 @@ go 1.24
 require (
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/jackc/pgx/v5 v5.5.5
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
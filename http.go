@@ -0,0 +1,42 @@
+package raindrop
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/treeyh/raindrop/model"
+)
+
+// Handler 健康检查的 http.Handler，可通过 http.StripPrefix 挂载到任意路径：
+// /livez 存活检查，租约丢失、已过期或因时间偏差释放worker时返回 503，需重启服务重新分配worker；
+// /readyz 就绪检查，存活检查不通过、未初始化或与DB、NTP服务器的时间偏差超过阈值时返回 503；
+// /status 返回运行状态。均以 JSON 返回 Status
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		status := Status(r.Context())
+		writeStatus(w, r, status, status.Live)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := Status(r.Context())
+		writeStatus(w, r, status, status.Ready)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, r, Status(r.Context()), true)
+	})
+	return mux
+}
+
+// writeStatus 以 JSON 输出运行状态，ok 为 false 时返回 503
+func writeStatus(w http.ResponseWriter, r *http.Request, status model.Status, ok bool) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	err := json.NewEncoder(w).Encode(status)
+	if err != nil && log != nil {
+		log.Error(r.Context(), "write status fail: "+err.Error(), err)
+	}
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"log/slog"
+)
+
+// 结构化日志的属性名
+const (
+	KeyWorkerId     = "worker_id"
+	KeyDatacenterId = "datacenter_id"
+	KeyVersion      = "version"
+	KeyCode         = "code"
+	KeyIdCode       = "id_code"
+	KeyTimeSeq      = "time_seq"
+	KeyTable        = "table"
+	KeyErr          = "err"
+)
+
+// WorkerId worker id 属性
+func WorkerId(id int64) slog.Attr {
+	return slog.Int64(KeyWorkerId, id)
+}
+
+// DatacenterId 数据中心 id 属性
+func DatacenterId(id int64) slog.Attr {
+	return slog.Int64(KeyDatacenterId, id)
+}
+
+// Version worker 版本号属性
+func Version(version int64) slog.Attr {
+	return slog.Int64(KeyVersion, version)
+}
+
+// Code 持有worker的节点属性
+func Code(code string) slog.Attr {
+	return slog.String(KeyCode, code)
+}
+
+// IdCode NewIdByCode 的 code 属性
+func IdCode(idCode string) slog.Attr {
+	return slog.String(KeyIdCode, idCode)
+}
+
+// TimeSeq 时间戳流水属性
+func TimeSeq(timeSeq int64) slog.Attr {
+	return slog.Int64(KeyTimeSeq, timeSeq)
+}
+
+// Table 数据库表名属性
+func Table(table string) slog.Attr {
+	return slog.String(KeyTable, table)
+}
+
+// Err 错误属性，err 为空时属性值为空
+func Err(err error) slog.Attr {
+	return slog.Any(KeyErr, err)
+}
+
+// toAttrs 将日志参数转换为结构化属性：slog.Attr 原样保留，error 转换为 err 属性，
+// 字符串与其后的参数组成键值对，其余参数以 data 为属性名，空值忽略
+func toAttrs(data []interface{}) []slog.Attr {
+	attrs := make([]slog.Attr, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch v := data[i].(type) {
+		case nil:
+		case slog.Attr:
+			attrs = append(attrs, v)
+		case error:
+			attrs = append(attrs, Err(v))
+		case string:
+			if i+1 < len(data) {
+				attrs = append(attrs, slog.Any(v, data[i+1]))
+				i++
+			} else {
+				attrs = append(attrs, slog.String("data", v))
+			}
+		default:
+			attrs = append(attrs, slog.Any("data", v))
+		}
+	}
+	return attrs
+}
@@ -51,7 +51,7 @@ type DefaultWriter struct {
 }
 
 func (dw *DefaultWriter) Printf(ctx context.Context, msg string, data ...interface{}) {
-	fmt.Println(append([]interface{}{msg}, data...))
+	fmt.Println(append([]interface{}{msg}, data...)...)
 }
 
 func New(writer IWriter, logLevel LogLevel, colorful bool) ILogger {
@@ -83,9 +83,9 @@ func New(writer IWriter, logLevel LogLevel, colorful bool) ILogger {
 	}
 }
 
+// NewDefault 默认日志，通过 slog.Default() 输出结构化日志，参见 NewSlog
 func NewDefault() ILogger {
-	d := DefaultWriter{}
-	return New(&d, Info, true)
+	return NewSlog(nil)
 }
 
 type _logger struct {
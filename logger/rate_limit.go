@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimitBurst 每条消息连续输出的默认最大条数
+	DefaultRateLimitBurst = 10
+
+	// DefaultRateLimitInterval 默认每秒补充一个令牌
+	DefaultRateLimitInterval = time.Second
+
+	// DefaultRateLimitMaxKeys 默认限流的消息数量上限
+	DefaultRateLimitMaxKeys = 1024
+
+	// KeySuppressed 被抑制的相同消息数量的属性名
+	KeySuppressed = "suppressed"
+
+	// KeySuppressedMsg 汇总日志中被抑制消息的属性名
+	KeySuppressedMsg = "suppressed_msg"
+)
+
+// RateLimit 日志限流配置，按日志级别及消息分别使用令牌桶限流
+type RateLimit struct {
+	// Burst 每条消息连续输出的最大条数，即令牌桶容量，默认 DefaultRateLimitBurst，小于 0 时不限流
+	Burst int `json:"burst" yaml:"burst" env:"BURST"`
+
+	// Interval 每隔 Interval 补充一个令牌，默认 DefaultRateLimitInterval
+	Interval time.Duration `json:"interval" yaml:"interval" env:"INTERVAL"`
+
+	// MaxKeys 限流的消息数量上限，超过后新的消息不限流，默认 DefaultRateLimitMaxKeys
+	MaxKeys int `json:"maxKeys" yaml:"maxKeys" env:"MAX_KEYS"`
+}
+
+// rateKey 限流的消息
+type rateKey struct {
+	level LogLevel
+	msg   string
+}
+
+// rateBucket 消息的令牌桶
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+
+	// suppressed 上次输出后被抑制的条数
+	suppressed int64
+}
+
+// rateLimiter 各消息的令牌桶，LogMode 创建的日志共用
+type rateLimiter struct {
+	limit   RateLimit
+	lock    sync.Mutex
+	buckets map[rateKey]*rateBucket
+}
+
+// RateLimitedLogger 限流的日志，相同级别及消息的日志按令牌桶限流，Fatal 不限流。被抑制的条数在该消息下次输出时
+// 以 suppressed 属性输出，或由 Flush 输出 "suppressed N similar messages" 汇总
+type RateLimitedLogger struct {
+	ILogger
+	limiter *rateLimiter
+}
+
+// NewRateLimited 创建限流的日志，limit 中为 0 的配置使用默认值
+func NewRateLimited(l ILogger, limit RateLimit) *RateLimitedLogger {
+	if limit.Burst == 0 {
+		limit.Burst = DefaultRateLimitBurst
+	}
+	if limit.Interval <= 0 {
+		limit.Interval = DefaultRateLimitInterval
+	}
+	if limit.MaxKeys <= 0 {
+		limit.MaxKeys = DefaultRateLimitMaxKeys
+	}
+	return &RateLimitedLogger{
+		ILogger: l,
+		limiter: &rateLimiter{
+			limit:   limit,
+			buckets: make(map[rateKey]*rateBucket),
+		},
+	}
+}
+
+// allow 消耗一个令牌，返回是否输出及上次输出后被抑制的条数
+func (r *rateLimiter) allow(level LogLevel, msg string) (bool, int64) {
+	if r.limit.Burst < 0 {
+		return true, 0
+	}
+	now := time.Now()
+	key := rateKey{level: level, msg: msg}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	b, ok := r.buckets[key]
+	if !ok {
+		if len(r.buckets) >= r.limit.MaxKeys {
+			return true, 0
+		}
+		b = &rateBucket{tokens: float64(r.limit.Burst), last: now}
+		r.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(r.limit.Interval)
+	if b.tokens > float64(r.limit.Burst) {
+		b.tokens = float64(r.limit.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.suppressed++
+		return false, 0
+	}
+	b.tokens--
+	suppressed := b.suppressed
+	b.suppressed = 0
+	return true, suppressed
+}
+
+// LogMode 设置最低日志级别，与原日志共用令牌桶
+func (r *RateLimitedLogger) LogMode(level LogLevel) ILogger {
+	return &RateLimitedLogger{
+		ILogger: r.ILogger.LogMode(level),
+		limiter: r.limiter,
+	}
+}
+
+// log 未被限流时输出日志
+func (r *RateLimitedLogger) log(ctx context.Context, level LogLevel, f func(context.Context, string, ...interface{}), msg string, data []interface{}) {
+	ok, suppressed := r.limiter.allow(level, msg)
+	if !ok {
+		return
+	}
+	if suppressed > 0 {
+		data = append(data, slog.Int64(KeySuppressed, suppressed))
+	}
+	f(ctx, msg, data...)
+}
+
+func (r *RateLimitedLogger) Debug(ctx context.Context, msg string, data ...interface{}) {
+	r.log(ctx, Debug, r.ILogger.Debug, msg, data)
+}
+
+func (r *RateLimitedLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	r.log(ctx, Info, r.ILogger.Info, msg, data)
+}
+
+func (r *RateLimitedLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	r.log(ctx, Warn, r.ILogger.Warn, msg, data)
+}
+
+func (r *RateLimitedLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	r.log(ctx, Error, r.ILogger.Error, msg, data)
+}
+
+// Flush 输出各消息上次输出后被抑制条数的汇总，并清理已补满的令牌桶
+func (r *RateLimitedLogger) Flush(ctx context.Context) {
+	type summary struct {
+		key        rateKey
+		suppressed int64
+	}
+	var summaries []summary
+	now := time.Now()
+
+	r.limiter.lock.Lock()
+	for key, b := range r.limiter.buckets {
+		if b.suppressed > 0 {
+			summaries = append(summaries, summary{key: key, suppressed: b.suppressed})
+			b.suppressed = 0
+		} else if b.tokens+float64(now.Sub(b.last))/float64(r.limiter.limit.Interval) >= float64(r.limiter.limit.Burst) {
+			delete(r.limiter.buckets, key)
+		}
+	}
+	r.limiter.lock.Unlock()
+
+	for _, s := range summaries {
+		msg := fmt.Sprintf("suppressed %d similar messages", s.suppressed)
+		attrs := []interface{}{slog.String(KeySuppressedMsg, s.key.msg), slog.Int64(KeySuppressed, s.suppressed)}
+		switch s.key.level {
+		case Debug:
+			r.ILogger.Debug(ctx, msg, attrs...)
+		case Info:
+			r.ILogger.Info(ctx, msg, attrs...)
+		case Warn:
+			r.ILogger.Warn(ctx, msg, attrs...)
+		default:
+			r.ILogger.Error(ctx, msg, attrs...)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// LevelFatal Fatal 对应的 slog 级别
+const LevelFatal = slog.LevelError + 4
+
+// packageName logger 包内函数名的前缀
+const packageName = "github.com/treeyh/raindrop/logger."
+
+// slogLogger 通过 *slog.Logger 输出日志
+type slogLogger struct {
+	// l 为空时使用 slog.Default()，跟随应用设置的默认 slog
+	l *slog.Logger
+
+	// level 最低日志级别，为 0 时由 handler 决定
+	level LogLevel
+}
+
+// NewSlog 创建通过 l 输出日志的 ILogger，l 为空时使用 slog.Default()。日志参数中的 slog.Attr 原样输出，
+// error 输出为 err 属性，字符串与其后的参数组成键值对，参见 WorkerId、Code、TimeSeq、Table、Err
+func NewSlog(l *slog.Logger) ILogger {
+	return &slogLogger{l: l}
+}
+
+// logger 获取输出日志的 *slog.Logger
+func (s *slogLogger) logger() *slog.Logger {
+	if s.l != nil {
+		return s.l
+	}
+	return slog.Default()
+}
+
+// LogMode 设置最低日志级别，低于该级别的日志不输出
+func (s *slogLogger) LogMode(level LogLevel) ILogger {
+	newLogger := *s
+	newLogger.level = level
+	return &newLogger
+}
+
+// GetLogLevel 获取最低日志级别，未设置时为 handler 启用的最低级别
+func (s *slogLogger) GetLogLevel() LogLevel {
+	if s.level != 0 {
+		return s.level
+	}
+	ctx := context.Background()
+	l := s.logger()
+	for level := Debug; level < Fatal; level++ {
+		if l.Enabled(ctx, slogLevel(level)) {
+			return level
+		}
+	}
+	return Fatal
+}
+
+// slogLevel 日志级别对应的 slog 级别
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case Debug:
+		return slog.LevelDebug
+	case Info:
+		return slog.LevelInfo
+	case Warn:
+		return slog.LevelWarn
+	case Error:
+		return slog.LevelError
+	default:
+		return LevelFatal
+	}
+}
+
+// log 输出日志，调用位置为调用 ILogger 方法处
+func (s *slogLogger) log(ctx context.Context, level LogLevel, msg string, data []interface{}) {
+	if level < s.level {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	l := s.logger()
+	sl := slogLevel(level)
+	if !l.Enabled(ctx, sl) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), sl, msg, callerPC())
+	r.AddAttrs(toAttrs(data)...)
+	_ = l.Handler().Handle(ctx, r)
+}
+
+// callerPC 获取调用日志方法处的 PC，跳过 logger 包内的调用，如 RateLimitedLogger
+func callerPC() uintptr {
+	var pcs [8]uintptr
+	// 跳过 runtime.Callers、callerPC 及 log
+	n := runtime.Callers(3, pcs[:])
+	for _, pc := range pcs[:n] {
+		f := runtime.FuncForPC(pc - 1)
+		if f == nil || !strings.HasPrefix(f.Name(), packageName) {
+			return pc
+		}
+	}
+	return 0
+}
+
+func (s *slogLogger) Debug(ctx context.Context, msg string, data ...interface{}) {
+	s.log(ctx, Debug, msg, data)
+}
+
+func (s *slogLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	s.log(ctx, Info, msg, data)
+}
+
+func (s *slogLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	s.log(ctx, Warn, msg, data)
+}
+
+func (s *slogLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	s.log(ctx, Error, msg, data)
+}
+
+func (s *slogLogger) Fatal(ctx context.Context, msg string, data ...interface{}) {
+	s.log(ctx, Fatal, msg, data)
+	panic(msg)
+}
@@ -0,0 +1,296 @@
+// Package metrics 雨滴的 Prometheus 指标。生成id、序列号耗尽、时钟回拨、心跳等事件通过 config.Observer 收集，
+// 租约时长、DB时间偏差、时间回拨位及空闲worker数量在采集时读取
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+)
+
+const (
+	// Namespace 指标名称的前缀
+	Namespace = "raindrop"
+
+	// FreeWorkersInterval 空闲worker数量的查询间隔，间隔内的采集使用上次查询的结果，避免每次采集都查询数据库
+	FreeWorkersInterval = time.Duration(consts.HeartbeatTimeInterval) * time.Second
+)
+
+// Metrics 实现 config.Observer 及 prometheus.Collector，设置为 RainDropConfig.Observer 后收集事件指标。
+// 事件同时转发给 next，next 为空时不转发
+type Metrics struct {
+	next config.Observer
+
+	idsGenerated      *prometheus.CounterVec
+	newIdDuration     *prometheus.HistogramVec
+	seqExhausted      *prometheus.CounterVec
+	seqExhaustedWait  prometheus.Histogram
+	clockBackwards    *prometheus.CounterVec
+	timeBackFlips     prometheus.Counter
+	heartbeats        *prometheus.CounterVec
+	heartbeatDuration prometheus.Histogram
+	workerEvents      *prometheus.CounterVec
+	driftExceeded     *prometheus.CounterVec
+	dbRetries         *prometheus.CounterVec
+
+	leaseAgeDesc    *prometheus.Desc
+	dbDriftDesc     *prometheus.Desc
+	timeBackBitDesc *prometheus.Desc
+	freeWorkersDesc *prometheus.Desc
+
+	// freeLock 空闲worker数量缓存的锁
+	freeLock sync.Mutex
+	// freeWorkers 上次查询的空闲worker数量
+	freeWorkers int
+	// freeErr 上次查询的错误
+	freeErr error
+	// freeTime 上次查询的时间
+	freeTime time.Time
+}
+
+// New 创建指标并注册到 reg，reg 为空时注册到 prometheus.DefaultRegisterer。
+// 返回值需设置为 RainDropConfig.Observer，next 为原有的观察者，可为空
+func New(reg prometheus.Registerer, next config.Observer) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	m := newMetrics(next)
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// newMetrics 创建指标
+func newMetrics(next config.Observer) *Metrics {
+	return &Metrics{
+		next: next,
+		idsGenerated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "ids_generated_total",
+			Help:      "Number of NewId and NewIdByCode calls by id code, id mode and result.",
+		}, []string{"code", "mode", "result"}),
+		newIdDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "new_id_duration_seconds",
+			Help:      "Latency of NewId and NewIdByCode, including waits for the next time seq.",
+			Buckets:   prometheus.ExponentialBuckets(0.000001, 4, 11),
+		}, []string{"mode"}),
+		seqExhausted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "seq_exhausted_total",
+			Help:      "Number of sequence exhaustions by id code and action, wait for the next time seq or error.",
+		}, []string{"code", "action"}),
+		seqExhaustedWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "seq_exhausted_wait_seconds",
+			Help:      "Time waited for the next time seq after the sequence was exhausted.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		clockBackwards: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "clock_backwards_total",
+			Help:      "Number of clock backward jumps observed when generating ids, by id code.",
+		}, []string{"code"}),
+		timeBackFlips: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "time_back_flips_total",
+			Help:      "Number of time back bit flips caused by clock backward jumps.",
+		}),
+		heartbeats: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "heartbeats_total",
+			Help:      "Number of worker heartbeats by result.",
+		}, []string{"result"}),
+		heartbeatDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "heartbeat_duration_seconds",
+			Help:      "Latency of worker heartbeats, including ntp queries.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}),
+		workerEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "worker_events_total",
+			Help:      "Number of worker lease events: activate, takeover, release and lost.",
+		}, []string{"event"}),
+		driftExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "drift_exceeded_total",
+			Help:      "Number of times the clock drift from the database or ntp servers exceeded the threshold.",
+		}, []string{"source"}),
+		dbRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "db_retries_total",
+			Help:      "Number of transient database errors by operation and action, retry or failed after the last attempt.",
+		}, []string{"op", "action"}),
+		leaseAgeDesc: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "lease_age_seconds"),
+			"Time since the last successful heartbeat of the held worker.", nil, nil),
+		dbDriftDesc: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "db_drift_seconds"),
+			"Smoothed database time minus server time estimated on heartbeats.", nil, nil),
+		timeBackBitDesc: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "time_back_bit"),
+			"Current time back bit of NewId.", nil, nil),
+		freeWorkersDesc: prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "free_workers"),
+			"Free workers remaining in the pool of the datacenter.", nil, nil),
+	}
+}
+
+// collectors 事件指标
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.idsGenerated, m.newIdDuration, m.seqExhausted, m.seqExhaustedWait, m.clockBackwards,
+		m.timeBackFlips, m.heartbeats, m.heartbeatDuration, m.workerEvents, m.driftExceeded, m.dbRetries}
+}
+
+// Describe 实现 prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+	ch <- m.leaseAgeDesc
+	ch <- m.dbDriftDesc
+	ch <- m.timeBackBitDesc
+	ch <- m.freeWorkersDesc
+}
+
+// Collect 实现 prometheus.Collector，未初始化时不输出运行状态指标，查询空闲worker失败时不输出空闲worker数量
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+	ctx := context.Background()
+	status := raindrop.Status(ctx)
+	if !status.Initialized {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(m.leaseAgeDesc, prometheus.GaugeValue, status.LeaseAge.Seconds())
+	ch <- prometheus.MustNewConstMetric(m.dbDriftDesc, prometheus.GaugeValue, status.Drift.Offset.Seconds())
+	ch <- prometheus.MustNewConstMetric(m.timeBackBitDesc, prometheus.GaugeValue, float64(status.TimeBackBit))
+	count, err := m.queryFreeWorkers(ctx)
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(m.freeWorkersDesc, prometheus.GaugeValue, float64(count))
+	}
+}
+
+// queryFreeWorkers 查询空闲worker数量，距上次查询不足 FreeWorkersInterval 时返回上次的结果
+func (m *Metrics) queryFreeWorkers(ctx context.Context) (int, error) {
+	m.freeLock.Lock()
+	defer m.freeLock.Unlock()
+	now := time.Now()
+	if !m.freeTime.IsZero() && now.Sub(m.freeTime) < FreeWorkersInterval {
+		return m.freeWorkers, m.freeErr
+	}
+	m.freeWorkers, m.freeErr = raindrop.FreeWorkers(ctx)
+	m.freeTime = now
+	return m.freeWorkers, m.freeErr
+}
+
+// result 指标的结果标签
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+func (m *Metrics) WorkerAcquired(ctx context.Context, worker model.RaindropWorker, event string) {
+	m.workerEvents.WithLabelValues(event).Inc()
+	if m.next != nil {
+		m.next.WorkerAcquired(ctx, worker, event)
+	}
+}
+
+func (m *Metrics) WorkerReleased(ctx context.Context, worker model.RaindropWorker) {
+	m.workerEvents.WithLabelValues(consts.WorkerEventRelease).Inc()
+	if m.next != nil {
+		m.next.WorkerReleased(ctx, worker)
+	}
+}
+
+func (m *Metrics) WorkerLost(ctx context.Context, worker model.RaindropWorker, err error) {
+	m.workerEvents.WithLabelValues("lost").Inc()
+	if m.next != nil {
+		m.next.WorkerLost(ctx, worker, err)
+	}
+}
+
+func (m *Metrics) HeartbeatFailed(ctx context.Context, worker model.RaindropWorker, err error) {
+	if m.next != nil {
+		m.next.HeartbeatFailed(ctx, worker, err)
+	}
+}
+
+func (m *Metrics) HeartbeatCompleted(ctx context.Context, worker model.RaindropWorker, latency time.Duration, err error) {
+	m.heartbeats.WithLabelValues(result(err)).Inc()
+	m.heartbeatDuration.Observe(latency.Seconds())
+	if m.next != nil {
+		m.next.HeartbeatCompleted(ctx, worker, latency, err)
+	}
+}
+
+func (m *Metrics) IdGenerated(ctx context.Context, idMode string, idCode string, latency time.Duration, err error) {
+	m.idsGenerated.WithLabelValues(idCode, idMode, result(err)).Inc()
+	m.newIdDuration.WithLabelValues(idMode).Observe(latency.Seconds())
+	if m.next != nil {
+		m.next.IdGenerated(ctx, idMode, idCode, latency, err)
+	}
+}
+
+func (m *Metrics) ClockBackwards(ctx context.Context, incident model.ClockIncident) {
+	m.clockBackwards.WithLabelValues(incident.IdCode).Inc()
+	if incident.TimeBackBefore != incident.TimeBackAfter {
+		m.timeBackFlips.Inc()
+	}
+	if m.next != nil {
+		m.next.ClockBackwards(ctx, incident)
+	}
+}
+
+func (m *Metrics) DriftExceeded(ctx context.Context, incident model.ClockIncident) {
+	m.driftExceeded.WithLabelValues(incident.Kind).Inc()
+	if m.next != nil {
+		m.next.DriftExceeded(ctx, incident)
+	}
+}
+
+func (m *Metrics) SeqExhausted(ctx context.Context, idCode string, timeSeq int64, wait time.Duration) {
+	if wait > 0 {
+		m.seqExhausted.WithLabelValues(idCode, "wait").Inc()
+		m.seqExhaustedWait.Observe(wait.Seconds())
+	} else {
+		m.seqExhausted.WithLabelValues(idCode, "error").Inc()
+	}
+	if m.next != nil {
+		m.next.SeqExhausted(ctx, idCode, timeSeq, wait)
+	}
+}
+
+func (m *Metrics) TimestampExpiring(ctx context.Context, overflowTime time.Time, remaining time.Duration) {
+	if m.next != nil {
+		m.next.TimestampExpiring(ctx, overflowTime, remaining)
+	}
+}
+
+func (m *Metrics) DbRetry(ctx context.Context, op string, attempt int, wait time.Duration, err error) {
+	m.dbRetries.WithLabelValues(op, "retry").Inc()
+	if m.next != nil {
+		m.next.DbRetry(ctx, op, attempt, wait, err)
+	}
+}
+
+func (m *Metrics) DbRetryFailed(ctx context.Context, op string, attempts int, err error) {
+	m.dbRetries.WithLabelValues(op, "failed").Inc()
+	if m.next != nil {
+		m.next.DbRetryFailed(ctx, op, attempts, err)
+	}
+}
+
+func (m *Metrics) SchemaMigrated(ctx context.Context, table string, version int, description string, err error) {
+	if m.next != nil {
+		m.next.SchemaMigrated(ctx, table, version, description, err)
+	}
+}
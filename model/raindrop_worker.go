@@ -6,12 +6,19 @@ import (
 )
 
 type RaindropWorker struct {
+	Service string `json:"service"`
+
+	DatacenterId int64 `json:"datacenterId"`
+
 	Id int64 `json:"id"`
 
 	Code string `json:"code"`
 
 	TimeUnit consts.TimeUnit `json:"timeUnit"`
 
+	// Layout 最近一次激活该worker的 id 结构指纹
+	Layout string `json:"layout"`
+
 	HeartbeatTime time.Time `json:"heartbeatTime"`
 
 	CreateTime time.Time `json:"createTime"`
@@ -20,5 +27,347 @@ type RaindropWorker struct {
 
 	Version int64 `json:"version"`
 
+	// DelFlag 删除标记，参见 consts.WorkerDelFlag*
 	DelFlag int `json:"delFlag"`
 }
+
+// WorkerHistory worker分配历史，每次激活、接管、释放及停用记录一条
+type WorkerHistory struct {
+	Service string `json:"service"`
+
+	DatacenterId int64 `json:"datacenterId"`
+
+	WorkerId int64 `json:"workerId"`
+
+	// Version 事件发生后worker的版本号
+	Version int64 `json:"version"`
+
+	// Event 事件，参见 consts.WorkerEvent*
+	Event string `json:"event"`
+
+	// Code 事件发生后持有worker的节点，释放及停用时为空
+	Code string `json:"code"`
+
+	// PreviousCode 事件发生前持有worker的节点
+	PreviousCode string `json:"previousCode"`
+
+	// Layout 激活及接管时为节点的 id 结构指纹，释放及停用时为worker最近一次使用的 id 结构指纹
+	Layout string `json:"layout"`
+
+	// TimeSeq 激活及接管时按 Layout 计算的时间戳位的值，节点生成的id的时间戳位从该值开始；释放及停用时为 0
+	TimeSeq int64 `json:"timeSeq"`
+
+	// EventTime 事件发生时间
+	EventTime time.Time `json:"eventTime"`
+}
+
+// ClockIncident 时钟异常，记录时钟回拨、服务器与DB时间差异过大及序列号耗尽
+type ClockIncident struct {
+	Service string `json:"service"`
+
+	DatacenterId int64 `json:"datacenterId"`
+
+	WorkerId int64 `json:"workerId"`
+
+	// Code 持有worker的节点
+	Code string `json:"code"`
+
+	// Kind 异常类型，参见 consts.ClockIncident*
+	Kind string `json:"kind"`
+
+	// IdCode NewIdByCode 的 code，NewId 及心跳时为空
+	IdCode string `json:"idCode"`
+
+	// LastTimeSeq 上次生成id时时间戳位的值，心跳时为 0
+	LastTimeSeq int64 `json:"lastTimeSeq"`
+
+	// TimeSeq 发生异常时时间戳位的值，心跳时为 0
+	TimeSeq int64 `json:"timeSeq"`
+
+	// Jump 时钟回拨的时长
+	Jump time.Duration `json:"jump"`
+
+	// TimeBackBefore 时钟回拨前时间回拨位的值
+	TimeBackBefore int64 `json:"timeBackBefore"`
+
+	// TimeBackAfter 时钟回拨后时间回拨位的值
+	TimeBackAfter int64 `json:"timeBackAfter"`
+
+	// Drift 偏差，平滑后的DB时间或NTP服务器时间减去服务器时间
+	Drift time.Duration `json:"drift"`
+
+	// Count 合并的次数，序列号耗尽时为距上一条记录期间耗尽的次数，其余为 1
+	Count int64 `json:"count"`
+
+	// EventTime 异常发生时间，服务器时间
+	EventTime time.Time `json:"eventTime"`
+}
+
+// DriftStatus 服务器与DB时间偏差的估算状态
+type DriftStatus struct {
+	// Offset 平滑后的偏差，DB时间减去服务器时间
+	Offset time.Duration `json:"offset"`
+
+	// LastSample 最近一次采样的偏差，已按往返时间的一半补偿
+	LastSample time.Duration `json:"lastSample"`
+
+	// RoundTrip 最近一次采样查询DB时间的往返时间
+	RoundTrip time.Duration `json:"roundTrip"`
+
+	// Samples 采样次数
+	Samples int64 `json:"samples"`
+
+	// SampleTime 最近一次采样的服务器时间
+	SampleTime time.Time `json:"sampleTime"`
+
+	// Threshold 偏差阈值
+	Threshold time.Duration `json:"threshold"`
+
+	// Policy 偏差超过阈值时的处理策略，参见 consts.DriftPolicy*
+	Policy string `json:"policy"`
+
+	// Exceeded 平滑后的偏差是否超过阈值
+	Exceeded bool `json:"exceeded"`
+
+	// Stopped 是否因偏差超过阈值停止生成id
+	Stopped bool `json:"stopped"`
+}
+
+// NtpStatus 服务器与NTP服务器时间偏差的估算状态
+type NtpStatus struct {
+	// Offset 各NTP服务器偏差的中位数，NTP服务器时间减去服务器时间
+	Offset time.Duration `json:"offset"`
+
+	// Dispersion 偏差的误差范围，为各NTP服务器偏差与中位数的最大差值加上最小的同步距离
+	Dispersion time.Duration `json:"dispersion"`
+
+	// Servers 最近一次查询各NTP服务器的结果
+	Servers []NtpSample `json:"servers"`
+
+	// Samples 查询成功的次数
+	Samples int64 `json:"samples"`
+
+	// SampleTime 最近一次查询成功的服务器时间
+	SampleTime time.Time `json:"sampleTime"`
+
+	// Exceeded 偏差是否超过阈值
+	Exceeded bool `json:"exceeded"`
+}
+
+// NtpSample 查询单个NTP服务器的结果
+type NtpSample struct {
+	Server string `json:"server"`
+
+	// Offset NTP服务器时间减去服务器时间，已按往返时间补偿
+	Offset time.Duration `json:"offset"`
+
+	// Delay 往返时间
+	Delay time.Duration `json:"delay"`
+
+	Stratum int `json:"stratum"`
+
+	// Distance 同步距离，本次偏差的误差上限
+	Distance time.Duration `json:"distance"`
+
+	// Error 查询失败的原因，成功时为空
+	Error string `json:"error"`
+}
+
+// IdOrigin id的来源
+type IdOrigin struct {
+	// Info id解析结果
+	Info IdInfo `json:"info"`
+
+	// Owner 生成id时持有worker的节点的激活或接管记录
+	Owner WorkerHistory `json:"owner"`
+
+	// EndTimeSeq 节点持有worker结束时的时间戳位的值，-1 表示仍在持有。节点持有worker的范围为 [Owner.TimeSeq, EndTimeSeq]
+	EndTimeSeq int64 `json:"endTimeSeq"`
+
+	// InRange id的时间戳位是否在节点持有worker的范围内，为 false 时id不是由 Owner 正常生成的
+	InRange bool `json:"inRange"`
+}
+
+// WorkerLease worker及其租约状态
+type WorkerLease struct {
+	RaindropWorker
+
+	// Status 租约状态，参见 consts.WorkerStatus*
+	Status string `json:"status"`
+}
+
+// IdInfo id解析结果
+type IdInfo struct {
+	// Time id生成时间，按时间单位截断
+	Time time.Time `json:"time"`
+
+	// TimeSeq 时间戳位的值
+	TimeSeq int64 `json:"timeSeq"`
+
+	// DatacenterId 数据中心 id
+	DatacenterId int64 `json:"datacenterId"`
+
+	// WorkerId 工作节点 id
+	WorkerId int64 `json:"workerId"`
+
+	// TimeBack 时间回拨位的值
+	TimeBack int64 `json:"timeBack"`
+
+	// Seq 同一时间单位内的流水号
+	Seq int64 `json:"seq"`
+
+	// EndBits 预留位的值
+	EndBits int64 `json:"endBits"`
+}
+
+// LayoutPlan id 结构变更计划
+type LayoutPlan struct {
+	// Safe 立即切换到新结构时，新结构当前及之后生成的 id 均大于已有的 id
+	Safe bool `json:"safe"`
+
+	// MaxIssuedId 已生成的最大 id
+	MaxIssuedId int64 `json:"maxIssuedId"`
+
+	// OldMaxIdNow 旧结构当前可生成的最大 id
+	OldMaxIdNow int64 `json:"oldMaxIdNow"`
+
+	// NewMinIdNow 新结构当前可生成的最小 id
+	NewMinIdNow int64 `json:"newMinIdNow"`
+
+	// CutoverTime 旧结构运行到该时间后切换到新结构是安全的，零值表示在新结构溢出前无法安全切换
+	CutoverTime time.Time `json:"cutoverTime"`
+
+	// EpochAdjustment 立即切换时新结构 StartTimeStamp 需要提前的时间单位数（新结构的 TimeUnit），0 表示无需调整
+	EpochAdjustment int64 `json:"epochAdjustment"`
+
+	// SuggestedStartTimeStamp 立即切换时建议的新结构 StartTimeStamp，零值表示无法通过调整起始时间立即切换
+	SuggestedStartTimeStamp time.Time `json:"suggestedStartTimeStamp"`
+
+	// OverflowTime 新结构时间戳位耗尽的时间
+	OverflowTime time.Time `json:"overflowTime"`
+
+	// Warnings 警告信息
+	Warnings []string `json:"warnings"`
+}
+
+// WorkerReconcileResult worker表对账结果
+type WorkerReconcileResult struct {
+	// Inserted 新增的worker数量
+	Inserted int64 `json:"inserted"`
+
+	// Disabled 标记为删除的范围外worker数量
+	Disabled int64 `json:"disabled"`
+
+	// Enabled 恢复的范围内worker数量
+	Enabled int64 `json:"enabled"`
+}
+
+// LeaseCache 本地租约缓存，数据库不可用时据此降级启动
+type LeaseCache struct {
+	Service string `json:"service"`
+
+	// TableName 数据库表名
+	TableName string `json:"tableName"`
+
+	DatacenterId int64 `json:"datacenterId"`
+
+	// Worker 最近一次激活或心跳成功后的worker，版本号用于数据库恢复后确认租约
+	Worker RaindropWorker `json:"worker"`
+
+	// Layout id 结构指纹
+	Layout string `json:"layout"`
+
+	// LeaseExpireTime 租约到期时间，已扣除 DriftThreshold 的时钟偏差余量，之后worker可能被其他节点激活
+	LeaseExpireTime time.Time `json:"leaseExpireTime"`
+
+	// LastTimeSeq 写入时的时间戳流水与已生成id的最大时间戳流水中的较大值，之前生成的id的时间戳位不大于该值
+	LastTimeSeq int64 `json:"lastTimeSeq"`
+
+	// TimeBackValue 写入时的时间回拨位
+	TimeBackValue int64 `json:"timeBackValue"`
+
+	// UpdateTime 写入时间
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+// Status 生成器的运行状态
+type Status struct {
+	// Initialized 是否已初始化
+	Initialized bool `json:"initialized"`
+
+	// Live 存活检查是否通过，租约丢失、已过期或因时间偏差释放worker时不通过，需重新初始化
+	Live bool `json:"live"`
+
+	// Ready 就绪检查是否通过，存活检查不通过、未初始化或时钟异常时不通过
+	Ready bool `json:"ready"`
+
+	// Problems 检查不通过的原因
+	Problems []string `json:"problems"`
+
+	DatacenterId int64 `json:"datacenterId"`
+
+	WorkerId int64 `json:"workerId"`
+
+	// Code 持有worker的节点
+	Code string `json:"code"`
+
+	// Version 租约版本号
+	Version int64 `json:"version"`
+
+	// HeartbeatTime 最近一次激活或心跳成功写入的心跳时间
+	HeartbeatTime time.Time `json:"heartbeatTime"`
+
+	// LeaseAge 距最近一次心跳成功的时长
+	LeaseAge time.Duration `json:"leaseAge"`
+
+	// LeaseExpireTime 租约到期时间，之后worker可能被其他节点激活
+	LeaseExpireTime time.Time `json:"leaseExpireTime"`
+
+	// LastHeartbeatTime 最近一次心跳的时间，未执行过心跳时为零值
+	LastHeartbeatTime time.Time `json:"lastHeartbeatTime"`
+
+	// LastHeartbeatError 最近一次心跳的错误，成功时为空
+	LastHeartbeatError string `json:"lastHeartbeatError"`
+
+	// Degraded 是否基于本地租约缓存降级运行
+	Degraded bool `json:"degraded"`
+
+	// LeaseLost 租约是否已丢失（worker被停用、释放或被其他节点激活），丢失后停止生成id
+	LeaseLost bool `json:"leaseLost"`
+
+	// Drift 与DB时间偏差的估算状态
+	Drift DriftStatus `json:"drift"`
+
+	// Ntp 与NTP服务器时间偏差的估算状态
+	Ntp NtpStatus `json:"ntp"`
+
+	// TimeBackBit 当前时间回拨位
+	TimeBackBit int64 `json:"timeBackBit"`
+
+	// TimeSeq 当前时间戳流水
+	TimeSeq int64 `json:"timeSeq"`
+
+	// SeqUsed NewId 在当前时间戳流水已使用的序列号数量
+	SeqUsed int64 `json:"seqUsed"`
+
+	// SeqCapacity 同一时间戳流水可用的序列号数量
+	SeqCapacity int64 `json:"seqCapacity"`
+
+	// SeqUtilization SeqUsed 占 SeqCapacity 的比例
+	SeqUtilization float64 `json:"seqUtilization"`
+
+	// IssuedIds 初始化后 NewId 及 NewIdByCode 生成的id数量
+	IssuedIds int64 `json:"issuedIds"`
+
+	// ExhaustionWaits 序列号耗尽后等待下一时间戳流水的次数
+	ExhaustionWaits int64 `json:"exhaustionWaits"`
+
+	// ExhaustionErrors 序列号耗尽后返回 consts.ErrMsgIdSeqReachesMaxValueError 的次数
+	ExhaustionErrors int64 `json:"exhaustionErrors"`
+
+	// OverflowTime 时间戳位耗尽的时间
+	OverflowTime time.Time `json:"overflowTime"`
+
+	// RemainingLifetime 距时间戳位耗尽的时长
+	RemainingLifetime time.Duration `json:"remainingLifetime"`
+}
@@ -2,42 +2,235 @@ package raindrop
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/treeyh/raindrop/config"
 	"github.com/treeyh/raindrop/consts"
 	"github.com/treeyh/raindrop/db"
 	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/model"
 	"github.com/treeyh/raindrop/utils"
 	"github.com/treeyh/raindrop/worker"
 )
 
 var (
 	log logger.ILogger
+
+	// degradedLock 降级运行状态的锁
+	degradedLock sync.Mutex
+	// degradedConf 降级启动时的配置，用于重连数据库
+	degradedConf config.RainDropConfig
+	// degradedDb 降级启动时调用方提供的 IDb，为空时根据配置创建数据库连接
+	degradedDb db.IDb
 )
 
-// Init 初始化
+// Init 初始化，失败时调用 log.Fatal 终止，需要自行处理启动失败时使用 InitE
 func Init(ctx context.Context, conf config.RainDropConfig) {
+	err := InitE(ctx, conf)
+	if err != nil {
+		log.Fatal(ctx, err.Error(), err)
+	}
+}
 
-	initLogger(ctx, conf)
-	log.Info(ctx, "raindrop init. config: "+utils.ToJsonIgnoreError(conf))
+// InitE 初始化，失败时返回错误。可通过 errors.Is 判断 consts.ErrMsg* 错误，
+// 通过 errors.As 获取 consts.ConfigError、consts.DbError、consts.LeaseError、consts.ClockError 中的详细信息
+func InitE(ctx context.Context, conf config.RainDropConfig) error {
+	return initWithDb(ctx, conf, nil)
+}
+
+// InitWithDb 使用调用方提供的 IDb 实现初始化，失败时返回错误
+func InitWithDb(ctx context.Context, conf config.RainDropConfig, d db.IDb) error {
+	return initWithDb(ctx, conf, d)
+}
+
+// initWithDb 初始化，d 为空时根据配置创建数据库连接
+func initWithDb(ctx context.Context, conf config.RainDropConfig, d db.IDb) error {
+	initLogger(ctx, &conf)
+	// 连接串中的密码不输出到日志，调用方提供的连接池不参与序列化
+	logConf := conf
+	logConf.DbConfig.DbUrl = utils.RedactDbUrl(conf.DbConfig.DbUrl)
+	log.Info(ctx, "raindrop init. config: "+utils.ToJsonIgnoreError(logConf))
 
 	err := config.CheckConfig(ctx, &conf)
 	if err != nil {
-		log.Fatal(ctx, "config check fail: "+err.Error(), err)
+		return fmt.Errorf("config check fail: %w", err)
 	}
 	log.Debug(ctx, "check config over.")
-	err = initDb(ctx, conf)
+	// 先于连接数据库查询NTP服务器，数据库不可用时同样校验时钟
+	err = worker.CheckStartupNtp(ctx, conf)
+	if err != nil {
+		log.Error(ctx, err.Error(), err)
+		return fmt.Errorf("check ntp fail: %w", err)
+	}
+	err = connectDb(ctx, conf, d)
 	if err != nil {
-		log.Fatal(ctx, "init db fail: "+err.Error(), err)
+		if startDegraded(ctx, conf, d, err) {
+			return nil
+		}
+		return fmt.Errorf("init db fail: %w", err)
 	}
 
 	log.Debug(ctx, "init db over.")
 	err = initRaindrop(ctx, conf)
 	if err != nil {
-		log.Fatal(ctx, "init raindrop fail: "+err.Error(), err)
+		if startDegraded(ctx, conf, d, err) {
+			return nil
+		}
+		return fmt.Errorf("init raindrop fail: %w", err)
+	}
+	return nil
+}
+
+// connectDb 连接数据库，d 为空时根据配置创建数据库连接，数据库的重试及迁移事件回调 conf.Observer
+func connectDb(ctx context.Context, conf config.RainDropConfig, d db.IDb) error {
+	db.SetObserver(conf.Observer)
+	if d != nil {
+		return db.UseDb(ctx, d, conf.DbConfig, log)
+	}
+	return initDb(ctx, conf)
+}
+
+// startDegraded 配置了 LeaseCacheFile 且数据库因临时错误不可用时，基于本地租约缓存降级启动并在后台重连数据库，返回是否降级启动成功
+func startDegraded(ctx context.Context, conf config.RainDropConfig, d db.IDb, cause error) bool {
+	retryable := conf.DbConfig.Retry.Retryable
+	if retryable == nil {
+		retryable = db.IsRetryableError
+	}
+	if conf.LeaseCacheFile == "" || !retryable(cause) {
+		return false
+	}
+	err := worker.InitDegraded(ctx, conf)
+	if err != nil {
+		return false
+	}
+
+	degradedLock.Lock()
+	degradedConf = conf
+	degradedDb = d
+	degradedLock.Unlock()
+	log.Error(ctx, "database unavailable, raindrop degraded start with lease cache. leaseExpireTime: "+
+		worker.GetLeaseExpireTime(ctx).String()+", error: "+cause.Error(), cause)
+
+	if v := ctx.Value(consts.ProjectName); v != nil {
+		// 支持单元测试，跳过后台重连，通过 Reconnect 手动重连
+		if consts.SkipHeartbeat == v.(string) {
+			return true
+		}
+	}
+	go startReconnect(context.WithoutCancel(ctx))
+	return true
+}
+
+// startReconnect 降级运行时定时重连数据库，确认租约或租约丢失后退出
+func startReconnect(ctx context.Context) {
+	ticker := time.NewTicker(consts.LeaseReconnectInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := Reconnect(ctx)
+		if err == nil || errors.Is(err, consts.ErrMsgWorkerLeaseLost) {
+			return
+		}
+	}
+}
+
+// Reconnect 降级运行时立即重连数据库，通过心跳确认缓存的租约，成功后退出降级并启动心跳；
+// worker已被其他节点激活时停止生成id并返回 consts.ErrMsgWorkerLeaseLost。未降级时直接返回
+func Reconnect(ctx context.Context) error {
+	degradedLock.Lock()
+	defer degradedLock.Unlock()
+
+	if !worker.IsDegraded(ctx) {
+		return nil
+	}
+	err := connectDb(ctx, degradedConf, degradedDb)
+	if err == nil {
+		err = initTableWorkers(ctx, degradedConf)
+	}
+	if err != nil {
+		log.Error(ctx, "reconnect database fail. leaseExpireTime: "+worker.GetLeaseExpireTime(ctx).String()+", error: "+err.Error(), err)
+		return err
+	}
+	return worker.RecoverLease(ctx)
+}
+
+// Degraded 是否因数据库不可用基于本地租约缓存降级运行，降级时返回租约到期时间，到期前数据库未恢复时停止生成id
+func Degraded(ctx context.Context) (bool, time.Time) {
+	return worker.IsDegraded(ctx), worker.GetLeaseExpireTime(ctx)
+}
+
+// Migrate 根据配置连接数据库并执行表结构迁移，不分配worker。适用于禁止运行时执行 DDL 的场景，
+// 在部署流程中单独调用后，Init 时设置 DbConfig.DisableMigrate 仅校验表结构版本
+func Migrate(ctx context.Context, conf config.RainDropConfig) error {
+	initLogger(ctx, &conf)
+
+	err := config.CheckConfig(ctx, &conf)
+	if err != nil {
+		return fmt.Errorf("config check fail: %w", err)
+	}
+	err = initDb(ctx, conf)
+	if err != nil {
+		return fmt.Errorf("init db fail: %w", err)
+	}
+	err = db.Migrate(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate fail: %w", err)
+	}
+	return nil
+}
+
+// ChangeLayout 变更 id 结构（StartTimeStamp、TimeUnit、各部分位长度）。maxIssuedId 为旧结构已生成的最大 id，
+// 新结构当前生成的 id 必须大于该值，之后生成的 id 随时间递增，因此均排在已生成的 id 之后。
+// 校验通过后将空闲worker的 id 结构指纹改为新结构，解除隔离，返回修改的worker数量。执行前需先停止使用旧结构的服务
+func ChangeLayout(ctx context.Context, conf config.RainDropConfig, maxIssuedId int64) (int64, error) {
+	initLogger(ctx, &conf)
+
+	err := config.CheckConfig(ctx, &conf)
+	if err != nil {
+		return 0, fmt.Errorf("config check fail: %w", err)
+	}
+	now := conf.Clock.Now()
+	minId := worker.MinIdAt(conf, now)
+	if minId <= maxIssuedId {
+		err = fmt.Errorf("%w. maxIssuedId: %d, minId: %d", consts.ErrMsgLayoutChangeUnsafe, maxIssuedId, minId)
+		log.Error(ctx, err.Error(), err)
+		return 0, err
+	}
+
+	err = initDb(ctx, conf)
+	if err != nil {
+		return 0, fmt.Errorf("init db fail: %w", err)
+	}
+	err = db.InitTableWorkers(ctx, conf.DatacenterId, conf.ServiceMinWorkId, conf.ServiceMaxWorkId, time.Time{})
+	if err != nil {
+		return 0, fmt.Errorf("init table workers fail: %w", err)
 	}
+
+	layout := worker.LayoutFingerprint(conf)
+	count, err := db.Db.ChangeWorkersLayout(ctx, layout, worker.FreeHeartbeatTime(now, conf.TimeUnit))
+	if err != nil {
+		return 0, fmt.Errorf("change workers layout fail: %w", err)
+	}
+	log.Info(ctx, "change layout over. layout: "+layout+", minId: "+strconv.FormatInt(minId, 10)+", workers: "+strconv.FormatInt(count, 10))
+	return count, nil
+}
+
+// PlanLayoutChange 计算从 oldConf 变更到 newConf 的 id 结构变更计划，不访问数据库。maxIssuedId 为旧结构已生成的最大 id，
+// 计划给出新结构当前及之后生成的 id 是否均大于旧结构的 id、最早的安全切换时间、需要调整的起始时间以及溢出时间
+func PlanLayoutChange(ctx context.Context, oldConf config.RainDropConfig, newConf config.RainDropConfig, maxIssuedId int64) (*model.LayoutPlan, error) {
+	err := config.CheckConfig(ctx, &oldConf)
+	if err != nil {
+		return nil, fmt.Errorf("old config check fail: %w", err)
+	}
+	err = config.CheckConfig(ctx, &newConf)
+	if err != nil {
+		return nil, fmt.Errorf("new config check fail: %w", err)
+	}
+	plan := worker.PlanLayoutChange(oldConf, newConf, maxIssuedId, newConf.Clock.Now())
+	return &plan, nil
 }
 
 // NewId 获取新id
@@ -62,66 +255,131 @@ func NewIdContextByCode(ctx context.Context, code string) (int64, error) {
 	return worker.NewIdByCode(ctx, code)
 }
 
+// Parse 按当前配置的id结构解析id，获取生成时间、数据中心、工作节点等信息
+func Parse(id int64) model.IdInfo {
+	return worker.Parse(id)
+}
+
+// LookupOrigin 按当前配置的id结构解析id，根据worker分配历史找到生成该id时持有worker的节点，用于排查重复或可疑的id
+func LookupOrigin(ctx context.Context, id int64) (*model.IdOrigin, error) {
+	return worker.LookupOrigin(ctx, id)
+}
+
+// Status 获取生成器的运行状态：worker、租约、最近一次心跳结果、时间偏差、时间回拨位、当前时间戳流水的序列号使用率、
+// 生成的id数量、序列号耗尽次数及时间戳位的剩余可用时长，以及存活、就绪检查结果
+func Status(ctx context.Context) model.Status {
+	return worker.GetStatus(ctx)
+}
+
+// DriftStatus 获取心跳时估算的服务器与DB时间偏差，偏差超过 DriftThreshold 时按 DriftPolicy 处理
+func DriftStatus(ctx context.Context) model.DriftStatus {
+	return worker.GetDriftStatus(ctx)
+}
+
+// NtpStatus 获取启动及心跳时查询的服务器与NTP服务器时间偏差，未配置 NtpServers 时为空
+func NtpStatus(ctx context.Context) model.NtpStatus {
+	return worker.GetNtpStatus(ctx)
+}
+
+// ClockIncidents 获取当前进程最近的时钟异常：时钟回拨、服务器与DB时间差异过大及序列号耗尽
+func ClockIncidents(ctx context.Context) []model.ClockIncident {
+	return worker.ClockIncidents(ctx)
+}
+
+// QueryClockIncidents 查询当前服务命名空间全部节点在 [begin, end) 内记录的时钟异常，用于将重复id等问题与时钟异常关联
+func QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error) {
+	if db.Db == nil {
+		return nil, consts.ErrMsgDatabaseInitFail
+	}
+	return worker.QueryClockIncidents(ctx, begin, end)
+}
+
+// FreeWorkers 查询当前数据中心剩余的空闲worker数量，用于监控worker池是否即将耗尽
+func FreeWorkers(ctx context.Context) (int, error) {
+	if db.Db == nil {
+		return 0, consts.ErrMsgDatabaseInitFail
+	}
+	return worker.CountFreeWorkers(ctx)
+}
+
 // initLogger 初始化日志
-func initLogger(ctx context.Context, conf config.RainDropConfig) {
+func initLogger(ctx context.Context, conf *config.RainDropConfig) {
 	if conf.Logger != nil {
 		log = conf.Logger
 	} else {
-		log = logger.New(&logger.DefaultWriter{}, logger.Info, true)
+		log = logger.NewDefault()
+		conf.Logger = log
 	}
 }
 
 // initDb 初始化数据库
 func initDb(ctx context.Context, conf config.RainDropConfig) error {
 	var err error
-	if consts.DbTypeMySql == conf.DbConfig.DbType {
-		err = db.InitMySqlDb(ctx, conf.DbConfig, log)
-	} else if consts.DbTypePostgreSQL == conf.DbConfig.DbType {
-		err = db.InitPostgreSqlDb(ctx, conf.DbConfig, log)
+	if consts.DbTypeMemory == conf.DbConfig.DbType {
+		err = db.InitMemoryDb(ctx, conf.DbConfig, log)
 	} else {
-		err = db.InitPostgreSqlDb(ctx, conf.DbConfig, log)
+		err = db.InitSqlDb(ctx, conf.DbConfig, log)
 	}
 	if err != nil {
 		log.Error(ctx, err.Error(), err)
+		return &consts.DbError{Op: "connect", Err: err}
 	}
 
 	log.Debug(ctx, "raindrop database initialization completed.")
-	return err
+	return nil
 }
 
 // initRaindrop 初始化雨滴
 func initRaindrop(ctx context.Context, conf config.RainDropConfig) error {
-	err := checkDbTimeInterval(ctx)
+	if len(conf.NtpServers) == 0 {
+		// 配置了NTP服务器时已在连接数据库前校验时钟，不再校验DB时间
+		err := checkDbTimeInterval(ctx, conf)
+		if err != nil {
+			return err
+		}
+	}
+	err := initTableWorkers(ctx, conf)
 	if err != nil {
 		return err
 	}
-	err = db.InitTableWorkers(ctx, conf.ServiceMinWorkId, conf.ServiceMaxWorkId)
+	err = worker.Init(ctx, conf)
 	if err != nil {
 		log.Error(ctx, err.Error(), err)
 		return err
 	}
-	err = worker.Init(ctx, conf)
+	return nil
+}
+
+// initTableWorkers 执行表结构迁移并对账worker
+func initTableWorkers(ctx context.Context, conf config.RainDropConfig) error {
+	var disableBefore time.Time
+	if conf.DisableOutOfRangeWorkers {
+		disableBefore = worker.FreeHeartbeatTime(conf.Clock.Now(), conf.TimeUnit)
+	}
+	err := db.InitTableWorkers(ctx, conf.DatacenterId, conf.ServiceMinWorkId, conf.ServiceMaxWorkId, disableBefore)
 	if err != nil {
 		log.Error(ctx, err.Error(), err)
-		return err
+		return &consts.DbError{Op: "init table workers", Err: err}
 	}
 	return nil
 }
 
-// checkDbTimeInterval 校验服务器时间和db时间间隔
-func checkDbTimeInterval(ctx context.Context) error {
-	now := time.Now()
+// checkDbTimeInterval 校验服务器时间和db时间间隔，超过 DriftThreshold 时初始化失败
+func checkDbTimeInterval(ctx context.Context, conf config.RainDropConfig) error {
+	now := conf.Clock.Now()
 	dbNow, err := db.Db.GetNowTime(ctx)
 
 	if err != nil {
 		log.Error(ctx, "get database now time fail: "+err.Error(), err)
-		return err
+		return &consts.DbError{Op: "get now time", Err: err}
 	}
 
-	if now.Unix() > (dbNow.Unix()+consts.DatabaseTimeInterval) || now.Unix() < (dbNow.Unix()-consts.DatabaseTimeInterval) {
-		log.Error(ctx, consts.ErrMsgDatabaseServerTimeInterval.Error()+". system now time:"+now.String()+"; system now unix: "+strconv.FormatInt(now.Unix(), 10)+" ; db now time:"+dbNow.String()+"; db now unix: "+strconv.FormatInt(dbNow.Unix(), 10))
-		panic(consts.ErrMsgDatabaseServerTimeInterval.Error())
-		// return consts.ErrMsgDatabaseServerTimeInterval
+	offset := dbNow.Sub(now)
+	if offset > conf.DriftThreshold || offset < -conf.DriftThreshold {
+		err = &consts.ClockError{Source: consts.ClockIncidentDbDrift, Offset: offset, Threshold: conf.DriftThreshold,
+			TimeUnit: conf.TimeUnit, Err: consts.ErrMsgDatabaseServerTimeInterval}
+		log.Error(ctx, err.Error()+". system now time: "+now.String()+", db now time: "+dbNow.String(), err)
+		return err
 	}
 	return nil
 }
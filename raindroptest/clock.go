@@ -0,0 +1,42 @@
+package raindroptest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 手动时钟，只有调用 Set 或 Advance 时才会变化
+type Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewClock 创建手动时钟
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now 获取时钟当前时间
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// Set 设置时钟时间，可以设置为更早的时间以模拟时钟回拨
+func (c *Clock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = now
+}
+
+// Advance 时钟前进 d，d 为负数时模拟时钟回拨
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	return c.now
+}
@@ -0,0 +1,107 @@
+// Package raindroptest 提供基于内存数据库和手动时钟的 raindrop 生成器，
+// 便于在单元测试中推进时间、模拟时钟回拨、心跳和租约被抢占等场景。
+//
+// raindrop 的 worker 状态是进程级的，同一时刻只能有一个 Generator 生效，使用它的测试不能并行执行。
+package raindroptest
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// Generator 测试用 id 生成器，心跳和时间流水不会自动运行，需要通过 Heartbeat 和 Advance 驱动
+type Generator struct {
+	// Clock 手动时钟
+	Clock *Clock
+
+	// Db 内存数据库
+	Db *db.MemoryDb
+
+	conf config.RainDropConfig
+}
+
+// New 创建测试生成器并完成初始化，conf.Clock 不是 *Clock 时使用从当前时间开始的手动时钟
+func New(ctx context.Context, conf config.RainDropConfig) (*Generator, error) {
+	clock, ok := conf.Clock.(*Clock)
+	if !ok {
+		clock = NewClock(time.Now())
+	}
+	conf.Clock = clock
+	conf.DbConfig.DbType = consts.DbTypeMemory
+
+	g := &Generator{
+		Clock: clock,
+		Db:    db.NewMemoryDb(clock),
+		conf:  conf,
+	}
+	err := g.Restart(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Config 获取生成器使用的配置
+func (g *Generator) Config() config.RainDropConfig {
+	return g.conf
+}
+
+// Restart 模拟进程重启，基于同一个内存数据库重新初始化
+func (g *Generator) Restart(ctx context.Context) error {
+	return g.RestartWithConfig(ctx, g.conf)
+}
+
+// RestartWithConfig 使用新的配置模拟进程重启，时钟和内存数据库保持不变
+func (g *Generator) RestartWithConfig(ctx context.Context, conf config.RainDropConfig) error {
+	conf.Clock = g.Clock
+	conf.DbConfig.DbType = consts.DbTypeMemory
+	g.conf = conf
+
+	ctx = context.WithValue(ctx, consts.ProjectName, consts.SkipHeartbeat)
+	return raindrop.InitWithDb(ctx, conf, g.Db)
+}
+
+// NewId 获取新id
+func (g *Generator) NewId(ctx context.Context) (int64, error) {
+	return raindrop.NewIdContext(ctx)
+}
+
+// NewIdByCode 基于code获取新id
+func (g *Generator) NewIdByCode(ctx context.Context, code string) (int64, error) {
+	return raindrop.NewIdContextByCode(ctx, code)
+}
+
+// WorkerId 获取当前使用的workerId
+func (g *Generator) WorkerId(ctx context.Context) int64 {
+	return worker.GetWorkerId(ctx)
+}
+
+// Advance 时钟前进 d 并刷新时间流水，d 为负数时模拟时钟回拨
+func (g *Generator) Advance(ctx context.Context, d time.Duration) time.Time {
+	now := g.Clock.Advance(d)
+	worker.RefreshNowTimeSeq(ctx)
+	return now
+}
+
+// SetTime 设置时钟时间并刷新时间流水
+func (g *Generator) SetTime(ctx context.Context, now time.Time) {
+	g.Clock.Set(now)
+	worker.RefreshNowTimeSeq(ctx)
+}
+
+// Heartbeat 执行一次心跳
+func (g *Generator) Heartbeat(ctx context.Context) error {
+	return worker.Heartbeat(ctx)
+}
+
+// StealLease 模拟其他节点抢占当前workerId的租约，之后的心跳将返回 consts.ErrMsgWorkerLeaseLost
+func (g *Generator) StealLease(ctx context.Context, code string) (*model.RaindropWorker, error) {
+	return g.Db.StealWorker(ctx, worker.GetWorkerId(ctx), code)
+}
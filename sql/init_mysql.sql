@@ -1,17 +1,80 @@
 CREATE TABLE `soc_raindrop_worker` (
+   `service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '服务命名空间',
+   `datacenter_id` smallint NOT NULL DEFAULT '0' COMMENT '数据中心id',
    `id` bigint NOT NULL COMMENT 'id主键',
    `code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '编号',
    `time_unit` tinyint NOT NULL DEFAULT '2' COMMENT '时间单位，1：毫秒，2：秒（默认），3：分钟，4：小时，5：天',
+   `layout` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '最近一次激活的id结构指纹',
    `heartbeat_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '最后心跳时间',
    `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
    `update_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP COMMENT '更新时间',
    `version` bigint NOT NULL DEFAULT '1' COMMENT '乐观锁版本号',
-   `del_flag` tinyint NOT NULL DEFAULT '2' COMMENT '是否删除，1删除，2未删除',
-   PRIMARY KEY (`id`),
+   `del_flag` tinyint NOT NULL DEFAULT '2' COMMENT '是否删除，1删除，2未删除，3停用',
+   PRIMARY KEY (`service`, `datacenter_id`, `id`),
    KEY `idx_soc_raindrop_worker_heartbeat_time` (`heartbeat_time`),
    KEY `idx_soc_raindrop_worker_code` (`code`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='id生成节点';
 
+CREATE TABLE `soc_raindrop_worker_schema_version` (
+   `version` int NOT NULL COMMENT '迁移版本号',
+   `description` varchar(256) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '迁移描述',
+   `applied_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '执行时间',
+   PRIMARY KEY (`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='id生成节点表结构版本';
+
+CREATE TABLE `soc_raindrop_worker_service` (
+   `id_space` varchar(128) COLLATE utf8mb4_general_ci NOT NULL COMMENT 'id空间',
+   `service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '占用该id空间的服务命名空间',
+   `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+   PRIMARY KEY (`id_space`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='id空间登记';
+
+CREATE TABLE `soc_raindrop_worker_history` (
+   `id` bigint NOT NULL AUTO_INCREMENT COMMENT 'id主键',
+   `service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '服务命名空间',
+   `datacenter_id` smallint NOT NULL DEFAULT '0' COMMENT '数据中心id',
+   `worker_id` bigint NOT NULL COMMENT 'worker id',
+   `version` bigint NOT NULL COMMENT '事件发生后worker的版本号',
+   `event` varchar(16) COLLATE utf8mb4_general_ci NOT NULL COMMENT '事件，activate：激活，takeover：接管，release：释放，disable：停用',
+   `code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '事件发生后持有worker的编号',
+   `previous_code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '事件发生前持有worker的编号',
+   `layout` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT 'id结构指纹',
+   `time_seq` bigint NOT NULL DEFAULT '0' COMMENT '激活及接管时的时间戳位',
+   `event_time` datetime(3) NOT NULL COMMENT '事件发生时间',
+   `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+   PRIMARY KEY (`id`),
+   KEY `idx_soc_raindrop_worker_history_worker` (`service`, `datacenter_id`, `worker_id`, `event_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='id生成节点分配历史';
+
+CREATE TABLE `soc_raindrop_worker_clock_incident` (
+   `id` bigint NOT NULL AUTO_INCREMENT COMMENT 'id主键',
+   `service` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '服务命名空间',
+   `datacenter_id` smallint NOT NULL DEFAULT '0' COMMENT '数据中心id',
+   `worker_id` bigint NOT NULL COMMENT 'worker id',
+   `code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '持有worker的编号',
+   `kind` varchar(16) COLLATE utf8mb4_general_ci NOT NULL COMMENT '异常类型，backwards：时钟回拨，db_drift：与数据库时间偏差过大，ntp_drift：与NTP服务器时间偏差过大，seq_exhausted：序列号耗尽',
+   `id_code` varchar(128) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '' COMMENT '按编号生成id时的编号',
+   `last_time_seq` bigint NOT NULL DEFAULT '0' COMMENT '上次生成id时的时间戳位',
+   `time_seq` bigint NOT NULL DEFAULT '0' COMMENT '异常发生时的时间戳位',
+   `jump_ms` bigint NOT NULL DEFAULT '0' COMMENT '时钟回拨时长，毫秒',
+   `time_back_before` tinyint NOT NULL DEFAULT '0' COMMENT '回拨前的时间回拨位',
+   `time_back_after` tinyint NOT NULL DEFAULT '0' COMMENT '回拨后的时间回拨位',
+   `drift_ms` bigint NOT NULL DEFAULT '0' COMMENT '数据库或NTP服务器时间减服务器时间，毫秒',
+   `count` bigint NOT NULL DEFAULT '1' COMMENT '合并的次数',
+   `event_time` datetime(3) NOT NULL COMMENT '异常发生时间',
+   `create_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+   PRIMARY KEY (`id`),
+   KEY `idx_soc_raindrop_worker_clock_incident_time` (`service`, `event_time`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='时钟异常记录';
+
+INSERT INTO `soc_raindrop_worker_schema_version`(`version`, `description`)
+ VALUES (1, 'create worker table'),
+        (2, 'add service namespace'),
+        (3, 'add datacenter scope'),
+        (4, 'add layout fingerprint'),
+        (5, 'add worker history'),
+        (6, 'add clock incident');
+
 INSERT INTO `soc_raindrop_worker`(`id`, `heartbeat_time`)
  VALUES (1, '2023-01-01 00:00:00'),
         (2, '2023-01-01 00:00:00'),
@@ -1,22 +1,87 @@
 CREATE TABLE IF NOT EXISTS "soc_raindrop_worker" (
+  "service"              varchar(128)         not null default '',
+  "datacenter_id"        smallint             not null default '0',
   "id"                   bigint               not null,
   "code"                 varchar(128)         not null default '',
-  "lang_code"            varchar(128)         not null default '',
   "time_unit"            smallint             not null default '2',
+  "layout"               varchar(64)          not null default '',
   "heartbeat_time"       TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
   "create_time"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
   "update_time"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
   "version"              bigint               not null default '1',
-  "del_flag"             bool                 not null default false,
-constraint "PK_SOC_RAINDROP_WORKER" primary key ("id")
+  "del_flag"             smallint             not null default '2',
+constraint "PK_soc_raindrop_worker" primary key ("service", "datacenter_id", "id")
 );
-CREATE INDEX "idx_soc_raindrop_worker_hb_time" on "soc_raindrop_worker" (
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_hb_time" on "soc_raindrop_worker" (
   "heartbeat_time"
 );
-CREATE INDEX "idx_soc_raindrop_worker_code" on "soc_raindrop_worker" (
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_code" on "soc_raindrop_worker" (
   "code"
 );
 
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_schema_version" (
+  "version"              integer              not null,
+  "description"          varchar(256)         not null default '',
+  "applied_time"         TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
+constraint "PK_soc_raindrop_worker_schema_version" primary key ("version")
+);
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_service" (
+  "id_space"             varchar(128)         not null,
+  "service"              varchar(128)         not null default '',
+  "create_time"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
+constraint "PK_soc_raindrop_worker_service" primary key ("id_space")
+);
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_history" (
+  "id"                   bigserial            not null,
+  "service"              varchar(128)         not null default '',
+  "datacenter_id"        smallint             not null default '0',
+  "worker_id"            bigint               not null,
+  "version"              bigint               not null,
+  "event"                varchar(16)          not null,
+  "code"                 varchar(128)         not null default '',
+  "previous_code"        varchar(128)         not null default '',
+  "layout"               varchar(64)          not null default '',
+  "time_seq"             bigint               not null default '0',
+  "event_time"           TIMESTAMP WITH TIME ZONE not null,
+  "create_time"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
+constraint "PK_soc_raindrop_worker_history" primary key ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_history_worker" on "soc_raindrop_worker_history" (
+  "service", "datacenter_id", "worker_id", "event_time"
+);
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_clock_incident" (
+  "id"                   bigserial            not null,
+  "service"              varchar(128)         not null default '',
+  "datacenter_id"        smallint             not null default '0',
+  "worker_id"            bigint               not null,
+  "code"                 varchar(128)         not null default '',
+  "kind"                 varchar(16)          not null,
+  "id_code"              varchar(128)         not null default '',
+  "last_time_seq"        bigint               not null default '0',
+  "time_seq"             bigint               not null default '0',
+  "jump_ms"              bigint               not null default '0',
+  "time_back_before"     smallint             not null default '0',
+  "time_back_after"      smallint             not null default '0',
+  "drift_ms"             bigint               not null default '0',
+  "count"                bigint               not null default '1',
+  "event_time"           TIMESTAMP WITH TIME ZONE not null,
+  "create_time"          TIMESTAMP WITH TIME ZONE not null default CURRENT_TIMESTAMP,
+constraint "PK_soc_raindrop_worker_clock_incident" primary key ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_clock_incident_time" on "soc_raindrop_worker_clock_incident" (
+  "service", "event_time"
+);
+
+INSERT INTO "soc_raindrop_worker_schema_version"("version", "description")
+VALUES (1, 'create worker table'),
+       (2, 'drop unused lang_code column and store del_flag as smallint'),
+       (3, 'add service namespace'),
+       (4, 'add datacenter scope'),
+       (5, 'add layout fingerprint'),
+       (6, 'add worker history'),
+       (7, 'add clock incident')
+ON CONFLICT DO NOTHING;
+
 INSERT INTO "soc_raindrop_worker"("id", "heartbeat_time")
 VALUES (1, '2023-01-01 00:00:00'),
       (2, '2023-01-01 00:00:00'),
@@ -0,0 +1,77 @@
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker" (
+  "service"        VARCHAR(128) NOT NULL DEFAULT '',
+  "datacenter_id"  INTEGER      NOT NULL DEFAULT 0,
+  "id"             INTEGER      NOT NULL,
+  "code"           VARCHAR(128) NOT NULL DEFAULT '',
+  "time_unit"      SMALLINT     NOT NULL DEFAULT 2,
+  "layout"         VARCHAR(64)  NOT NULL DEFAULT '',
+  "heartbeat_time" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "create_time"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "update_time"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "version"        BIGINT       NOT NULL DEFAULT 1,
+  "del_flag"       SMALLINT     NOT NULL DEFAULT 2,
+  PRIMARY KEY ("service", "datacenter_id", "id")
+);
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_hb_time" ON "soc_raindrop_worker" ("heartbeat_time");
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_code" ON "soc_raindrop_worker" ("code");
+
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_schema_version" (
+  "version"      INTEGER      NOT NULL PRIMARY KEY,
+  "description"  VARCHAR(256) NOT NULL DEFAULT '',
+  "applied_time" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_service" (
+  "id_space"    VARCHAR(128) NOT NULL PRIMARY KEY,
+  "service"     VARCHAR(128) NOT NULL DEFAULT '',
+  "create_time" DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_history" (
+  "id"             INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+  "service"        VARCHAR(128) NOT NULL DEFAULT '',
+  "datacenter_id"  INTEGER      NOT NULL DEFAULT 0,
+  "worker_id"      INTEGER      NOT NULL,
+  "version"        BIGINT       NOT NULL,
+  "event"          VARCHAR(16)  NOT NULL,
+  "code"           VARCHAR(128) NOT NULL DEFAULT '',
+  "previous_code"  VARCHAR(128) NOT NULL DEFAULT '',
+  "layout"         VARCHAR(64)  NOT NULL DEFAULT '',
+  "time_seq"       BIGINT       NOT NULL DEFAULT 0,
+  "event_time"     DATETIME     NOT NULL,
+  "create_time"    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_history_worker" ON "soc_raindrop_worker_history" ("service", "datacenter_id", "worker_id", "event_time");
+CREATE TABLE IF NOT EXISTS "soc_raindrop_worker_clock_incident" (
+  "id"               INTEGER      NOT NULL PRIMARY KEY AUTOINCREMENT,
+  "service"          VARCHAR(128) NOT NULL DEFAULT '',
+  "datacenter_id"    INTEGER      NOT NULL DEFAULT 0,
+  "worker_id"        INTEGER      NOT NULL,
+  "code"             VARCHAR(128) NOT NULL DEFAULT '',
+  "kind"             VARCHAR(16)  NOT NULL,
+  "id_code"          VARCHAR(128) NOT NULL DEFAULT '',
+  "last_time_seq"    BIGINT       NOT NULL DEFAULT 0,
+  "time_seq"         BIGINT       NOT NULL DEFAULT 0,
+  "jump_ms"          BIGINT       NOT NULL DEFAULT 0,
+  "time_back_before" INTEGER      NOT NULL DEFAULT 0,
+  "time_back_after"  INTEGER      NOT NULL DEFAULT 0,
+  "drift_ms"         BIGINT       NOT NULL DEFAULT 0,
+  "count"            BIGINT       NOT NULL DEFAULT 1,
+  "event_time"       DATETIME     NOT NULL,
+  "create_time"      DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS "idx_soc_raindrop_worker_clock_incident_time" ON "soc_raindrop_worker_clock_incident" ("service", "event_time");
+
+INSERT OR IGNORE INTO "soc_raindrop_worker_schema_version"("version", "description")
+VALUES (1, 'create worker table'),
+       (2, 'add service namespace'),
+       (3, 'add datacenter scope'),
+       (4, 'add layout fingerprint'),
+       (5, 'add worker history'),
+       (6, 'add clock incident');
+
+INSERT OR IGNORE INTO "soc_raindrop_worker"("id", "heartbeat_time")
+VALUES (1, '2023-01-01 00:00:00'),
+       (2, '2023-01-01 00:00:00'),
+       (3, '2023-01-01 00:00:00'),
+       (4, '2023-01-01 00:00:00'),
+       (5, '2023-01-01 00:00:00'),
+       (6, '2023-01-01 00:00:00');
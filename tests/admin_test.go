@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/consts"
+)
+
+// TestAdminWorkers_Fence 停用或强制释放worker后，原持有节点下次心跳时失去租约并停止生成id，租约过期后仍不会恢复
+func TestAdminWorkers_Fence(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	fences := map[string]func(id int64, version int64) error{
+		"ForceRelease": func(id int64, version int64) error {
+			return raindrop.ForceRelease(ctx, id, version)
+		},
+		"DisableWorker": func(id int64, version int64) error {
+			return raindrop.DisableWorker(ctx, id, version)
+		},
+	}
+	for name, fence := range fences {
+		t.Run(name, func(t *testing.T) {
+			g := newTestGenerator(t, getTestSecondConfig())
+			_, err := g.NewId(ctx)
+			assert.NoError(t, err)
+
+			status := raindrop.Status(ctx)
+			assert.NoError(t, fence(status.WorkerId, status.Version))
+			assert.True(t, errors.Is(g.Heartbeat(ctx), consts.ErrMsgWorkerLeaseLost))
+			_, err = g.NewId(ctx)
+			assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+
+			g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval*5)*time.Second)
+			assert.True(t, errors.Is(g.Heartbeat(ctx), consts.ErrMsgWorkerLeaseLost))
+			_, err = g.NewIdByCode(ctx, "fence")
+			assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+			assert.False(t, raindrop.Status(ctx).Live)
+		})
+	}
+}
+
+// TestAdminWorkers 通过管理接口查看租约状态，停用、强制释放及按范围删除 worker
+func TestAdminWorkers(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	g := newTestGenerator(t, getTestSecondConfig())
+	workerId := g.WorkerId(ctx)
+
+	leases, err := raindrop.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, leases, maxWorkerId-minWorkerId+1)
+	var version int64
+	for _, l := range leases {
+		if l.Id == workerId {
+			assert.Equal(t, consts.WorkerStatusLeased, l.Status)
+			version = l.Version
+		} else {
+			assert.Equal(t, consts.WorkerStatusFree, l.Status)
+		}
+	}
+
+	// 旧版本号无法操作
+	err = raindrop.ForceRelease(ctx, workerId, version-1)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerVersionConflict))
+	assert.NoError(t, raindrop.ForceRelease(ctx, workerId, version))
+	assert.True(t, errors.Is(g.Heartbeat(ctx), consts.ErrMsgWorkerLeaseLost))
+
+	// 原持有节点的租约过期前不会被分配
+	leases, err = raindrop.ListWorkers(ctx)
+	assert.NoError(t, err)
+	for _, l := range leases {
+		if l.Id == workerId {
+			assert.Equal(t, consts.WorkerStatusReleased, l.Status)
+			assert.Equal(t, "", l.Code)
+		} else {
+			assert.Equal(t, consts.WorkerStatusFree, l.Status)
+		}
+		if l.Id != maxWorkerId {
+			assert.NoError(t, raindrop.DisableWorker(ctx, l.Id, l.Version))
+		}
+	}
+
+	// 未停用的 worker 不会被删除
+	count, err := raindrop.DeleteRange(ctx, minWorkerId+1, maxWorkerId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(maxWorkerId-minWorkerId-1), count)
+
+	leases, err = raindrop.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, leases, 2)
+	assert.Equal(t, consts.WorkerStatusDisabled, leases[0].Status)
+	assert.NoError(t, raindrop.EnableWorker(ctx, leases[0].Id, leases[0].Version))
+
+	// 重启对账时会补齐配置范围内被删除的 worker
+	g.Advance(ctx, time.Hour)
+	assert.NoError(t, g.Restart(ctx))
+	leases, err = raindrop.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, leases, maxWorkerId-minWorkerId+1)
+	for _, l := range leases {
+		if l.Id == g.WorkerId(ctx) {
+			assert.Equal(t, consts.WorkerStatusLeased, l.Status)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/treeyh/raindrop/config"
 	"github.com/treeyh/raindrop/consts"
 	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/raindroptest"
+	"testing"
 	"time"
 )
 
@@ -26,6 +28,9 @@ var (
 
 	_pgDbPool *pgxpool.Pool
 
+	// _testDbErr 测试数据库连接错误，不为空时跳过依赖真实数据库的用例
+	_testDbErr error
+
 	tableName = "soc_raindrop_worker"
 )
 
@@ -34,10 +39,26 @@ func init() {
 
 	dbConfig := getTestConfig()
 	if consts.DbTypeMySql == dbConfig.DbType {
-		initTestMySqlDb(ctx)
+		_testDbErr = initTestMySqlDb(ctx)
 	} else {
-		initTestPostgreSqlDb(ctx)
+		_testDbErr = initTestPostgreSqlDb(ctx)
+	}
+}
+
+// skipIfNoTestDb 测试数据库不可用时跳过
+func skipIfNoTestDb(t *testing.T) {
+	if _testDbErr != nil {
+		t.Skipf("%s skip, test database not available: %s", t.Name(), _testDbErr.Error())
+	}
+}
+
+// newTestGenerator 基于内存数据库和手动时钟创建生成器
+func newTestGenerator(t *testing.T, conf config.RainDropConfig) *raindroptest.Generator {
+	g, err := raindroptest.New(getTestContext(), conf)
+	if err != nil {
+		t.Fatalf("%s init generator fail. %s", t.Name(), err.Error())
 	}
+	return g
 }
 
 func getTestSkipHeartbeatContext() context.Context {
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+)
+
+// lastClockIncident 当前进程最近的一条时钟异常
+func lastClockIncident(t *testing.T) model.ClockIncident {
+	incidents := raindrop.ClockIncidents(getTestContext())
+	if len(incidents) == 0 {
+		t.Fatalf("%s no clock incident", t.Name())
+	}
+	return incidents[len(incidents)-1]
+}
+
+// TestClockIncident_Backwards 时钟回拨时记录回拨时长及时间回拨位的变化，并写入数据库
+func TestClockIncident_Backwards(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	g := newTestGenerator(t, getTestSecondConfig())
+	begin := g.Clock.Now()
+
+	_, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	g.Advance(ctx, -5*time.Second)
+	_, err = g.NewId(ctx)
+	assert.NoError(t, err)
+
+	incident := lastClockIncident(t)
+	assert.Equal(t, consts.ClockIncidentBackwards, incident.Kind)
+	assert.Equal(t, g.WorkerId(ctx), incident.WorkerId)
+	assert.NotEmpty(t, incident.Code)
+	assert.Equal(t, 5*time.Second, incident.Jump)
+	assert.Equal(t, int64(5), incident.LastTimeSeq-incident.TimeSeq)
+	assert.Equal(t, int64(0), incident.TimeBackBefore)
+	assert.Equal(t, int64(1), incident.TimeBackAfter)
+
+	_, err = g.NewIdByCode(ctx, "order")
+	assert.NoError(t, err)
+	g.Advance(ctx, -2*time.Second)
+	_, err = g.NewIdByCode(ctx, "order")
+	assert.NoError(t, err)
+	incident = lastClockIncident(t)
+	assert.Equal(t, "order", incident.IdCode)
+	assert.Equal(t, 2*time.Second, incident.Jump)
+
+	// 异步写入数据库，按服务器时间排序，第二次回拨发生在更早的时间
+	assert.Eventually(t, func() bool {
+		incidents, err := raindrop.QueryClockIncidents(ctx, begin.Add(-time.Minute), begin.Add(time.Minute))
+		return err == nil && len(incidents) == 2 && incidents[0].Jump == 2*time.Second && incidents[1].Jump == 5*time.Second
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestClockIncident_SeqExhausted 序列号耗尽在一段时间内只记录一次，期间的次数合并到下一条记录
+func TestClockIncident_SeqExhausted(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestMinuteConfig()
+	// 序列号 3 位，每分钟 8 个id
+	conf.TimeStampLength = 50
+	conf.EndBitsLength = 5
+	g := newTestGenerator(t, conf)
+
+	exhaust := func() {
+		for i := 0; i < 8; i++ {
+			_, err := g.NewId(ctx)
+			assert.NoError(t, err)
+		}
+		for i := 0; i < 2; i++ {
+			_, err := g.NewId(ctx)
+			assert.True(t, errors.Is(err, consts.ErrMsgIdSeqReachesMaxValueError))
+		}
+	}
+
+	exhaust()
+	incident := lastClockIncident(t)
+	assert.Equal(t, consts.ClockIncidentSeqExhausted, incident.Kind)
+	assert.Equal(t, int64(1), incident.Count)
+
+	g.Advance(ctx, consts.ClockIncidentBurstInterval+time.Minute)
+	exhaust()
+	incident = lastClockIncident(t)
+	assert.Equal(t, consts.ClockIncidentSeqExhausted, incident.Kind)
+	assert.Equal(t, int64(2), incident.Count)
+	assert.Equal(t, g.Clock.Now(), incident.EventTime)
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+)
+
+// writeTestConfigFile 在临时目录写入配置文件
+func writeTestConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config file fail. %s", err.Error())
+	}
+	return path
+}
+
+// getTestConfigErrorFields 获取全部校验失败的配置项
+func getTestConfigErrorFields(err error) []string {
+	var fields []string
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+	for _, e := range errs {
+		var configErr *consts.ConfigError
+		if errors.As(e, &configErr) {
+			fields = append(fields, configErr.Field)
+		}
+	}
+	return fields
+}
+
+// TestLoad_Yaml YAML 配置文件支持时间单位名称、RFC3339 起始时间及 30s 格式的时长
+func TestLoad_Yaml(t *testing.T) {
+	path := writeTestConfigFile(t, "raindrop.yaml", `
+dbConfig:
+  dbType: memory
+  tableName: soc_raindrop_worker
+  retry:
+    maxAttempts: 5
+    initialBackoff: 50ms
+timeUnit: millisecond
+startTimeStamp: 2023-03-01T00:00:00Z
+timeStampLength: 41
+workIdLength: 5
+driftThreshold: 10s
+ntpServers: [time.example.com, "time2.example.com:123"]
+logRateLimit:
+  burst: 5
+endBitsLength: 1
+endBitValue: 1
+`)
+	conf, err := config.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, consts.DbTypeMemory, conf.DbConfig.DbType)
+	assert.Equal(t, 5, conf.DbConfig.Retry.MaxAttempts)
+	assert.Equal(t, 50*time.Millisecond, conf.DbConfig.Retry.InitialBackoff)
+	assert.Equal(t, consts.TimeUnitMillisecond, conf.TimeUnit)
+	assert.True(t, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC).Equal(conf.StartTimeStamp))
+	assert.Equal(t, 10*time.Second, conf.DriftThreshold)
+	assert.Equal(t, []string{"time.example.com", "time2.example.com:123"}, conf.NtpServers)
+	assert.Equal(t, 5, conf.LogRateLimit.Burst)
+	assert.Equal(t, 1, conf.EndBitsValue)
+
+	assert.NoError(t, config.CheckConfig(context.Background(), &conf))
+	assert.Equal(t, int64(1), conf.ServiceMinWorkId)
+	assert.Equal(t, int64(31), conf.ServiceMaxWorkId)
+	assert.Equal(t, consts.DriftPolicyWarn, conf.DriftPolicy)
+
+	_, err = config.Load(writeTestConfigFile(t, "raindrop.yaml", "timeUnit: week\n"))
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+	_, err = config.Load(writeTestConfigFile(t, "raindrop.yaml", "endBitValues: 1\n"))
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+}
+
+// TestLoad_EndBitsValueAlias 兼容 endBitsValue 的写法，与 endBitValue 同时设置时返回错误
+func TestLoad_EndBitsValueAlias(t *testing.T) {
+	conf, err := config.Load(writeTestConfigFile(t, "raindrop.yaml", "endBitsLength: 2\nendBitsValue: 3\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, conf.EndBitsValue)
+
+	conf, err = config.Load(writeTestConfigFile(t, "raindrop.json", `{"endBitsLength": 2, "endBitsValue": 2}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, conf.EndBitsValue)
+
+	_, err = config.Load(writeTestConfigFile(t, "raindrop.yaml", "endBitValue: 1\nendBitsValue: 2\n"))
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+}
+
+// TestLoad_Json JSON 配置文件与 json.Marshal 的输出一致，时间单位兼容数值
+func TestLoad_Json(t *testing.T) {
+	conf := getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeMemory
+	conf.EndBitsLength = 1
+	conf.EndBitsValue = 1
+	data, err := json.Marshal(conf)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"timeUnit":"second"`)
+	assert.Contains(t, string(data), `"endBitValue":1`)
+
+	loaded, err := config.Load(writeTestConfigFile(t, "raindrop.json", string(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, conf.TimeUnit, loaded.TimeUnit)
+	assert.True(t, conf.StartTimeStamp.Equal(loaded.StartTimeStamp))
+	assert.Equal(t, conf.DbConfig.DbType, loaded.DbConfig.DbType)
+	assert.Equal(t, 1, loaded.EndBitsValue)
+
+	loaded, err = config.Load(writeTestConfigFile(t, "raindrop.json", `{"timeUnit": 3, "startTimeStamp": "2023-03-01T00:00:00+08:00"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, consts.TimeUnitMinute, loaded.TimeUnit)
+}
+
+// TestFromEnv 环境变量覆盖对应的配置，格式错误的环境变量一次全部返回
+func TestFromEnv(t *testing.T) {
+	t.Setenv("TEST_RAINDROP_DB_TYPE", consts.DbTypeMemory)
+	t.Setenv("TEST_RAINDROP_DB_RETRY_MAX_BACKOFF", "3s")
+	t.Setenv("TEST_RAINDROP_TIME_UNIT", "Minute")
+	t.Setenv("TEST_RAINDROP_START_TIME_STAMP", "2023-03-01T00:00:00Z")
+	t.Setenv("TEST_RAINDROP_SERVICE_MIN_WORK_ID", "2")
+	t.Setenv("TEST_RAINDROP_SERVICE_MAX_WORK_ID", "9")
+	t.Setenv("TEST_RAINDROP_PRIORITY_EQUAL_CODE_WORK_ID", "true")
+	t.Setenv("TEST_RAINDROP_NTP_SERVERS", "a.example.com, b.example.com")
+	t.Setenv("TEST_RAINDROP_LOG_RATE_LIMIT_INTERVAL", "2s")
+	conf, err := config.FromEnv("TEST_RAINDROP")
+	assert.NoError(t, err)
+	assert.Equal(t, consts.DbTypeMemory, conf.DbConfig.DbType)
+	assert.Equal(t, 3*time.Second, conf.DbConfig.Retry.MaxBackoff)
+	assert.Equal(t, consts.TimeUnitMinute, conf.TimeUnit)
+	assert.True(t, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC).Equal(conf.StartTimeStamp))
+	assert.Equal(t, int64(2), conf.ServiceMinWorkId)
+	assert.Equal(t, int64(9), conf.ServiceMaxWorkId)
+	assert.True(t, conf.PriorityEqualCodeWorkId)
+	assert.Equal(t, []string{"a.example.com", "b.example.com"}, conf.NtpServers)
+	assert.Equal(t, 2*time.Second, conf.LogRateLimit.Interval)
+
+	t.Setenv("TEST_RAINDROP_TIME_UNIT", "week")
+	t.Setenv("TEST_RAINDROP_DRIFT_THRESHOLD", "30")
+	_, err = config.FromEnv("TEST_RAINDROP")
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+	assert.ElementsMatch(t, []string{"TEST_RAINDROP_TIME_UNIT", "TEST_RAINDROP_DRIFT_THRESHOLD"}, getTestConfigErrorFields(err))
+}
+
+// TestCheckConfig_AllErrors 校验失败时返回全部校验失败的配置项
+func TestCheckConfig_AllErrors(t *testing.T) {
+	conf := getTestSecondConfig()
+	conf.ServicePort = -1
+	conf.DatacenterIdLength = 6
+	conf.DriftPolicy = "ignore"
+	conf.TimeBackBitValue = 2
+	err := config.CheckConfig(context.Background(), &conf)
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+	var configErr *consts.ConfigError
+	assert.True(t, errors.As(err, &configErr))
+	assert.Equal(t, "ServicePort", configErr.Field)
+	assert.Equal(t, []string{"ServicePort", "DatacenterIdLength", "DriftPolicy", "TimeBackBitValue"}, getTestConfigErrorFields(err))
+}
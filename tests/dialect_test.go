@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop/db"
+	"strings"
+	"testing"
+)
+
+// TestDialectTableNames 表名带 schema 前缀，索引和约束名由表名生成
+func TestDialectTableNames(t *testing.T) {
+	table := db.Table{Schema: "svc", Name: "raindrop_worker"}
+
+	pg := db.PostgreSqlDialect{}
+	assert.Equal(t, `"svc"."raindrop_worker"`, pg.QuoteTable(table))
+	migrations := pg.Migrations(table)
+	ddl := strings.Join(migrations[0].Sql, "\n")
+	assert.Contains(t, ddl, `CREATE TABLE IF NOT EXISTS "svc"."raindrop_worker"`)
+	assert.Contains(t, ddl, `"PK_raindrop_worker"`)
+	// 已发布的 v1 迁移保持不变，索引名由新的迁移版本重命名
+	assert.Contains(t, ddl, `"idx_soc_raindrop_worker_hb_time" on "svc"."raindrop_worker"`)
+	ddl = strings.Join(migrations[len(migrations)-1].Sql, "\n")
+	assert.Contains(t, ddl, `ALTER INDEX "svc"."idx_soc_raindrop_worker_hb_time" RENAME TO "idx_raindrop_worker_hb_time"`)
+	assert.Contains(t, ddl, `"idx_raindrop_worker_hb_time" on "svc"."raindrop_worker"`)
+	assert.Contains(t, ddl, `"idx_raindrop_worker_code" on "svc"."raindrop_worker"`)
+	s, args := pg.ExistTableSql(table)
+	assert.Contains(t, s, "schemaname")
+	assert.Equal(t, []interface{}{"svc", "raindrop_worker"}, args)
+
+	mysql := db.MySqlDialect{}
+	assert.Equal(t, "`svc`.`raindrop_worker`", mysql.QuoteTable(table))
+	migrations = mysql.Migrations(table)
+	ddl = strings.Join(migrations[0].Sql, "\n")
+	assert.Contains(t, ddl, "CREATE TABLE IF NOT EXISTS `svc`.`raindrop_worker`")
+	assert.Contains(t, ddl, "`idx_soc_raindrop_worker_heartbeat_time`")
+	ddl = strings.Join(migrations[len(migrations)-1].Sql, "\n")
+	assert.Contains(t, ddl, "RENAME INDEX `idx_soc_raindrop_worker_heartbeat_time` TO `idx_raindrop_worker_heartbeat_time`")
+	assert.Contains(t, ddl, "RENAME INDEX `idx_soc_raindrop_worker_code` TO `idx_raindrop_worker_code`")
+	// 默认表名的索引名不变，无需重命名
+	migrations = mysql.Migrations(db.Table{Name: "soc_raindrop_worker"})
+	assert.Empty(t, migrations[len(migrations)-1].Sql)
+	_, args = mysql.ExistTableSql(table)
+	assert.Equal(t, []interface{}{"svc", "raindrop_worker", "BASE TABLE"}, args)
+
+	assert.Equal(t, `"raindrop_worker"`, pg.QuoteTable(db.Table{Name: "raindrop_worker"}))
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/raindroptest"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// driftMemoryDb DB时间比服务器时间快 offset 的内存数据库
+type driftMemoryDb struct {
+	*db.MemoryDb
+
+	offset time.Duration
+}
+
+// GetNowTime 获取DB当前时间
+func (d *driftMemoryDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	now, err := d.MemoryDb.GetNowTime(ctx)
+	return now.Add(d.offset), err
+}
+
+// initDriftTest 使用时间可偏移的内存数据库初始化
+func initDriftTest(t *testing.T, policy string) (context.Context, *driftMemoryDb) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.Clock = raindroptest.NewClock(time.Now())
+	conf.DbConfig = config.RainDropDbConfig{DbType: consts.DbTypeMemory, TableName: tableName}
+	conf.DriftPolicy = policy
+	d := &driftMemoryDb{MemoryDb: db.NewMemoryDb(conf.Clock)}
+	if err := raindrop.InitWithDb(ctx, conf, d); err != nil {
+		t.Fatalf("%s init fail. %s", t.Name(), err.Error())
+	}
+	return ctx, d
+}
+
+// TestDrift_Pause 平滑后的偏差超过阈值时暂停生成id，偏差恢复后继续
+func TestDrift_Pause(t *testing.T) {
+	ctx, d := initDriftTest(t, consts.DriftPolicyPause)
+
+	assert.NoError(t, worker.Heartbeat(ctx))
+	status := raindrop.DriftStatus(ctx)
+	assert.Equal(t, int64(1), status.Samples)
+	assert.Equal(t, time.Duration(0), status.Offset)
+	assert.Equal(t, consts.DatabaseTimeInterval*time.Second, status.Threshold)
+
+	// 单次采样不会立即超过阈值：0.2 * 120s = 24s
+	d.offset = 2 * time.Minute
+	assert.NoError(t, worker.Heartbeat(ctx))
+	status = raindrop.DriftStatus(ctx)
+	assert.Equal(t, 2*time.Minute, status.LastSample)
+	assert.Equal(t, 24*time.Second, status.Offset)
+	assert.False(t, status.Exceeded)
+	_, err := raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+
+	// 24s + 0.2 * 96s = 43.2s
+	assert.NoError(t, worker.Heartbeat(ctx))
+	status = raindrop.DriftStatus(ctx)
+	assert.True(t, status.Exceeded)
+	assert.True(t, status.Stopped)
+	_, err = raindrop.NewIdContext(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgClockDriftExceeded))
+	incidents := raindrop.ClockIncidents(ctx)
+	assert.Equal(t, consts.ClockIncidentDbDrift, incidents[len(incidents)-1].Kind)
+	assert.Equal(t, status.Offset, incidents[len(incidents)-1].Drift)
+
+	// 偏差恢复：43.2s -> 34.56s -> 27.648s
+	d.offset = 0
+	assert.NoError(t, worker.Heartbeat(ctx))
+	assert.True(t, raindrop.DriftStatus(ctx).Stopped)
+	assert.NoError(t, worker.Heartbeat(ctx))
+	assert.False(t, raindrop.DriftStatus(ctx).Stopped)
+	_, err = raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+}
+
+// TestDrift_Release 偏差超过阈值时释放worker，之后不再续约及生成id
+func TestDrift_Release(t *testing.T) {
+	ctx, d := initDriftTest(t, consts.DriftPolicyRelease)
+	workerId := worker.GetWorkerId(ctx)
+
+	d.offset = -time.Hour
+	assert.NoError(t, worker.Heartbeat(ctx))
+	status := raindrop.DriftStatus(ctx)
+	assert.Equal(t, -time.Hour, status.Offset)
+	assert.True(t, status.Stopped)
+
+	_, err := raindrop.NewIdContext(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgClockDriftExceeded))
+	assert.True(t, errors.Is(worker.Heartbeat(ctx), consts.ErrMsgClockDriftExceeded))
+	for _, w := range d.Workers() {
+		if w.Id == workerId {
+			assert.Empty(t, w.Code)
+		}
+	}
+
+	// 偏差恢复后仍不生成id
+	d.offset = 0
+	_, err = raindrop.NewIdContext(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgClockDriftExceeded))
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/consts"
+)
+
+// TestLookupOrigin worker 被重复使用后，仍能根据分配历史找到生成 id 的节点
+func TestLookupOrigin(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.ServiceMaxWorkId = minWorkerId
+	g := newTestGenerator(t, conf)
+
+	id1, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	origin, err := raindrop.LookupOrigin(ctx, id1)
+	assert.NoError(t, err)
+	assert.Equal(t, consts.WorkerEventActivate, origin.Owner.Event)
+	assert.Equal(t, int64(minWorkerId), origin.Owner.WorkerId)
+	assert.NotEmpty(t, origin.Owner.Code)
+	assert.Equal(t, int64(-1), origin.EndTimeSeq)
+	assert.True(t, origin.InRange)
+	code := origin.Owner.Code
+
+	// 其他节点持有后租约过期，被当前节点接管
+	_, err = g.StealLease(ctx, "other")
+	assert.NoError(t, err)
+	g.Advance(ctx, 10*time.Minute)
+	assert.NoError(t, g.Restart(ctx))
+	id2, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	origin, err = raindrop.LookupOrigin(ctx, id2)
+	assert.NoError(t, err)
+	assert.Equal(t, consts.WorkerEventTakeover, origin.Owner.Event)
+	assert.Equal(t, "other", origin.Owner.PreviousCode)
+	assert.Equal(t, code, origin.Owner.Code)
+
+	origin, err = raindrop.LookupOrigin(ctx, id1)
+	assert.NoError(t, err)
+	assert.Equal(t, consts.WorkerEventActivate, origin.Owner.Event)
+	assert.Greater(t, origin.EndTimeSeq, origin.Owner.TimeSeq)
+	assert.True(t, origin.InRange)
+
+	// 强制释放后生成的 id 不在持有范围内
+	leases, err := raindrop.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, raindrop.ForceRelease(ctx, minWorkerId, leases[0].Version))
+	g.Advance(ctx, time.Minute)
+	id3, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	origin, err = raindrop.LookupOrigin(ctx, id3)
+	assert.NoError(t, err)
+	assert.Equal(t, consts.WorkerEventTakeover, origin.Owner.Event)
+	assert.False(t, origin.InRange)
+
+	// 早于首次激活的 id 没有来源
+	_, err = raindrop.LookupOrigin(ctx, id1&(1<<(consts.IdBitLength-conf.TimeStampLength)-1))
+	assert.True(t, errors.Is(err, consts.ErrMsgIdOriginNotFound))
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/raindroptest"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// getTestLayoutConfig 变更计划测试使用的配置
+func getTestLayoutConfig(now time.Time, timeUnit consts.TimeUnit, start time.Time, timeStampLength int) config.RainDropConfig {
+	conf := getTestSecondConfig()
+	conf.Clock = raindroptest.NewClock(now)
+	conf.TimeUnit = timeUnit
+	conf.StartTimeStamp = start
+	conf.TimeStampLength = timeStampLength
+	return conf
+}
+
+// TestPlanLayoutChange_Safe 秒切换为毫秒，新结构当前的 id 已大于旧结构
+func TestPlanLayoutChange_Safe(t *testing.T) {
+	ctx := getTestContext()
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	oldConf := getTestLayoutConfig(now, consts.TimeUnitSecond, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), 35)
+	newConf := getTestLayoutConfig(now, consts.TimeUnitMillisecond, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), 41)
+
+	maxIssuedId := worker.MaxIdAt(oldConf, now)
+	plan, err := raindrop.PlanLayoutChange(ctx, oldConf, newConf, maxIssuedId)
+	assert.NoError(t, err)
+	assert.True(t, plan.Safe)
+	assert.Equal(t, now, plan.CutoverTime)
+	assert.Greater(t, plan.NewMinIdNow, plan.MaxIssuedId)
+	assert.Equal(t, int64(0), plan.EpochAdjustment)
+	assert.WithinDuration(t, newConf.StartTimeStamp, plan.SuggestedStartTimeStamp, 0)
+	assert.Empty(t, plan.Warnings)
+}
+
+// TestPlanLayoutChange_Cutover 新结构的 id 增长更快，到切换时间后才安全，或提前起始时间后立即安全
+func TestPlanLayoutChange_Cutover(t *testing.T) {
+	ctx := getTestContext()
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	oldConf := getTestLayoutConfig(now, consts.TimeUnitMillisecond, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 42)
+	newConf := getTestLayoutConfig(now, consts.TimeUnitMillisecond, time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), 41)
+
+	plan, err := raindrop.PlanLayoutChange(ctx, oldConf, newConf, 0)
+	assert.NoError(t, err)
+	assert.False(t, plan.Safe)
+	assert.True(t, plan.CutoverTime.After(now))
+
+	// 切换时间安全，前一个时间单位不安全
+	assert.Greater(t, worker.MinIdAt(newConf, plan.CutoverTime), worker.MaxIdAt(oldConf, plan.CutoverTime))
+	before := plan.CutoverTime.Add(-time.Millisecond)
+	assert.LessOrEqual(t, worker.MinIdAt(newConf, before), worker.MaxIdAt(oldConf, before))
+
+	// 按建议的起始时间立即切换是安全的
+	assert.Greater(t, plan.EpochAdjustment, int64(0))
+	adjusted := newConf
+	adjusted.StartTimeStamp = plan.SuggestedStartTimeStamp
+	assert.Greater(t, worker.MinIdAt(adjusted, now), plan.OldMaxIdNow)
+	assert.WithinDuration(t, newConf.StartTimeStamp.Add(-time.Duration(plan.EpochAdjustment)*time.Millisecond), plan.SuggestedStartTimeStamp, 0)
+}
+
+// TestPlanLayoutChange_Overflow 新结构无法生成更大的 id，且时间戳位会溢出
+func TestPlanLayoutChange_Overflow(t *testing.T) {
+	ctx := getTestContext()
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	oldConf := getTestLayoutConfig(now, consts.TimeUnitMillisecond, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), 41)
+	newConf := getTestLayoutConfig(now, consts.TimeUnitSecond, time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), 31)
+
+	plan, err := raindrop.PlanLayoutChange(ctx, oldConf, newConf, math.MaxInt64)
+	assert.NoError(t, err)
+	assert.False(t, plan.Safe)
+	assert.True(t, plan.CutoverTime.IsZero())
+	assert.True(t, plan.SuggestedStartTimeStamp.IsZero())
+	assert.WithinDuration(t, time.Date(2068, 1, 19, 3, 14, 8, 0, time.UTC), plan.OverflowTime, 0)
+	assert.Len(t, plan.Warnings, 2)
+
+	// 新结构已溢出
+	newConf.StartTimeStamp = time.Date(1950, 1, 1, 0, 0, 0, 0, time.UTC)
+	plan, err = raindrop.PlanLayoutChange(ctx, oldConf, newConf, 0)
+	assert.NoError(t, err)
+	assert.Contains(t, plan.Warnings, "new layout has already overflowed at "+plan.OverflowTime.Format(time.RFC3339))
+}
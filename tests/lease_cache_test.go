@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/raindroptest"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// downMemoryDb 可模拟不可用的内存数据库，不可用时返回连接错误
+type downMemoryDb struct {
+	*db.MemoryDb
+
+	down atomic.Bool
+}
+
+// GetNowTime 获取DB当前时间
+func (d *downMemoryDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	if d.down.Load() {
+		return time.Time{}, driver.ErrBadConn
+	}
+	return d.MemoryDb.GetNowTime(ctx)
+}
+
+// Migrate 执行表结构迁移
+func (d *downMemoryDb) Migrate(ctx context.Context) error {
+	if d.down.Load() {
+		return driver.ErrBadConn
+	}
+	return d.MemoryDb.Migrate(ctx)
+}
+
+// HeartbeatWorker 心跳
+func (d *downMemoryDb) HeartbeatWorker(ctx context.Context, worker *model.RaindropWorker) (*model.RaindropWorker, error) {
+	if d.down.Load() {
+		return nil, driver.ErrBadConn
+	}
+	return d.MemoryDb.HeartbeatWorker(ctx, worker)
+}
+
+// initLeaseCacheTest 数据库可用时初始化并写入租约缓存，之后数据库不可用，返回重启使用的配置
+func initLeaseCacheTest(t *testing.T) (context.Context, config.RainDropConfig, *downMemoryDb, *raindroptest.Clock) {
+	ctx := getTestSkipHeartbeatContext()
+	clock := raindroptest.NewClock(time.Now())
+	conf := getTestSecondConfig()
+	conf.Clock = clock
+	conf.DbConfig = config.RainDropDbConfig{DbType: consts.DbTypeMemory, TableName: tableName, Retry: config.RetryPolicy{MaxAttempts: 1}}
+	conf.LeaseCacheFile = filepath.Join(t.TempDir(), "raindrop.lease")
+	d := &downMemoryDb{MemoryDb: db.NewMemoryDb(clock)}
+	if err := raindrop.InitWithDb(ctx, conf, d); err != nil {
+		t.Fatalf("%s init fail. %s", t.Name(), err.Error())
+	}
+	if _, err := os.Stat(conf.LeaseCacheFile); err != nil {
+		t.Fatalf("%s lease cache not saved. %s", t.Name(), err.Error())
+	}
+	d.down.Store(true)
+	return ctx, conf, d, clock
+}
+
+// TestLeaseCache_DegradedStart 数据库不可用时基于租约缓存降级启动，时间回拨位取反，数据库恢复后确认租约退出降级
+func TestLeaseCache_DegradedStart(t *testing.T) {
+	ctx, conf, d, clock := initLeaseCacheTest(t)
+	data, err := os.ReadFile(conf.LeaseCacheFile)
+	assert.NoError(t, err)
+	var cache model.LeaseCache
+	assert.NoError(t, json.Unmarshal(data, &cache))
+
+	clock.Advance(10 * time.Second)
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, d))
+	degraded, expireTime := raindrop.Degraded(ctx)
+	assert.True(t, degraded)
+	assert.Equal(t, cache.LeaseExpireTime.UnixMilli(), expireTime.UnixMilli())
+	assert.True(t, expireTime.After(clock.Now()))
+
+	assert.Equal(t, cache.Worker.Id, worker.GetWorkerId(ctx))
+	assert.Equal(t, cache.TimeBackValue^1, worker.GetTimeBackBitValue(ctx))
+	id, err := raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Worker.Id, raindrop.Parse(id).WorkerId)
+
+	assert.True(t, errors.Is(raindrop.Reconnect(ctx), driver.ErrBadConn))
+	degraded, _ = raindrop.Degraded(ctx)
+	assert.True(t, degraded)
+
+	d.down.Store(false)
+	assert.NoError(t, raindrop.Reconnect(ctx))
+	degraded, expireTime = raindrop.Degraded(ctx)
+	assert.False(t, degraded)
+	assert.True(t, expireTime.IsZero())
+	_, err = raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+}
+
+// TestLeaseCache_Expired 降级运行时租约到期后停止生成id，数据库恢复时worker已被其他节点激活则租约丢失
+func TestLeaseCache_Expired(t *testing.T) {
+	ctx, conf, d, clock := initLeaseCacheTest(t)
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, d))
+
+	clock.Advance(consts.HeartbeatTimeInterval * 4 * time.Second)
+	_, err := raindrop.NewIdContext(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgLeaseExpired))
+
+	// 其他节点在租约到期后激活了该worker
+	w, err := d.GetWorkerById(ctx, worker.GetWorkerId(ctx))
+	assert.NoError(t, err)
+	assert.NoError(t, d.ReleaseWorker(ctx, w.Id, w.Version))
+	d.down.Store(false)
+	assert.True(t, errors.Is(raindrop.Reconnect(ctx), consts.ErrMsgWorkerLeaseLost))
+	_, err = raindrop.NewIdContext(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+	_, err = os.Stat(conf.LeaseCacheFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// TestLeaseCache_Invalid 租约缓存已过期时无法降级启动，返回数据库错误
+func TestLeaseCache_Invalid(t *testing.T) {
+	ctx, conf, d, clock := initLeaseCacheTest(t)
+
+	clock.Advance(consts.HeartbeatTimeInterval * 4 * time.Second)
+	err := raindrop.InitWithDb(ctx, conf, d)
+	assert.True(t, errors.Is(err, driver.ErrBadConn))
+
+	// 未配置租约缓存文件时不降级
+	clock.Set(clock.Now().Add(-consts.HeartbeatTimeInterval * 4 * time.Second))
+	conf.LeaseCacheFile = ""
+	err = raindrop.InitWithDb(ctx, conf, d)
+	assert.True(t, errors.Is(err, driver.ErrBadConn))
+}
+
+// TestLeaseCache_IssuedTimeSeq 时钟回拨后租约缓存记录已生成id的最大时间流水，时钟仍早于该值时无法降级启动
+func TestLeaseCache_IssuedTimeSeq(t *testing.T) {
+	ctx, conf, d, clock := initLeaseCacheTest(t)
+	d.down.Store(false)
+	_, err := raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+	issued := worker.GetNowTimeSeq(ctx)
+
+	clock.Advance(-5 * time.Second)
+	assert.NoError(t, worker.RefreshNowTimeSeq(ctx))
+	_, err = raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Heartbeat(ctx))
+	data, err := os.ReadFile(conf.LeaseCacheFile)
+	assert.NoError(t, err)
+	var cache model.LeaseCache
+	assert.NoError(t, json.Unmarshal(data, &cache))
+	assert.Equal(t, issued, cache.LastTimeSeq)
+
+	d.down.Store(true)
+	err = raindrop.InitWithDb(ctx, conf, d)
+	assert.True(t, errors.Is(err, driver.ErrBadConn))
+	degraded, _ := raindrop.Degraded(ctx)
+	assert.False(t, degraded)
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop/logger"
+)
+
+// 仅日志限流测试使用的 code，按 code 生成的状态在重新初始化后保留
+const rateLimitCode = "rate_limit"
+
+// newTestSlogLogger 创建输出 JSON 到 buf 的 slog 日志
+func newTestSlogLogger(buf *bytes.Buffer, level slog.Level) logger.ILogger {
+	return logger.NewSlog(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: true, Level: level})))
+}
+
+// TestSlog_Attrs slog.Attr 原样输出，error 输出为 err 属性，字符串与其后的参数组成键值对，调用位置为调用方
+func TestSlog_Attrs(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := newTestSlogLogger(buf, slog.LevelInfo)
+	l.Error(context.Background(), "heartbeat worker fail!!!", logger.WorkerId(3), logger.Table("raindrop_worker"),
+		errors.New("lease lost"), "count", 0)
+
+	record := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "ERROR", record[slog.LevelKey])
+	assert.Equal(t, "heartbeat worker fail!!!", record[slog.MessageKey])
+	assert.Equal(t, float64(3), record[logger.KeyWorkerId])
+	assert.Equal(t, "raindrop_worker", record[logger.KeyTable])
+	assert.Equal(t, "lease lost", record[logger.KeyErr])
+	assert.Equal(t, float64(0), record["count"])
+	source, _ := record[slog.SourceKey].(map[string]interface{})
+	assert.Contains(t, source["file"], "logger_test.go")
+}
+
+// TestSlog_Level 日志级别默认由 handler 决定，LogMode 设置后低于该级别的日志不输出
+func TestSlog_Level(t *testing.T) {
+	ctx := context.Background()
+	buf := &bytes.Buffer{}
+	l := newTestSlogLogger(buf, slog.LevelInfo)
+	assert.Equal(t, logger.Info, l.GetLogLevel())
+	l.Debug(ctx, "debug")
+	assert.Zero(t, buf.Len())
+	l.Info(ctx, "info")
+	assert.NotZero(t, buf.Len())
+
+	buf.Reset()
+	l = l.LogMode(logger.Error)
+	assert.Equal(t, logger.Error, l.GetLogLevel())
+	l.Warn(ctx, "warn")
+	assert.Zero(t, buf.Len())
+	assert.Panics(t, func() {
+		l.Fatal(ctx, "fatal")
+	})
+	assert.Contains(t, buf.String(), `"level":"ERROR+4"`)
+}
+
+// getTestLogRecords 获取 JSON 日志中消息为 msg 的记录
+func getTestLogRecords(buf *bytes.Buffer, msg string) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]interface{}{}
+		if line == "" || json.Unmarshal([]byte(line), &record) != nil {
+			continue
+		}
+		if record[slog.MessageKey] == msg {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// TestRateLimited 相同级别及消息的日志超过令牌桶容量后被抑制，Flush 输出被抑制条数的汇总，不同消息分别限流
+func TestRateLimited(t *testing.T) {
+	ctx := context.Background()
+	buf := &bytes.Buffer{}
+	l := logger.NewRateLimited(newTestSlogLogger(buf, slog.LevelInfo), logger.RateLimit{Burst: 2, Interval: time.Hour})
+	for i := 0; i < 5; i++ {
+		l.Error(ctx, "clock backwards", logger.TimeSeq(int64(i)))
+	}
+	l.Warn(ctx, "clock backwards")
+	records := getTestLogRecords(buf, "clock backwards")
+	assert.Len(t, records, 3)
+	source, _ := records[0][slog.SourceKey].(map[string]interface{})
+	assert.Contains(t, source["file"], "logger_test.go")
+
+	l.Flush(ctx)
+	summaries := getTestLogRecords(buf, "suppressed 3 similar messages")
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, "clock backwards", summaries[0][logger.KeySuppressedMsg])
+	assert.Equal(t, "ERROR", summaries[0][slog.LevelKey])
+
+	buf.Reset()
+	l.Flush(ctx)
+	assert.Zero(t, buf.Len())
+
+	buf.Reset()
+	l = logger.NewRateLimited(newTestSlogLogger(buf, slog.LevelInfo), logger.RateLimit{Burst: -1})
+	for i := 0; i < 5; i++ {
+		l.Error(ctx, "clock backwards")
+	}
+	assert.Len(t, getTestLogRecords(buf, "clock backwards"), 5)
+}
+
+// TestRateLimited_ClockBackwards 连续时钟回拨时只输出令牌桶容量内的日志，心跳时输出被抑制条数的汇总
+func TestRateLimited_ClockBackwards(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	buf := &bytes.Buffer{}
+	conf := getTestSecondConfig()
+	conf.Logger = newTestSlogLogger(buf, slog.LevelInfo)
+	conf.LogRateLimit = logger.RateLimit{Burst: 1, Interval: time.Hour}
+	g := newTestGenerator(t, conf)
+
+	_, err := g.NewIdByCode(ctx, rateLimitCode)
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		g.Advance(ctx, -2*time.Second)
+		_, err = g.NewIdByCode(ctx, rateLimitCode)
+		assert.NoError(t, err)
+	}
+	assert.Len(t, getTestLogRecords(buf, "server clock backwards"), 1)
+
+	g.Advance(ctx, 20*time.Second)
+	_ = g.Heartbeat(ctx)
+	summaries := getTestLogRecords(buf, "suppressed 4 similar messages")
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, "server clock backwards", summaries[0][logger.KeySuppressedMsg])
+}
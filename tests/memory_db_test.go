@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+)
+
+// TestMemoryDb_Service 不同服务共用一个内存数据库时各自拥有相同id的worker，对账不影响其他服务
+func TestMemoryDb_Service(t *testing.T) {
+	ctx := getTestContext()
+	d := db.NewMemoryDb(nil)
+	assert.NoError(t, d.Migrate(ctx))
+	for _, service := range []string{"order", "user"} {
+		d.InitSql("", tableName, service, 0)
+		_, err := d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Now())
+		assert.NoError(t, err)
+	}
+
+	d.InitSql("", tableName, "order", 0)
+	workers, err := d.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+	assert.Equal(t, "order", workers[0].Service)
+	w, err := d.ActivateWorker(ctx, workers[0].Id, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+	assert.NoError(t, err)
+	assert.NotNil(t, w)
+
+	// 其他服务中相同id的worker不受影响
+	d.InitSql("", tableName, "user", 0)
+	before, err := d.GetBeforeWorker(ctx, "code")
+	assert.NoError(t, err)
+	assert.Nil(t, before)
+	workers, err = d.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+	assert.Equal(t, w.Id, workers[0].Id)
+	assert.Equal(t, "user", workers[0].Service)
+	assert.Len(t, d.Workers(), maxWorkerId-minWorkerId+1)
+
+	// user 服务的对账范围不包含 order 服务的worker
+	result, err := d.ReconcileWorkers(ctx, minWorkerId+1, maxWorkerId, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Disabled)
+	d.InitSql("", tableName, "order", 0)
+	w, err = d.GetWorkerById(ctx, w.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "code", w.Code)
+}
+
+// TestMemoryDb_Datacenter 不同数据中心共用一个内存数据库时各自拥有完整的worker范围
+func TestMemoryDb_Datacenter(t *testing.T) {
+	ctx := getTestContext()
+	d := db.NewMemoryDb(nil)
+	assert.NoError(t, d.Migrate(ctx))
+
+	for _, datacenterId := range []int64{0, 1} {
+		d.InitSql("", tableName, "", datacenterId)
+		_, err := d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+		assert.NoError(t, err)
+		workers, err := d.QueryFreeWorkers(ctx, time.Now())
+		assert.NoError(t, err)
+		assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+		assert.Equal(t, datacenterId, workers[0].DatacenterId)
+
+		w, err := d.ActivateWorker(ctx, minWorkerId, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+		assert.NoError(t, err)
+		assert.NotNil(t, w)
+		assert.Equal(t, int64(minWorkerId), w.Id)
+		assert.Equal(t, datacenterId, w.DatacenterId)
+	}
+
+	// 数据中心 1 激活的worker不影响数据中心 0
+	d.InitSql("", tableName, "", 0)
+	w, err := d.GetWorkerById(ctx, minWorkerId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), w.DatacenterId)
+	assert.Equal(t, int64(2), w.Version)
+	assert.Len(t, d.Workers(), maxWorkerId-minWorkerId+1)
+}
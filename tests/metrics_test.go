@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/metrics"
+)
+
+// 仅指标测试使用的 code，按 code 生成的状态在重新初始化后保留
+const (
+	metricsCode          = "metrics"
+	metricsExhaustedCode = "metrics_exhausted"
+)
+
+// getTestMetric 采集 reg 中名称为 name 且标签均匹配的指标值，计数器及仪表盘返回值，直方图返回采样数，不存在时返回 -1
+func getTestMetric(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("%s gather metrics fail. %s", t.Name(), err.Error())
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if !matchTestLabels(metric, labels) {
+				continue
+			}
+			switch {
+			case metric.GetCounter() != nil:
+				return metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				return metric.GetGauge().GetValue()
+			case metric.GetHistogram() != nil:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return -1
+}
+
+func matchTestLabels(metric *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range metric.GetLabel() {
+		if v, ok := labels[pair.GetName()]; ok && v == pair.GetValue() {
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
+
+// TestMetrics 生成id、时钟回拨、心跳及租约丢失时更新指标，采集时输出租约时长及空闲worker数量，事件同时转发给原有的观察者
+func TestMetrics(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	reg := prometheus.NewRegistry()
+	o := &testObserver{}
+	m, err := metrics.New(reg, o)
+	assert.NoError(t, err)
+	conf := getTestSecondConfig()
+	conf.Observer = m
+	g := newTestGenerator(t, conf)
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_worker_events_total", map[string]string{"event": consts.WorkerEventActivate}))
+	assert.Equal(t, float64(maxWorkerId-minWorkerId), getTestMetric(t, reg, "raindrop_free_workers", nil))
+
+	_, err = g.NewId(ctx)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = g.NewIdByCode(ctx, metricsCode)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_ids_generated_total",
+		map[string]string{"code": "", "mode": consts.IdModeSnowflake, "result": "success"}))
+	assert.Equal(t, float64(2), getTestMetric(t, reg, "raindrop_ids_generated_total",
+		map[string]string{"code": metricsCode, "mode": consts.IdModeSnowflake, "result": "success"}))
+	assert.Equal(t, float64(3), getTestMetric(t, reg, "raindrop_new_id_duration_seconds", nil))
+
+	// NewId 的时间戳流水延续之前的测试，使用仅本测试生成过的 code 验证时钟回拨
+	flips := getTestMetric(t, reg, "raindrop_time_back_flips_total", nil)
+	g.Advance(ctx, -3*time.Second)
+	_, err = g.NewIdByCode(ctx, metricsCode)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_clock_backwards_total", map[string]string{"code": metricsCode}))
+	assert.Equal(t, flips+1, getTestMetric(t, reg, "raindrop_time_back_flips_total", nil))
+
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	assert.NoError(t, g.Heartbeat(ctx))
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_heartbeats_total", map[string]string{"result": "success"}))
+
+	_, err = g.StealLease(ctx, "thief")
+	assert.NoError(t, err)
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	assert.Error(t, g.Heartbeat(ctx))
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_heartbeats_total", map[string]string{"result": "error"}))
+	assert.Equal(t, float64(2), getTestMetric(t, reg, "raindrop_heartbeat_duration_seconds", nil))
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_worker_events_total", map[string]string{"event": "lost"}))
+	assert.Equal(t, float64(consts.HeartbeatTimeInterval), getTestMetric(t, reg, "raindrop_lease_age_seconds", nil))
+	assert.Subset(t, o.Events(), []string{"acquired:" + consts.WorkerEventActivate, "backwards", "heartbeatFailed", "lost"})
+}
+
+// TestMetrics_SeqExhausted 序列号耗尽后返回错误时按 code 计数
+func TestMetrics_SeqExhausted(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	reg := prometheus.NewRegistry()
+	m, err := metrics.New(reg, nil)
+	assert.NoError(t, err)
+	conf := getTestMinuteConfig()
+	// 序列号 3 位，每分钟 8 个id
+	conf.TimeStampLength = 50
+	conf.EndBitsLength = 5
+	conf.Observer = m
+	g := newTestGenerator(t, conf)
+
+	for i := 0; i < 9; i++ {
+		_, _ = g.NewIdByCode(ctx, metricsExhaustedCode)
+	}
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_seq_exhausted_total", map[string]string{"code": metricsExhaustedCode, "action": "error"}))
+	assert.Equal(t, float64(1), getTestMetric(t, reg, "raindrop_ids_generated_total", map[string]string{"code": metricsExhaustedCode, "result": "error"}))
+}
@@ -10,6 +10,7 @@ import (
 )
 
 func TestMySqlDb_GetNowTime(t *testing.T) {
+	skipIfNoTestDb(t)
 	ctx := getTestContext()
 	l := logger.NewDefault()
 	err := db.InitPostgreSqlDb(ctx, getTestConfig(), l)
@@ -25,7 +26,7 @@ func TestMySqlDb_GetNowTime(t *testing.T) {
 }
 
 func TestMySqlDb_QueryFreeWorkers(t *testing.T) {
-
+	skipIfNoTestDb(t)
 	ctx := getTestContext()
 
 	conf := getTestSecondConfig()
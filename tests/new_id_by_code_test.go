@@ -20,9 +20,7 @@ func TestSimpleNewIdByCode(t *testing.T) {
 	ctx := getTestContext()
 	conf := getTestMinuteConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	newTestGenerator(t, conf)
 	if worker.GetWorkerId(ctx) != minWorkerId {
 		t.Fatalf("%s worker id get error.", t.Name())
 	}
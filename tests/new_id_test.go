@@ -2,19 +2,31 @@ package tests
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/raindroptest"
 	"github.com/treeyh/raindrop/worker"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestTicketInterval(t *testing.T) {
+	var count atomic.Int64
 	ticket := worker.NewTicket(time.Duration(1)*time.Millisecond, func(ctx context.Context) error {
-		t.Log(time.Now().UnixMilli())
+		count.Add(1)
 		return nil
 	})
-	ticket.Start(getTestContext())
+	go ticket.Start(getTestContext())
+
+	time.Sleep(time.Duration(50) * time.Millisecond)
+	if count.Load() <= 0 {
+		t.Fatalf("%s ticket not run.", t.Name())
+	}
+	t.Logf("%s run count: %d", t.Name(), count.Load())
 }
 
 // TestSimpleNewId 获取id
@@ -23,18 +35,15 @@ func TestSimpleNewId(t *testing.T) {
 	//conf := getTestSimpleMillisecondConfig()
 	conf := getTestSecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	newTestGenerator(t, conf)
 	if worker.GetWorkerId(ctx) != minWorkerId {
 		t.Fatalf("%s worker id get error.", t.Name())
 	}
 
 	t.Logf("%s pass.", t.Name())
 
-	batchNewId(ctx, t, 0, true)
-
-	time.Sleep(time.Duration(10) * time.Second)
+	idMap := make(map[int64]bool)
+	assert.NoError(t, batchNewId(ctx, t, 0, 10000000, true, idMap))
 
 	t.Log("End")
 }
@@ -45,20 +54,36 @@ func TestBenchmarkNewId(t *testing.T) {
 	//conf := getTestSimpleMillisecondConfig()
 	conf := getTestSecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	newTestGenerator(t, conf)
 	if worker.GetWorkerId(ctx) != minWorkerId {
 		t.Fatalf("%s worker id get error.", t.Name())
 	}
 
 	t.Logf("%s pass.", t.Name())
 
+	var wg sync.WaitGroup
+	errs := make([]error, 16)
+	idMaps := make([]map[int64]bool, 16)
 	for i := 0; i < 16; i++ {
-		go batchNewId(ctx, t, i, false)
+		idMaps[i] = make(map[int64]bool)
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			errs[index] = batchNewId(ctx, t, index, 1000000, false, idMaps[index])
+		}(i)
 	}
+	wg.Wait()
 
-	time.Sleep(time.Duration(60) * time.Second)
+	idMap := make(map[int64]bool)
+	for i := 0; i < 16; i++ {
+		assert.NoError(t, errs[i])
+		for id := range idMaps[i] {
+			if idMap[id] {
+				t.Fatalf("%s duplicate id generated: %d", t.Name(), id)
+			}
+			idMap[id] = true
+		}
+	}
 }
 
 // TestSimpleLongTimeNewId 获取长时间获取id
@@ -67,46 +92,166 @@ func TestSimpleLongTimeNewId(t *testing.T) {
 	//conf := getTestSimpleMillisecondConfig()
 	conf := getTestSecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	g := newTestGenerator(t, conf)
 	if worker.GetWorkerId(ctx) != minWorkerId {
 		t.Fatalf("%s worker id get error.", t.Name())
 	}
 
 	t.Logf("%s pass.", t.Name())
 
-	batchNewId(ctx, t, 0, true)
+	idMap := make(map[int64]bool)
+	assert.NoError(t, batchNewId(ctx, t, 0, 10000000, true, idMap))
 
-	time.Sleep(time.Duration(10) * time.Second)
+	g.Advance(ctx, time.Duration(10)*time.Second)
 
-	endTime := time.Now().Add(time.Duration(6) * time.Hour)
+	endTime := g.Clock.Now().Add(time.Duration(6) * time.Hour)
 
-	for true {
+	var lastId int64
+	for i := 1; true; i++ {
 		id, err := worker.NewId(ctx)
 		assert.NoError(t, err)
-		t.Logf("new id: %d", id)
+		if id <= lastId {
+			t.Fatalf("%s id not increasing. lastId: %d, id: %d", t.Name(), lastId, id)
+		}
+		lastId = id
 
-		if endTime.Unix() < time.Now().Unix() {
+		if endTime.Unix() < g.Clock.Now().Unix() {
 			break
 		}
-		time.Sleep(time.Duration(5) * time.Second)
+		if i%6 == 0 {
+			if err = g.Heartbeat(ctx); err != nil {
+				t.Fatalf("%s heartbeat error. %s", t.Name(), err.Error())
+			}
+		}
+		g.Advance(ctx, time.Duration(5)*time.Second)
 	}
 
 	t.Log("End")
 }
 
-func batchNewId(ctx context.Context, t *testing.T, index int, logFlag bool) {
+// TestClockBackwardsNewId 时钟回拨后翻转时间回拨位，id不重复
+func TestClockBackwardsNewId(t *testing.T) {
+	ctx := getTestContext()
+	conf := getTestMillisecondConfig()
+
+	g := newTestGenerator(t, conf)
+
+	idMap := make(map[int64]bool)
+	for i := 0; i < 1000; i++ {
+		id, err := g.NewId(ctx)
+		assert.NoError(t, err)
+		idMap[id] = true
+	}
+
+	bit := worker.GetTimeBackBitValue(ctx)
+	g.Advance(ctx, time.Duration(-1)*time.Millisecond)
+
+	id, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	if idMap[id] {
+		t.Fatalf("%s duplicate id generated after clock backwards: %d", t.Name(), id)
+	}
+	if worker.GetTimeBackBitValue(ctx) == bit {
+		t.Fatalf("%s time back bit should be flipped.", t.Name())
+	}
+	t.Logf("%s pass.", t.Name())
+}
+
+// TestSeqExhaustedNewId 同一时刻流水号用尽时等待时钟前进
+func TestSeqExhaustedNewId(t *testing.T) {
+	ctx := getTestContext()
+	conf := getTestSimpleMillisecondConfig()
+	// 流水号仅 3 位，每毫秒最多 8 个 id
+	conf.TimeStampLength = 55
+
+	g := newTestGenerator(t, conf)
 
 	idMap := make(map[int64]bool)
+	for i := 0; i < 8; i++ {
+		id, err := g.NewId(ctx)
+		assert.NoError(t, err)
+		idMap[id] = true
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(20)*time.Millisecond)
+	_, err := g.NewId(timeoutCtx)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("%s new id should wait for next tick, got: %v", t.Name(), err)
+	}
+
+	done := make(chan int64)
+	go func() {
+		id, _ := g.NewId(ctx)
+		done <- id
+	}()
+	time.Sleep(time.Duration(10) * time.Millisecond)
+	g.Advance(ctx, time.Millisecond)
+
+	select {
+	case id := <-done:
+		if idMap[id] {
+			t.Fatalf("%s duplicate id generated: %d", t.Name(), id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s new id still waiting after clock advanced.", t.Name())
+	}
+	t.Logf("%s pass.", t.Name())
+}
+
+// TestRaindropTestClock 手动时钟
+func TestRaindropTestClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := raindroptest.NewClock(now)
+	clock.Advance(time.Minute)
+	assert.Equal(t, now.Add(time.Minute), clock.Now())
+	clock.Set(now)
+	assert.Equal(t, now, clock.Now())
+}
+
+// TestDatacenterNewId 数据中心位位于时间戳和工作节点之间，解析id可以得到数据中心
+func TestDatacenterNewId(t *testing.T) {
+	ctx := getTestContext()
+	conf := getTestSecondConfig()
+	conf.DatacenterIdLength = 2
+	conf.DatacenterId = 3
+
+	g := newTestGenerator(t, conf)
+	assert.Equal(t, int64(3), worker.GetDatacenterId(ctx))
+
+	id, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	info := raindrop.Parse(id)
+	assert.Equal(t, int64(3), info.DatacenterId)
+	assert.Equal(t, worker.GetWorkerId(ctx), info.WorkerId)
+	assert.Equal(t, int64(0), info.Seq)
+	assert.Equal(t, g.Clock.Now().Unix(), info.Time.Unix())
+
+	id2, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), raindrop.Parse(id2).Seq)
+
+	// 数据中心 id 超出范围
+	conf.DatacenterId = 4
+	assert.Error(t, g.RestartWithConfig(ctx, conf))
+
+	// 数据中心位占满流水号位
+	conf.DatacenterId = 0
+	conf.DatacenterIdLength = 5
+	conf.TimeStampLength = 53
+	assert.Error(t, g.RestartWithConfig(ctx, conf))
+}
+
+func batchNewId(ctx context.Context, t *testing.T, index int, count int, logFlag bool, idMap map[int64]bool) error {
+
 	start := time.Now().UnixMilli()
-	for i := 0; i < 10000000; i++ {
+	for i := 0; i < count; i++ {
 		id, err := raindrop.NewId()
 		if err != nil {
-			t.Fatalf("%s newId get fail. %s", t.Name(), err.Error())
+			return err
 		}
 		if _, ok := idMap[id]; ok {
-			t.Errorf("%s duplicate id generated: %d", t.Name(), id)
+			return fmt.Errorf("%s duplicate id generated: %d", t.Name(), id)
 		}
 		idMap[id] = true
 		if logFlag && i%100000 == 0 {
@@ -115,4 +260,5 @@ func batchNewId(ctx context.Context, t *testing.T, index int, logFlag bool) {
 	}
 	end := time.Now().UnixMilli()
 	t.Logf("index:%d new id start:%d, end:%d, time: %d", index, start, end, end-start)
+	return nil
 }
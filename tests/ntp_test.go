@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/raindroptest"
+	"github.com/treeyh/raindrop/utils"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// testNtpServer 本地 UDP 的 NTP 服务，返回时钟 clock 加上 offset 的时间
+type testNtpServer struct {
+	conn  net.PacketConn
+	clock utils.Clock
+
+	lock    sync.Mutex
+	offset  time.Duration
+	stratum byte
+}
+
+// newTestNtpServer 启动本地 NTP 服务，测试结束时关闭
+func newTestNtpServer(t *testing.T, clock utils.Clock, offset time.Duration) *testNtpServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s listen udp fail. %s", t.Name(), err.Error())
+	}
+	s := &testNtpServer{conn: conn, clock: clock, offset: offset, stratum: 2}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	go s.serve()
+	return s
+}
+
+// Addr NTP 服务地址
+func (s *testNtpServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// SetOffset 设置返回时间的偏差
+func (s *testNtpServer) SetOffset(offset time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.offset = offset
+}
+
+// SetStratum 设置返回的层级，0 表示拒绝请求
+func (s *testNtpServer) SetStratum(stratum byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.stratum = stratum
+}
+
+func (s *testNtpServer) serve() {
+	buf := make([]byte, 48)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < 48 {
+			continue
+		}
+		s.lock.Lock()
+		now := s.clock.Now().Add(s.offset)
+		stratum := s.stratum
+		s.lock.Unlock()
+
+		response := make([]byte, 48)
+		// LI = 0，VN = 4，Mode = 4（服务器）
+		response[0] = 4<<3 | 4
+		response[1] = stratum
+		// 根延迟 10ms，根离散 5ms
+		binary.BigEndian.PutUint32(response[4:], uint32(10*time.Millisecond*(1<<16)/time.Second))
+		binary.BigEndian.PutUint32(response[8:], uint32(5*time.Millisecond*(1<<16)/time.Second))
+		copy(response[24:32], buf[40:48])
+		binary.BigEndian.PutUint64(response[32:], testNtpTime(now))
+		binary.BigEndian.PutUint64(response[40:], testNtpTime(now))
+		s.conn.WriteTo(response, addr)
+	}
+}
+
+// testNtpTime 转换为 NTP 64 位时间
+func testNtpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + 2208988800)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// TestQueryNtp 查询本地 NTP 服务，计算偏差、往返时间及同步距离
+func TestQueryNtp(t *testing.T) {
+	ctx := context.Background()
+	clock := raindroptest.NewClock(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC))
+	server := newTestNtpServer(t, clock, 3*time.Second)
+
+	r, err := utils.QueryNtp(ctx, server.Addr(), time.Second, clock)
+	assert.NoError(t, err)
+	assert.InDelta(t, float64(3*time.Second), float64(r.Offset), float64(time.Microsecond))
+	assert.Equal(t, time.Duration(0), r.Delay.Round(time.Microsecond))
+	assert.Equal(t, 2, r.Stratum)
+	// 根延迟及根离散按 16.16 定点数编码，精度约 15 微秒
+	assert.InDelta(t, float64(10*time.Millisecond), float64(r.Distance()), float64(100*time.Microsecond))
+
+	server.SetStratum(0)
+	_, err = utils.QueryNtp(ctx, server.Addr(), time.Second, clock)
+	assert.Error(t, err)
+
+	// 无响应时超时
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = utils.QueryNtp(ctx, conn.LocalAddr().String(), 100*time.Millisecond, clock)
+	assert.Error(t, err)
+}
+
+// initNtpTest 使用本地 NTP 服务初始化
+func initNtpTest(t *testing.T, policy string, offset time.Duration) (context.Context, *testNtpServer, error) {
+	return initNtpTestWithDb(t, policy, offset, func(clock *raindroptest.Clock) db.IDb {
+		return db.NewMemoryDb(clock)
+	})
+}
+
+// initNtpTestWithDb 使用本地 NTP 服务及 newDb 创建的数据库初始化
+func initNtpTestWithDb(t *testing.T, policy string, offset time.Duration, newDb func(clock *raindroptest.Clock) db.IDb) (context.Context, *testNtpServer, error) {
+	ctx := getTestSkipHeartbeatContext()
+	clock := raindroptest.NewClock(time.Now())
+	server := newTestNtpServer(t, clock, offset)
+	conf := getTestSecondConfig()
+	conf.Clock = clock
+	conf.DbConfig = config.RainDropDbConfig{DbType: consts.DbTypeMemory, TableName: tableName}
+	conf.DriftPolicy = policy
+	conf.NtpServers = []string{server.Addr(), server.Addr(), "127.0.0.1:1"}
+	conf.NtpTimeout = 200 * time.Millisecond
+	err := raindrop.InitWithDb(ctx, conf, newDb(clock))
+	return ctx, server, err
+}
+
+// TestNtp_Startup 启动时与 NTP 服务器的偏差超过阈值，处理策略不是 warn 时初始化失败
+func TestNtp_Startup(t *testing.T) {
+	_, _, err := initNtpTest(t, consts.DriftPolicyPause, time.Hour)
+	assert.True(t, errors.Is(err, consts.ErrMsgNtpOffsetExceeded))
+
+	ctx, _, err := initNtpTest(t, consts.DriftPolicyWarn, time.Hour)
+	assert.NoError(t, err)
+	status := raindrop.NtpStatus(ctx)
+	assert.True(t, status.Exceeded)
+	assert.InDelta(t, float64(time.Hour), float64(status.Offset), float64(time.Microsecond))
+	assert.Len(t, status.Servers, 3)
+	assert.NotEmpty(t, status.Servers[2].Error)
+	_, err = raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+}
+
+// TestNtp_ReplaceDbTime 配置了 NTP 服务器时以 NTP 服务器替代DB时间校验时钟，DB时间偏差不影响启动及心跳
+func TestNtp_ReplaceDbTime(t *testing.T) {
+	ctx, _, err := initNtpTestWithDb(t, consts.DriftPolicyPause, 0, func(clock *raindroptest.Clock) db.IDb {
+		return &driftMemoryDb{MemoryDb: db.NewMemoryDb(clock), offset: time.Hour}
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, worker.Heartbeat(ctx))
+	assert.Equal(t, int64(2), raindrop.NtpStatus(ctx).Samples)
+	assert.Zero(t, raindrop.DriftStatus(ctx).Samples)
+	_, err = raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+}
+
+// TestNtp_DbUnavailable 连接数据库前查询 NTP 服务器，数据库不可用时同样校验时钟
+func TestNtp_DbUnavailable(t *testing.T) {
+	_, _, err := initNtpTestWithDb(t, consts.DriftPolicyPause, time.Hour, func(clock *raindroptest.Clock) db.IDb {
+		d := &downMemoryDb{MemoryDb: db.NewMemoryDb(clock)}
+		d.down.Store(true)
+		return d
+	})
+	assert.True(t, errors.Is(err, consts.ErrMsgNtpOffsetExceeded))
+	var clockErr *consts.ClockError
+	assert.True(t, errors.As(err, &clockErr))
+	assert.Equal(t, consts.ClockIncidentNtpDrift, clockErr.Source)
+}
+
+// TestNtp_Pause 心跳时与 NTP 服务器的偏差超过阈值时暂停生成id，恢复后继续
+func TestNtp_Pause(t *testing.T) {
+	ctx, server, err := initNtpTest(t, consts.DriftPolicyPause, time.Second)
+	assert.NoError(t, err)
+	status := raindrop.NtpStatus(ctx)
+	assert.Equal(t, int64(1), status.Samples)
+	assert.False(t, status.Exceeded)
+
+	server.SetOffset(-2 * time.Minute)
+	assert.NoError(t, worker.Heartbeat(ctx))
+	status = raindrop.NtpStatus(ctx)
+	assert.True(t, status.Exceeded)
+	assert.Equal(t, int64(2), status.Samples)
+	_, err = raindrop.NewIdContext(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgClockDriftExceeded))
+	incidents := raindrop.ClockIncidents(ctx)
+	assert.Equal(t, consts.ClockIncidentNtpDrift, incidents[len(incidents)-1].Kind)
+
+	server.SetOffset(0)
+	assert.NoError(t, worker.Heartbeat(ctx))
+	_, err = raindrop.NewIdContext(ctx)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+)
+
+// testObserver 记录回调事件的观察者
+type testObserver struct {
+	config.NopObserver
+
+	lock      sync.Mutex
+	events    []string
+	incidents []model.ClockIncident
+	waits     []time.Duration
+	remaining time.Duration
+}
+
+func (o *testObserver) record(event string) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.events = append(o.events, event)
+}
+
+// Events 已回调的事件
+func (o *testObserver) Events() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return append([]string(nil), o.events...)
+}
+
+func (o *testObserver) WorkerAcquired(ctx context.Context, worker model.RaindropWorker, event string) {
+	o.record("acquired:" + event)
+}
+
+func (o *testObserver) WorkerLost(ctx context.Context, worker model.RaindropWorker, err error) {
+	o.record("lost")
+}
+
+func (o *testObserver) HeartbeatFailed(ctx context.Context, worker model.RaindropWorker, err error) {
+	o.record("heartbeatFailed")
+}
+
+func (o *testObserver) ClockBackwards(ctx context.Context, incident model.ClockIncident) {
+	o.record("backwards")
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.incidents = append(o.incidents, incident)
+}
+
+func (o *testObserver) SeqExhausted(ctx context.Context, idCode string, timeSeq int64, wait time.Duration) {
+	o.record("seqExhausted")
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.waits = append(o.waits, wait)
+}
+
+func (o *testObserver) TimestampExpiring(ctx context.Context, overflowTime time.Time, remaining time.Duration) {
+	o.record("timestampExpiring")
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.remaining = remaining
+}
+
+// dbObserver 记录数据库重试及表结构迁移事件的观察者
+type dbObserver struct {
+	testObserver
+
+	migrated []int
+}
+
+func (o *dbObserver) DbRetry(ctx context.Context, op string, attempt int, wait time.Duration, err error) {
+	o.record("retry:" + op + ":" + strconv.Itoa(attempt))
+}
+
+func (o *dbObserver) DbRetryFailed(ctx context.Context, op string, attempts int, err error) {
+	o.record("retryFailed:" + op + ":" + strconv.Itoa(attempts))
+}
+
+func (o *dbObserver) SchemaMigrated(ctx context.Context, table string, version int, description string, err error) {
+	if err != nil {
+		o.record("migrateFailed")
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.migrated = append(o.migrated, version)
+}
+
+// panicObserver 回调时 panic 的观察者
+type panicObserver struct {
+	config.NopObserver
+}
+
+func (panicObserver) WorkerAcquired(ctx context.Context, worker model.RaindropWorker, event string) {
+	panic("observer panic")
+}
+
+// TestObserver_Lease 激活worker、时钟回拨、心跳失败及租约丢失时回调观察者，租约丢失只回调一次
+func TestObserver_Lease(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	o := &testObserver{}
+	conf := getTestSecondConfig()
+	conf.Observer = o
+	g := newTestGenerator(t, conf)
+	assert.Equal(t, []string{"acquired:" + consts.WorkerEventActivate}, o.Events())
+
+	_, err := g.NewId(ctx)
+	assert.NoError(t, err)
+	g.Advance(ctx, -3*time.Second)
+	_, err = g.NewId(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, o.incidents, 1)
+	assert.Equal(t, int64(3), o.incidents[0].LastTimeSeq-o.incidents[0].TimeSeq)
+	assert.Equal(t, o.incidents[0].TimeBackBefore^1, o.incidents[0].TimeBackAfter)
+
+	_, err = g.StealLease(ctx, "thief")
+	assert.NoError(t, err)
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	assert.True(t, errors.Is(g.Heartbeat(ctx), consts.ErrMsgWorkerLeaseLost))
+	assert.Equal(t, []string{"acquired:" + consts.WorkerEventActivate, "backwards", "heartbeatFailed", "lost"}, o.Events())
+
+	// 租约丢失后不再心跳，每个租约只回调一次 WorkerLost
+	for i := 0; i < 3; i++ {
+		g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+		assert.True(t, errors.Is(g.Heartbeat(ctx), consts.ErrMsgWorkerLeaseLost))
+	}
+	assert.Len(t, filterTestEvents(o.Events(), "lost"), 1)
+	assert.Len(t, filterTestEvents(o.Events(), "heartbeatFailed"), 1)
+}
+
+// TestObserver_Capacity 序列号耗尽及时间戳位即将耗尽时回调观察者
+func TestObserver_Capacity(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	o := &testObserver{}
+	conf := getTestMinuteConfig()
+	// 序列号 3 位，每分钟 8 个id
+	conf.TimeStampLength = 50
+	conf.EndBitsLength = 5
+	conf.Observer = o
+	newTestGenerator(t, conf)
+	assert.NotContains(t, o.Events(), "timestampExpiring")
+
+	for i := 0; i < 9; i++ {
+		_, _ = raindrop.NewIdContext(ctx)
+	}
+	assert.Contains(t, o.Events(), "seqExhausted")
+	assert.Equal(t, []time.Duration{0}, o.waits)
+
+	// 时间戳位 31 位、以秒为单位约 68 年，起始时间为 60 年前时将在 10 年内耗尽
+	o = &testObserver{}
+	conf = getTestSecondConfig()
+	conf.StartTimeStamp = time.Now().AddDate(-60, 0, 0)
+	conf.Observer = o
+	newTestGenerator(t, conf)
+	assert.Contains(t, o.Events(), "timestampExpiring")
+	assert.True(t, o.remaining > 0 && o.remaining < consts.LayoutOverflowWarnTime)
+}
+
+// TestObserver_Panic 回调 panic 时恢复，不影响初始化
+func TestObserver_Panic(t *testing.T) {
+	conf := getTestSecondConfig()
+	conf.Observer = panicObserver{}
+	g := newTestGenerator(t, conf)
+	_, err := g.NewId(getTestSkipHeartbeatContext())
+	assert.NoError(t, err)
+}
@@ -1,14 +1,127 @@
 package tests
 
 import (
-	"github.com/treeyh/raindrop"
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/raindroptest"
 )
 
 func TestInit(t *testing.T) {
-	ctx := getTestContext()
+	ctx := getTestSkipHeartbeatContext()
 	//conf := tests.GetMillisecondConfig()
 	conf := getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeMemory
 	raindrop.Init(ctx, conf)
 	//time.Sleep(time.Duration(20) * time.Minute)
 }
+
+// TestInitE_ConfigError 配置校验失败时返回 consts.ConfigError，包含配置项及其值
+func TestInitE_ConfigError(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeMemory
+	conf.ServiceMinWorkId = 1
+	conf.ServiceMaxWorkId = 16
+
+	err := raindrop.InitE(ctx, conf)
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+	var configErr *consts.ConfigError
+	assert.True(t, errors.As(err, &configErr))
+	assert.Equal(t, "ServiceMaxWorkId", configErr.Field)
+	assert.Equal(t, [2]int64{1, 16}, configErr.Value)
+
+	conf = getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeMemory
+	conf.StartTimeStamp = time.Now().Add(time.Hour)
+	err = raindrop.InitE(ctx, conf)
+	assert.True(t, errors.Is(err, consts.ErrMsgConfigInvalid))
+	assert.True(t, errors.Is(err, consts.ErrMsgStartTimeStampError))
+}
+
+// TestInitE_ClockError 启动时服务器与DB时间偏差超过阈值时返回 consts.ClockError，不会 panic
+func TestInitE_ClockError(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.Clock = raindroptest.NewClock(time.Now())
+	conf.DbConfig = config.RainDropDbConfig{DbType: consts.DbTypeMemory, TableName: tableName}
+	d := &driftMemoryDb{MemoryDb: db.NewMemoryDb(conf.Clock), offset: time.Hour}
+
+	err := raindrop.InitWithDb(ctx, conf, d)
+	assert.True(t, errors.Is(err, consts.ErrMsgDatabaseServerTimeInterval))
+	var clockErr *consts.ClockError
+	assert.True(t, errors.As(err, &clockErr))
+	assert.Equal(t, consts.ClockIncidentDbDrift, clockErr.Source)
+	assert.Equal(t, time.Hour, clockErr.Offset)
+	assert.Equal(t, consts.DatabaseTimeInterval*time.Second, clockErr.Threshold)
+	assert.Equal(t, consts.TimeUnitSecond, clockErr.TimeUnit)
+}
+
+// TestInitE_LeaseError 没有空闲的worker时返回 consts.LeaseError，包含请求的worker范围
+func TestInitE_LeaseError(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.ServiceMinWorkId = 3
+	conf.ServiceMaxWorkId = 3
+	g := newTestGenerator(t, conf)
+
+	err := g.Restart(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkersNotAvailable))
+	var leaseErr *consts.LeaseError
+	assert.True(t, errors.As(err, &leaseErr))
+	assert.Equal(t, int64(3), leaseErr.MinWorkerId)
+	assert.Equal(t, int64(3), leaseErr.MaxWorkerId)
+	assert.Equal(t, consts.TimeUnitSecond, leaseErr.TimeUnit)
+}
+
+// beforeFailDb 查询节点之前的worker失败的内存数据库
+type beforeFailDb struct {
+	*db.MemoryDb
+}
+
+func (d *beforeFailDb) GetBeforeWorker(ctx context.Context, code string) (*model.RaindropWorker, error) {
+	return nil, driver.ErrBadConn
+}
+
+// TestInitE_GetBeforeWorkerError 查询节点之前的worker失败时返回 consts.DbError，不会分配其他worker
+func TestInitE_GetBeforeWorkerError(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.PriorityEqualCodeWorkId = true
+	conf.DbConfig = config.RainDropDbConfig{DbType: consts.DbTypeMemory, TableName: tableName}
+	d := &beforeFailDb{MemoryDb: db.NewMemoryDb(nil)}
+
+	err := raindrop.InitWithDb(ctx, conf, d)
+	assert.True(t, errors.Is(err, driver.ErrBadConn))
+	var dbErr *consts.DbError
+	assert.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "get before worker", dbErr.Op)
+	for _, w := range d.Workers() {
+		assert.Equal(t, "", w.Code)
+	}
+}
+
+// TestInit_RedactDbUrl 初始化时输出的配置中不包含连接串的密码
+func TestInit_RedactDbUrl(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	buf := &bytes.Buffer{}
+	conf := getTestSecondConfig()
+	conf.Logger = newTestSlogLogger(buf, slog.LevelInfo)
+	conf.DbConfig = config.RainDropDbConfig{DbType: consts.DbTypeMemory, TableName: tableName,
+		DbUrl: "raindrop:secret@tcp(127.0.0.1:3306)/raindrop"}
+
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, db.NewMemoryDb(nil)))
+	assert.Contains(t, buf.String(), "raindrop:xxxxx@tcp(127.0.0.1:3306)/raindrop")
+	assert.NotContains(t, buf.String(), "secret")
+}
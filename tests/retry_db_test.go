@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/raindroptest"
+	"github.com/treeyh/raindrop/worker"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyDb 前 failures 次调用返回连接错误的内存数据库
+type flakyDb struct {
+	*db.MemoryDb
+
+	failures int
+	calls    int
+	// applyBeforeFail 心跳失败前先在数据库生效，模拟提交后连接断开
+	applyBeforeFail bool
+}
+
+func (f *flakyDb) fail() bool {
+	f.calls++
+	return f.calls <= f.failures
+}
+
+func (f *flakyDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	if f.fail() {
+		return time.Time{}, driver.ErrBadConn
+	}
+	return f.MemoryDb.GetNowTime(ctx)
+}
+
+func (f *flakyDb) QueryFreeWorkers(ctx context.Context, heartbeatTime time.Time) ([]model.RaindropWorker, error) {
+	if f.fail() {
+		return nil, fmt.Errorf("query: %w", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"})
+	}
+	return f.MemoryDb.QueryFreeWorkers(ctx, heartbeatTime)
+}
+
+func (f *flakyDb) HeartbeatWorker(ctx context.Context, w *model.RaindropWorker) (*model.RaindropWorker, error) {
+	if f.fail() {
+		if f.applyBeforeFail {
+			f.MemoryDb.HeartbeatWorker(ctx, w)
+		}
+		return nil, &pgconn.PgError{Code: "40001"}
+	}
+	return f.MemoryDb.HeartbeatWorker(ctx, w)
+}
+
+func getTestRetryConfig() config.RainDropConfig {
+	conf := getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeMemory
+	conf.DbConfig.Retry = config.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Duration(5) * time.Millisecond,
+	}
+	return conf
+}
+
+// TestRetryDb_Init 初始化时的临时错误会被重试
+func TestRetryDb_Init(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	clock := raindroptest.NewClock(time.Now())
+	conf := getTestRetryConfig()
+	conf.Clock = clock
+
+	f := &flakyDb{MemoryDb: db.NewMemoryDb(clock), failures: 2}
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, f))
+	assert.Equal(t, int64(minWorkerId), worker.GetWorkerId(ctx))
+
+	f = &flakyDb{MemoryDb: db.NewMemoryDb(clock), failures: 3}
+	err := raindrop.InitWithDb(ctx, conf, f)
+	assert.True(t, errors.Is(err, driver.ErrBadConn))
+}
+
+// TestRetryDb_Observer 临时错误重试及重试后仍失败时回调观察者，观察者 panic 不影响初始化
+func TestRetryDb_Observer(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	clock := raindroptest.NewClock(time.Now())
+	conf := getTestRetryConfig()
+	conf.Clock = clock
+	o := &dbObserver{}
+	conf.Observer = o
+
+	f := &flakyDb{MemoryDb: db.NewMemoryDb(clock), failures: 2}
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, f))
+	assert.Equal(t, []string{"retry:GetNowTime:1", "retry:GetNowTime:2"}, filterTestEvents(o.Events(), "retry"))
+
+	o = &dbObserver{}
+	conf.Observer = o
+	f = &flakyDb{MemoryDb: db.NewMemoryDb(clock), failures: 3}
+	assert.Error(t, raindrop.InitWithDb(ctx, conf, f))
+	assert.Equal(t, []string{"retry:GetNowTime:1", "retry:GetNowTime:2", "retryFailed:GetNowTime:3"}, filterTestEvents(o.Events(), "retry"))
+
+	conf.Observer = panicDbObserver{}
+	f = &flakyDb{MemoryDb: db.NewMemoryDb(clock), failures: 2}
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, f))
+}
+
+// panicDbObserver 数据库重试时 panic 的观察者
+type panicDbObserver struct {
+	config.NopObserver
+}
+
+func (panicDbObserver) DbRetry(ctx context.Context, op string, attempt int, wait time.Duration, err error) {
+	panic("observer panic")
+}
+
+// filterTestEvents 获取以 prefix 开头的事件
+func filterTestEvents(events []string, prefix string) []string {
+	var filtered []string
+	for _, e := range events {
+		if strings.HasPrefix(e, prefix) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// TestRetryDb_Heartbeat 心跳在数据库已生效但连接断开时，重试不会误判为租约丢失
+func TestRetryDb_Heartbeat(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	clock := raindroptest.NewClock(time.Now())
+	conf := getTestRetryConfig()
+	conf.Clock = clock
+
+	f := &flakyDb{MemoryDb: db.NewMemoryDb(clock)}
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, f))
+
+	f.calls = 0
+	f.failures = 1
+	f.applyBeforeFail = true
+	assert.NoError(t, worker.Heartbeat(ctx))
+	assert.NoError(t, worker.Heartbeat(ctx))
+
+	// 租约确实被抢占时仍然返回租约丢失
+	_, err := f.StealWorker(ctx, worker.GetWorkerId(ctx), "thief")
+	assert.NoError(t, err)
+	f.calls = 0
+	f.failures = 1
+	f.applyBeforeFail = false
+	err = worker.Heartbeat(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+}
+
+// TestIsRetryableError 可重试错误判断
+func TestIsRetryableError(t *testing.T) {
+	assert.True(t, db.IsRetryableError(driver.ErrBadConn))
+	assert.True(t, db.IsRetryableError(&mysql.MySQLError{Number: 1213}))
+	assert.True(t, db.IsRetryableError(&mysql.MySQLError{Number: 1205}))
+	assert.True(t, db.IsRetryableError(&pgconn.PgError{Code: "40001"}))
+	assert.True(t, db.IsRetryableError(&pgconn.PgError{Code: "40P01"}))
+	assert.True(t, db.IsRetryableError(&pgconn.PgError{Code: "08006"}))
+	assert.False(t, db.IsRetryableError(&mysql.MySQLError{Number: 1062}))
+	assert.False(t, db.IsRetryableError(&pgconn.PgError{Code: "23505"}))
+	assert.False(t, db.IsRetryableError(consts.ErrMsgWorkerLeaseLost))
+	assert.False(t, db.IsRetryableError(context.Canceled))
+	assert.False(t, db.IsRetryableError(errors.New("syntax error")))
+}
@@ -0,0 +1,579 @@
+package tests
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/worker"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSqliteDb 基于临时文件创建 SQLite 数据库
+func newTestSqliteDb(t *testing.T) *db.SqlDb {
+	sqlDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "raindrop.db"))
+	if err != nil {
+		t.Fatalf("%s open sqlite fail. %s", t.Name(), err.Error())
+	}
+	sqlDb.SetMaxOpenConns(1)
+	t.Cleanup(func() {
+		sqlDb.Close()
+	})
+	return db.NewSqlDb(sqlDb, db.SqliteDialect{})
+}
+
+// TestSqliteDb_Workers SQLite 方言下的 worker 分配与心跳
+func TestSqliteDb_Workers(t *testing.T) {
+	ctx := getTestContext()
+	d := newTestSqliteDb(t)
+	err := db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger())
+	assert.NoError(t, err)
+
+	exist, err := d.ExistTable(ctx)
+	assert.NoError(t, err)
+	assert.False(t, exist)
+
+	current, latest, err := d.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, current)
+	assert.Equal(t, len(db.SqliteDialect{}.Migrations(db.Table{Name: tableName})), latest)
+
+	assert.NoError(t, d.Migrate(ctx))
+	// 重复迁移不会报错
+	assert.NoError(t, d.Migrate(ctx))
+	current, _, err = d.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, latest, current)
+
+	_, err = d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+	assert.NoError(t, err)
+	// 重复对账不会产生重复数据
+	_, err = d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+	assert.NoError(t, err)
+
+	exist, err = d.ExistTable(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	now, err := d.GetNowTime(ctx)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().Unix(), now.Unix(), consts.DatabaseTimeInterval)
+
+	workers, err := d.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+
+	w, err := d.ActivateWorker(ctx, workers[0].Id, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+	assert.NoError(t, err)
+	assert.Equal(t, workers[0].Version+1, w.Version)
+	assert.Equal(t, "code", w.Code)
+
+	// 版本号不匹配时激活失败
+	w2, err := d.ActivateWorker(ctx, workers[0].Id, "other", int(consts.TimeUnitSecond), "", workers[0].Version)
+	assert.NoError(t, err)
+	assert.Nil(t, w2)
+
+	before, err := d.GetBeforeWorker(ctx, "code")
+	assert.NoError(t, err)
+	assert.Equal(t, w.Id, before.Id)
+
+	w3, err := d.HeartbeatWorker(ctx, w)
+	assert.NoError(t, err)
+	assert.Equal(t, w.Version+1, w3.Version)
+
+	_, err = d.HeartbeatWorker(ctx, w)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+
+	workers, err = d.QueryFreeWorkers(ctx, time.Now().Add(time.Duration(-1)*time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId)
+}
+
+// TestSqliteDb_Init 基于 SQLite 初始化并获取id
+func TestSqliteDb_Init(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeSQLite
+
+	err := raindrop.InitWithDb(ctx, conf, newTestSqliteDb(t))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(minWorkerId), worker.GetWorkerId(ctx))
+
+	id, err := raindrop.NewId()
+	assert.NoError(t, err)
+	assert.True(t, id > 0)
+}
+
+// TestSqliteDb_MigrateObserver 每执行一个版本的表结构迁移回调观察者，已迁移的版本不再回调
+func TestSqliteDb_MigrateObserver(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestSecondConfig()
+	conf.DbConfig.DbType = consts.DbTypeSQLite
+	o := &dbObserver{}
+	conf.Observer = o
+
+	d := newTestSqliteDb(t)
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, d))
+	var versions []int
+	for _, m := range (db.SqliteDialect{}).Migrations(db.Table{Name: tableName}) {
+		versions = append(versions, m.Version)
+	}
+	assert.Equal(t, versions, o.migrated)
+	assert.Empty(t, filterTestEvents(o.Events(), "migrateFailed"))
+
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, d))
+	assert.Equal(t, versions, o.migrated)
+}
+
+// TestSqliteDb_CallerPool 调用方提供的连接池不会被 raindrop 关闭
+func TestSqliteDb_CallerPool(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	sqlDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "raindrop.db"))
+	assert.NoError(t, err)
+	sqlDb.SetMaxOpenConns(1)
+	defer sqlDb.Close()
+
+	conf := getTestSecondConfig()
+	conf.DbConfig = config.RainDropDbConfig{
+		DbType:           consts.DbTypeSQLite,
+		TableName:        tableName,
+		SqlDb:            sqlDb,
+		StatementTimeout: time.Duration(5) * time.Second,
+	}
+	raindrop.Init(ctx, conf)
+	assert.Equal(t, int64(minWorkerId), worker.GetWorkerId(ctx))
+
+	assert.NoError(t, db.Close(ctx))
+	assert.NoError(t, sqlDb.PingContext(ctx))
+
+	conf.DbConfig.MaxOpenConns = -1
+	err = raindrop.InitWithDb(ctx, conf, db.NewSqlDb(sqlDb, db.SqliteDialect{}))
+	assert.Error(t, err)
+}
+
+// TestSqliteDb_ReconcileWorkers SQLite 方言下对账 worker
+func TestSqliteDb_ReconcileWorkers(t *testing.T) {
+	ctx := getTestContext()
+	d := newTestSqliteDb(t)
+	assert.NoError(t, db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger()))
+	assert.NoError(t, d.Migrate(ctx))
+
+	result, err := d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(maxWorkerId-minWorkerId+1), result.Inserted)
+
+	result, err = d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, model.WorkerReconcileResult{}, *result)
+
+	result, err = d.ReconcileWorkers(ctx, minWorkerId-3, maxWorkerId, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), result.Inserted)
+
+	// 心跳未过期的范围外 worker 不会被标记
+	workers, err := d.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	leased, err := d.ActivateWorker(ctx, workers[0].Id, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+	assert.NoError(t, err)
+	assert.True(t, leased.Id < minWorkerId)
+
+	disableBefore := time.Now().Add(time.Duration(-1) * time.Minute)
+	result, err = d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, disableBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.Disabled)
+	assert.Equal(t, int64(0), result.Enabled)
+
+	workers, err = d.QueryFreeWorkers(ctx, disableBefore)
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+	_, err = d.HeartbeatWorker(ctx, leased)
+	assert.NoError(t, err)
+
+	result, err = d.ReconcileWorkers(ctx, minWorkerId-3, maxWorkerId, disableBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.Enabled)
+	workers, err = d.QueryFreeWorkers(ctx, disableBefore)
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+3)
+}
+
+// TestSqliteDb_DisableMigrate 禁止运行时迁移时，需先调用 Migrate 完成迁移
+func TestSqliteDb_DisableMigrate(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	sqlDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "raindrop.db"))
+	assert.NoError(t, err)
+	sqlDb.SetMaxOpenConns(1)
+	defer sqlDb.Close()
+
+	conf := getTestSecondConfig()
+	conf.DbConfig = config.RainDropDbConfig{
+		DbType:         consts.DbTypeSQLite,
+		TableName:      tableName,
+		SqlDb:          sqlDb,
+		DisableMigrate: true,
+	}
+	err = raindrop.InitWithDb(ctx, conf, db.NewSqlDb(sqlDb, db.SqliteDialect{}))
+	assert.True(t, errors.Is(err, consts.ErrMsgSchemaVersionOutdated))
+
+	assert.NoError(t, raindrop.Migrate(ctx, conf))
+	err = raindrop.InitWithDb(ctx, conf, db.NewSqlDb(sqlDb, db.SqliteDialect{}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(minWorkerId), worker.GetWorkerId(ctx))
+}
+
+// upgradeSqliteDialect 在 SqliteDialect 的基础上增加一个版本的迁移
+type upgradeSqliteDialect struct {
+	db.SqliteDialect
+}
+
+func (d upgradeSqliteDialect) Migrations(table db.Table) []db.Migration {
+	base := d.SqliteDialect.Migrations(table)
+	return append(base, db.Migration{
+		Version:     len(base) + 1,
+		Description: "add owner column",
+		Sql:         []string{"ALTER TABLE " + d.QuoteTable(table) + " ADD COLUMN \"owner\" VARCHAR(128) NOT NULL DEFAULT ''"},
+	})
+}
+
+// TestSqliteDb_MigrateUpgrade 已有的表只执行未执行过的迁移
+func TestSqliteDb_MigrateUpgrade(t *testing.T) {
+	ctx := getTestContext()
+	sqlDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "raindrop.db"))
+	assert.NoError(t, err)
+	sqlDb.SetMaxOpenConns(1)
+	defer sqlDb.Close()
+
+	d := db.NewSqlDb(sqlDb, db.SqliteDialect{})
+	assert.NoError(t, db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger()))
+	assert.NoError(t, d.Migrate(ctx))
+
+	u := db.NewSqlDb(sqlDb, upgradeSqliteDialect{})
+	assert.NoError(t, db.UseDb(ctx, u, getTestConfig(), getTestStdoutLogger()))
+	base, latest, err := u.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(db.SqliteDialect{}.Migrations(db.Table{Name: tableName})), base)
+	assert.Equal(t, base+1, latest)
+
+	assert.NoError(t, u.Migrate(ctx))
+	assert.NoError(t, u.Migrate(ctx))
+	current, _, err := u.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, latest, current)
+
+	var count int
+	assert.NoError(t, sqlDb.QueryRowContext(ctx, "SELECT count(*) FROM \""+tableName+"_schema_version\"").Scan(&count))
+	assert.Equal(t, latest, count)
+
+	// 旧版本的程序遇到更新的表结构时跳过迁移
+	assert.NoError(t, d.Migrate(ctx))
+}
+
+// TestSqliteDb_Schema 同一个数据库中的多张表以及不同 schema 下的同名表互不影响
+func TestSqliteDb_Schema(t *testing.T) {
+	ctx := getTestContext()
+	dir := t.TempDir()
+	sqlDb, err := sql.Open("sqlite", filepath.Join(dir, "raindrop.db"))
+	assert.NoError(t, err)
+	sqlDb.SetMaxOpenConns(1)
+	defer sqlDb.Close()
+	_, err = sqlDb.ExecContext(ctx, "ATTACH DATABASE '"+filepath.Join(dir, "other.db")+"' AS other")
+	assert.NoError(t, err)
+
+	dbConfig := getTestConfig()
+	for _, table := range []db.Table{{Name: "raindrop_a"}, {Name: "raindrop_b"}, {Schema: "other", Name: "raindrop_a"}} {
+		d := db.NewSqlDb(sqlDb, db.SqliteDialect{})
+		dbConfig.Schema = table.Schema
+		dbConfig.TableName = table.Name
+		assert.NoError(t, db.UseDb(ctx, d, dbConfig, getTestStdoutLogger()))
+
+		exist, err := d.ExistTable(ctx)
+		assert.NoError(t, err)
+		assert.False(t, exist, table.String())
+
+		assert.NoError(t, d.Migrate(ctx), table.String())
+		result, err := d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(maxWorkerId-minWorkerId+1), result.Inserted, table.String())
+
+		exist, err = d.ExistTable(ctx)
+		assert.NoError(t, err)
+		assert.True(t, exist, table.String())
+	}
+
+	var count int
+	// worker 表的 2 个索引，分配历史表及时钟异常表各 1 个索引
+	assert.NoError(t, sqlDb.QueryRowContext(ctx, "SELECT count(*) FROM other.sqlite_master WHERE type = 'index' AND sql IS NOT NULL").Scan(&count))
+	assert.Equal(t, 4, count)
+
+	dbConfig.Schema = ""
+	dbConfig.TableName = tableName
+	assert.NoError(t, db.UseDb(ctx, newTestSqliteDb(t), dbConfig, getTestStdoutLogger()))
+}
+
+// TestSqliteDb_Service 不同服务共用一张表时各自拥有相同id的worker，同一id空间不能被多个服务使用
+func TestSqliteDb_Service(t *testing.T) {
+	ctx := getTestContext()
+	sqlDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "raindrop.db"))
+	assert.NoError(t, err)
+	sqlDb.SetMaxOpenConns(1)
+	defer sqlDb.Close()
+
+	dbConfig := getTestConfig()
+	services := make(map[string]*db.SqlDb)
+	for _, service := range []string{"order", "user"} {
+		d := db.NewSqlDb(sqlDb, db.SqliteDialect{})
+		dbConfig.Service = service
+		dbConfig.IdSpace = service
+		assert.NoError(t, db.UseDb(ctx, d, dbConfig, getTestStdoutLogger()))
+		assert.NoError(t, db.InitTableWorkers(ctx, 0, minWorkerId, maxWorkerId, time.Time{}))
+		services[service] = d
+	}
+
+	order, user := services["order"], services["user"]
+	workers, err := order.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+	assert.Equal(t, "order", workers[0].Service)
+
+	w, err := order.ActivateWorker(ctx, workers[0].Id, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+	assert.NoError(t, err)
+	assert.NotNil(t, w)
+
+	// 其他服务中相同id的worker不受影响
+	before, err := user.GetBeforeWorker(ctx, "code")
+	assert.NoError(t, err)
+	assert.Nil(t, before)
+	workers, err = user.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+	assert.Equal(t, w.Id, workers[0].Id)
+	assert.Equal(t, "user", workers[0].Service)
+
+	// user 服务使用 order 的id空间时拒绝启动
+	dbConfig.Service = "user"
+	dbConfig.IdSpace = "order"
+	assert.NoError(t, db.UseDb(ctx, db.NewSqlDb(sqlDb, db.SqliteDialect{}), dbConfig, getTestStdoutLogger()))
+	err = db.InitTableWorkers(ctx, 0, minWorkerId, maxWorkerId, time.Time{})
+	assert.True(t, errors.Is(err, consts.ErrMsgIdSpaceConflict))
+
+	dbConfig = getTestConfig()
+	assert.NoError(t, db.UseDb(ctx, newTestSqliteDb(t), dbConfig, getTestStdoutLogger()))
+}
+
+// TestSqliteDb_Datacenter 不同数据中心各自拥有完整的worker范围
+func TestSqliteDb_Datacenter(t *testing.T) {
+	ctx := getTestContext()
+	d := newTestSqliteDb(t)
+	assert.NoError(t, db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger()))
+
+	for _, datacenterId := range []int64{0, 1} {
+		assert.NoError(t, db.InitTableWorkers(ctx, datacenterId, minWorkerId, maxWorkerId, time.Time{}))
+		workers, err := d.QueryFreeWorkers(ctx, time.Now())
+		assert.NoError(t, err)
+		assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+		assert.Equal(t, datacenterId, workers[0].DatacenterId)
+
+		w, err := d.ActivateWorker(ctx, minWorkerId, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+		assert.NoError(t, err)
+		assert.NotNil(t, w)
+		assert.Equal(t, datacenterId, w.DatacenterId)
+	}
+
+	// 数据中心 1 激活的worker不影响数据中心 0
+	d.InitSql("", tableName, "", 0)
+	w, err := d.GetWorkerById(ctx, minWorkerId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), w.DatacenterId)
+	assert.Equal(t, int64(2), w.Version)
+}
+
+// TestSqliteDb_ChangeLayout worker 最近被其他 id 结构使用时拒绝激活，变更 id 结构后新 id 大于已生成的 id
+func TestSqliteDb_ChangeLayout(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	sqlDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "raindrop.db"))
+	assert.NoError(t, err)
+	sqlDb.SetMaxOpenConns(1)
+	defer sqlDb.Close()
+
+	conf := getTestSecondConfig()
+	conf.ServiceMaxWorkId = minWorkerId
+	conf.DbConfig = config.RainDropDbConfig{
+		DbType:    consts.DbTypeSQLite,
+		TableName: tableName,
+		SqlDb:     sqlDb,
+	}
+	assert.NoError(t, raindrop.InitWithDb(ctx, conf, db.NewSqlDb(sqlDb, db.SqliteDialect{})))
+	oldId, err := raindrop.NewId()
+	assert.NoError(t, err)
+
+	w, err := db.Db.GetWorkerById(ctx, minWorkerId)
+	assert.NoError(t, err)
+	assert.Equal(t, worker.LayoutFingerprint(conf), w.Layout)
+
+	// 数据中心 id 不影响 id 结构
+	newConf := conf
+	newConf.DatacenterId = 1
+	assert.Equal(t, worker.LayoutFingerprint(conf), worker.LayoutFingerprint(newConf))
+
+	// 模拟旧结构的服务已停止
+	_, err = sqlDb.ExecContext(ctx, "UPDATE \""+tableName+"\" SET \"heartbeat_time\" = ?", time.Now().UTC().Add(-10*time.Minute))
+	assert.NoError(t, err)
+
+	newConf = conf
+	newConf.StartTimeStamp = time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local)
+	assert.NotEqual(t, worker.LayoutFingerprint(conf), worker.LayoutFingerprint(newConf))
+	err = raindrop.InitWithDb(ctx, newConf, db.NewSqlDb(sqlDb, db.SqliteDialect{}))
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLayoutMismatch))
+
+	_, err = raindrop.ChangeLayout(ctx, newConf, worker.MinIdAt(newConf, time.Now().Add(time.Hour)))
+	assert.True(t, errors.Is(err, consts.ErrMsgLayoutChangeUnsafe))
+
+	count, err := raindrop.ChangeLayout(ctx, newConf, oldId)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, raindrop.InitWithDb(ctx, newConf, db.NewSqlDb(sqlDb, db.SqliteDialect{})))
+	newId, err := raindrop.NewId()
+	assert.NoError(t, err)
+	assert.Greater(t, newId, oldId)
+}
+
+// TestSqliteDb_AdminWorkers 停用、启用、释放及删除 worker 均按版本号乐观锁执行
+func TestSqliteDb_AdminWorkers(t *testing.T) {
+	ctx := getTestContext()
+	d := newTestSqliteDb(t)
+	assert.NoError(t, db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger()))
+	assert.NoError(t, d.Migrate(ctx))
+	_, err := d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Time{})
+	assert.NoError(t, err)
+
+	workers, err := d.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId+1)
+	leased, err := d.ActivateWorker(ctx, minWorkerId, "code", int(consts.TimeUnitSecond), "", workers[0].Version)
+	assert.NoError(t, err)
+
+	// 版本号不一致时失败
+	err = d.DisableWorker(ctx, minWorkerId, workers[0].Version)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerVersionConflict))
+	assert.NoError(t, d.DisableWorker(ctx, minWorkerId, leased.Version))
+
+	// 停用后持有节点失去租约，不会被分配，对账时也不会恢复
+	_, err = d.HeartbeatWorker(ctx, leased)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+	free, err := d.QueryFreeWorkers(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, free, maxWorkerId-minWorkerId)
+	_, err = d.ReconcileWorkers(ctx, minWorkerId, maxWorkerId, time.Now())
+	assert.NoError(t, err)
+	workers, err = d.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, consts.WorkerDelFlagDisabled, workers[0].DelFlag)
+	assert.Equal(t, leased.Version+1, workers[0].Version)
+
+	assert.NoError(t, d.EnableWorker(ctx, minWorkerId, workers[0].Version))
+	err = d.EnableWorker(ctx, minWorkerId, workers[0].Version+1)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerVersionConflict))
+
+	// 强制释放后递增版本号，原持有节点的租约过期后才空闲
+	leased, err = d.ActivateWorker(ctx, minWorkerId, "code", int(consts.TimeUnitSecond), "", workers[0].Version+1)
+	assert.NoError(t, err)
+	assert.NoError(t, d.ReleaseWorker(ctx, minWorkerId, leased.Version))
+	_, err = d.HeartbeatWorker(ctx, leased)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+	w, err := d.GetWorkerById(ctx, minWorkerId)
+	assert.NoError(t, err)
+	assert.Equal(t, "", w.Code)
+	assert.Equal(t, leased.Version+1, w.Version)
+	free, err = d.QueryFreeWorkers(ctx, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, free, maxWorkerId-minWorkerId)
+	free, err = d.QueryFreeWorkers(ctx, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, free, maxWorkerId-minWorkerId+1)
+
+	// 仅能删除已停用的 worker
+	err = d.DeleteWorker(ctx, minWorkerId, w.Version)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerVersionConflict))
+	assert.NoError(t, d.DisableWorker(ctx, minWorkerId, w.Version))
+	assert.NoError(t, d.DeleteWorker(ctx, minWorkerId, w.Version+1))
+	workers, err = d.ListWorkers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, workers, maxWorkerId-minWorkerId)
+}
+
+// TestSqliteDb_WorkerHistory 分配历史按服务命名空间、数据中心及 worker 查询，按事件时间排序
+func TestSqliteDb_WorkerHistory(t *testing.T) {
+	ctx := getTestContext()
+	d := newTestSqliteDb(t)
+	assert.NoError(t, db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger()))
+	assert.NoError(t, d.Migrate(ctx))
+
+	now := time.Now().Truncate(time.Millisecond)
+	assert.NoError(t, d.AddWorkerHistory(ctx, &model.WorkerHistory{WorkerId: minWorkerId, Version: 3, Event: consts.WorkerEventRelease,
+		PreviousCode: "a", Layout: "layout", EventTime: now}))
+	assert.NoError(t, d.AddWorkerHistory(ctx, &model.WorkerHistory{WorkerId: minWorkerId, Version: 2, Event: consts.WorkerEventActivate,
+		Code: "a", Layout: "layout", TimeSeq: 100, EventTime: now.Add(-time.Minute)}))
+	assert.NoError(t, d.AddWorkerHistory(ctx, &model.WorkerHistory{WorkerId: maxWorkerId, Version: 2, Event: consts.WorkerEventActivate,
+		Code: "b", EventTime: now}))
+	d.InitSql("", tableName, "", 1)
+	assert.NoError(t, d.AddWorkerHistory(ctx, &model.WorkerHistory{WorkerId: minWorkerId, Version: 2, Event: consts.WorkerEventActivate,
+		Code: "c", EventTime: now}))
+
+	histories, err := d.QueryWorkerHistory(ctx, 0, minWorkerId)
+	assert.NoError(t, err)
+	assert.Len(t, histories, 2)
+	assert.Equal(t, consts.WorkerEventActivate, histories[0].Event)
+	assert.Equal(t, "a", histories[0].Code)
+	assert.Equal(t, int64(100), histories[0].TimeSeq)
+	assert.True(t, now.Add(-time.Minute).Equal(histories[0].EventTime))
+	assert.Equal(t, consts.WorkerEventRelease, histories[1].Event)
+	assert.Equal(t, "a", histories[1].PreviousCode)
+
+	histories, err = d.QueryWorkerHistory(ctx, 1, minWorkerId)
+	assert.NoError(t, err)
+	assert.Len(t, histories, 1)
+	assert.Equal(t, "c", histories[0].Code)
+}
+
+// TestSqliteDb_ClockIncidents 时钟异常按服务命名空间及时间范围查询，包含全部数据中心
+func TestSqliteDb_ClockIncidents(t *testing.T) {
+	ctx := getTestContext()
+	d := newTestSqliteDb(t)
+	assert.NoError(t, db.UseDb(ctx, d, getTestConfig(), getTestStdoutLogger()))
+	assert.NoError(t, d.Migrate(ctx))
+
+	now := time.Now().Truncate(time.Millisecond)
+	assert.NoError(t, d.AddClockIncident(ctx, &model.ClockIncident{WorkerId: minWorkerId, Code: "a", Kind: consts.ClockIncidentDbDrift,
+		Drift: -45 * time.Second, Count: 1, EventTime: now}))
+	assert.NoError(t, d.AddClockIncident(ctx, &model.ClockIncident{WorkerId: minWorkerId, Code: "a", Kind: consts.ClockIncidentBackwards,
+		LastTimeSeq: 105, TimeSeq: 100, Jump: 5 * time.Second, TimeBackBefore: 0, TimeBackAfter: 1, Count: 1, EventTime: now.Add(-time.Minute)}))
+	assert.NoError(t, d.AddClockIncident(ctx, &model.ClockIncident{WorkerId: minWorkerId, Kind: consts.ClockIncidentBackwards,
+		Count: 1, EventTime: now.Add(-time.Hour)}))
+	d.InitSql("", tableName, "", 1)
+	assert.NoError(t, d.AddClockIncident(ctx, &model.ClockIncident{WorkerId: maxWorkerId, Code: "b", Kind: consts.ClockIncidentSeqExhausted,
+		IdCode: "order", Count: 3, EventTime: now}))
+	d.InitSql("", tableName, "other", 0)
+	assert.NoError(t, d.AddClockIncident(ctx, &model.ClockIncident{WorkerId: minWorkerId, Kind: consts.ClockIncidentBackwards,
+		Count: 1, EventTime: now}))
+	d.InitSql("", tableName, "", 0)
+
+	incidents, err := d.QueryClockIncidents(ctx, now.Add(-30*time.Minute), now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, incidents, 3)
+	assert.Equal(t, consts.ClockIncidentBackwards, incidents[0].Kind)
+	assert.Equal(t, 5*time.Second, incidents[0].Jump)
+	assert.Equal(t, int64(1), incidents[0].TimeBackAfter)
+	assert.True(t, now.Add(-time.Minute).Equal(incidents[0].EventTime))
+	assert.Equal(t, -45*time.Second, incidents[1].Drift)
+	assert.Equal(t, int64(1), incidents[2].DatacenterId)
+	assert.Equal(t, "order", incidents[2].IdCode)
+	assert.Equal(t, int64(3), incidents[2].Count)
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/worker"
+)
+
+// getTestStatus 请求健康检查的路径，返回状态码及运行状态
+func getTestStatus(t *testing.T, path string) (int, model.Status) {
+	recorder := httptest.NewRecorder()
+	raindrop.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	var status model.Status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("%s decode status fail. %s", t.Name(), err.Error())
+	}
+	return recorder.Code, status
+}
+
+// TestStatus 运行状态包含worker、租约、序列号使用率及生成的id数量，租约丢失时存活及就绪检查均不通过且停止生成id
+func TestStatus(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	g := newTestGenerator(t, getTestSecondConfig())
+	// 同一时间戳流水内重新初始化时序列号延续之前生成的id
+	seqUsed := raindrop.Status(ctx).SeqUsed
+	for i := 0; i < 3; i++ {
+		_, err := g.NewId(ctx)
+		assert.NoError(t, err)
+	}
+
+	status := raindrop.Status(ctx)
+	assert.True(t, status.Initialized)
+	assert.True(t, status.Live)
+	assert.True(t, status.Ready)
+	assert.Empty(t, status.Problems)
+	assert.Equal(t, g.WorkerId(ctx), status.WorkerId)
+	assert.NotEmpty(t, status.Code)
+	assert.Equal(t, int64(3), status.IssuedIds)
+	assert.Equal(t, seqUsed+3, status.SeqUsed)
+	assert.Equal(t, status.SeqCapacity, int64(1)<<(consts.IdBitLength-31-4-consts.TimeBackBitLength))
+	assert.Equal(t, worker.GetTimeBackBitValue(ctx), status.TimeBackBit)
+	assert.True(t, status.RemainingLifetime > 0)
+
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	assert.NoError(t, g.Heartbeat(ctx))
+	code, status := getTestStatus(t, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, time.Duration(0), status.LeaseAge)
+	assert.Equal(t, int64(0), status.SeqUsed)
+	assert.False(t, status.LastHeartbeatTime.IsZero())
+	assert.Empty(t, status.LastHeartbeatError)
+
+	_, err := g.StealLease(ctx, "thief")
+	assert.NoError(t, err)
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	assert.Error(t, g.Heartbeat(ctx))
+	code, status = getTestStatus(t, "/livez")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, status.Live)
+	assert.True(t, status.LeaseLost)
+	_, err = g.NewId(ctx)
+	assert.True(t, errors.Is(err, consts.ErrMsgWorkerLeaseLost))
+	assert.NotEmpty(t, status.LastHeartbeatError)
+	assert.Equal(t, 30*time.Second, status.LeaseAge)
+	code, _ = getTestStatus(t, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	code, _ = getTestStatus(t, "/status")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+// TestStatus_ClockUnhealthy 与DB时间的偏差超过阈值时就绪检查不通过，存活检查仍通过
+func TestStatus_ClockUnhealthy(t *testing.T) {
+	ctx, d := initDriftTest(t, consts.DriftPolicyPause)
+	d.offset = time.Hour
+	assert.NoError(t, worker.Heartbeat(ctx))
+
+	code, status := getTestStatus(t, "/livez")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, status.Drift.Exceeded)
+	code, status = getTestStatus(t, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, status.Ready)
+	assert.Len(t, status.Problems, 2)
+}
+
+// TestStatus_ConcurrentHeartbeat 心跳续约替换worker时可以并发获取运行状态
+func TestStatus_ConcurrentHeartbeat(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	g := newTestGenerator(t, getTestSecondConfig())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			_ = raindrop.Status(ctx)
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, g.Heartbeat(ctx))
+	}
+	<-done
+	assert.Equal(t, g.WorkerId(ctx), raindrop.Status(ctx).WorkerId)
+}
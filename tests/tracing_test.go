@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/tracing"
+	"github.com/treeyh/raindrop/worker"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// 仅链路追踪测试使用的 code，按 code 生成的状态在重新初始化后保留
+const (
+	tracingCode          = "tracing"
+	tracingExhaustedCode = "tracing_exhausted"
+)
+
+// initTracingTest 启用链路追踪，返回记录 span 的 TracerProvider 及观察者，测试结束后取消数据库拦截器
+func initTracingTest(t *testing.T) (*sdktrace.TracerProvider, *tracetest.SpanRecorder, *tracing.Tracing) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() {
+		db.SetInterceptor(nil)
+	})
+	return tp, recorder, tracing.Enable(tp, nil)
+}
+
+// findTestSpan 查找名称为 name 的最后一个 span
+func findTestSpan(recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	spans := recorder.Ended()
+	for i := len(spans) - 1; i >= 0; i-- {
+		if spans[i].Name() == name {
+			return spans[i]
+		}
+	}
+	return nil
+}
+
+// getTestSpanAttr 获取 span 的属性值
+func getTestSpanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// TestTracing_Db 数据库调用的 span 以调用方 ctx 中的 span 为父 span，带有表名及 worker id 属性，时间回拨位取反时在父 span 上记录事件
+func TestTracing_Db(t *testing.T) {
+	tp, recorder, o := initTracingTest(t)
+	conf := getTestSecondConfig()
+	conf.Observer = o
+	g := newTestGenerator(t, conf)
+	activate := findTestSpan(recorder, "raindrop.db.ActivateWorker")
+	assert.NotNil(t, activate)
+	assert.Equal(t, tableName, getTestSpanAttr(activate, tracing.AttrTable).AsString())
+
+	ctx, parent := tp.Tracer("test").Start(getTestSkipHeartbeatContext(), "parent")
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	assert.NoError(t, g.Heartbeat(ctx))
+	_, err := g.NewIdByCode(ctx, tracingCode)
+	assert.NoError(t, err)
+	g.Advance(ctx, -3*time.Second)
+	_, err = g.NewIdByCode(ctx, tracingCode)
+	assert.NoError(t, err)
+	parent.End()
+
+	heartbeat := findTestSpan(recorder, "raindrop.db.HeartbeatWorker")
+	assert.NotNil(t, heartbeat)
+	assert.Equal(t, parent.SpanContext().SpanID(), heartbeat.Parent().SpanID())
+	assert.Equal(t, worker.GetWorkerId(ctx), getTestSpanAttr(heartbeat, tracing.AttrWorkerId).AsInt64())
+	assert.Equal(t, tableName, getTestSpanAttr(heartbeat, tracing.AttrTable).AsString())
+
+	events := findTestSpan(recorder, "parent").Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, tracing.EventTimeBackFlip, events[0].Name)
+}
+
+// TestTracing_SeqExhausted 序列号耗尽且不等待直接返回错误时在父 span 上记录事件
+func TestTracing_SeqExhausted(t *testing.T) {
+	tp, recorder, o := initTracingTest(t)
+	conf := getTestMinuteConfig()
+	// 序列号 3 位，每分钟 8 个id
+	conf.TimeStampLength = 50
+	conf.EndBitsLength = 5
+	conf.Observer = o
+	g := newTestGenerator(t, conf)
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	for i := 0; i < 9; i++ {
+		_, _ = g.NewIdByCode(ctx, tracingExhaustedCode)
+	}
+	parent.End()
+	events := findTestSpan(recorder, "parent").Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, tracing.EventSeqExhausted, events[0].Name)
+}
@@ -1,9 +1,8 @@
 package tests
 
 import (
-	"github.com/treeyh/raindrop"
+	"errors"
 	"github.com/treeyh/raindrop/consts"
-	"github.com/treeyh/raindrop/db"
 	"github.com/treeyh/raindrop/worker"
 	"testing"
 	"time"
@@ -14,9 +13,7 @@ func TestGetDefaultWorkerId(t *testing.T) {
 	ctx := getTestSkipHeartbeatContext()
 	conf := getTestMillisecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	newTestGenerator(t, conf)
 	t.Log(worker.GetWorkerId(ctx))
 	t.Log(worker.GetNowTimeSeq(ctx))
 	if worker.GetWorkerId(ctx) != minWorkerId {
@@ -30,9 +27,7 @@ func TestWorkerHeartbeat(t *testing.T) {
 	ctx := getTestContext()
 	conf := getTestMillisecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	g := newTestGenerator(t, conf)
 	t.Log(worker.GetWorkerId(ctx))
 	t.Log(worker.GetNowTimeSeq(ctx))
 	if worker.GetWorkerId(ctx) != minWorkerId {
@@ -42,27 +37,33 @@ func TestWorkerHeartbeat(t *testing.T) {
 	workerId := worker.GetWorkerId(ctx)
 	seq := worker.GetNowTimeSeq(ctx)
 
-	w, err := db.Db.GetWorkerById(ctx, workerId)
+	w, err := g.Db.GetWorkerById(ctx, workerId)
 	if err != nil {
 		t.Fatalf("%s worker %d get by db error. %s", t.Name(), workerId, err.Error())
 	}
 	t.Logf("%s workerId: %d, heartTime: %s, seq: %d.", t.Name(), workerId, w.HeartbeatTime, seq)
 
-	time.Sleep(time.Duration(consts.HeartbeatTimeInterval+2) * time.Second)
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval+2)*time.Second)
+	if err = g.Heartbeat(ctx); err != nil {
+		t.Fatalf("%s worker %d heartbeat error. %s", t.Name(), workerId, err.Error())
+	}
 
-	w, err = db.Db.GetWorkerById(ctx, workerId)
+	w, err = g.Db.GetWorkerById(ctx, workerId)
 	seq = worker.GetNowTimeSeq(ctx)
 	if err != nil {
 		t.Fatalf("%s worker %d get by db error. %s", t.Name(), workerId, err.Error())
 	}
 	t.Logf("%s workerId: %d, heartTime: %s, seq: %d.", t.Name(), workerId, w.HeartbeatTime, seq)
 
-	time.Sleep(time.Duration(consts.HeartbeatTimeInterval+2) * time.Second)
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval+2)*time.Second)
+	if err = g.Heartbeat(ctx); err != nil {
+		t.Fatalf("%s worker %d heartbeat error. %s", t.Name(), workerId, err.Error())
+	}
 
-	w2, err2 := db.Db.GetWorkerById(ctx, workerId)
+	w2, err2 := g.Db.GetWorkerById(ctx, workerId)
 	seq = worker.GetNowTimeSeq(ctx)
 	if err2 != nil {
-		t.Fatalf("%s worker %d get by db error. %s", t.Name(), workerId, err.Error())
+		t.Fatalf("%s worker %d get by db error. %s", t.Name(), workerId, err2.Error())
 	}
 	t.Logf("%s workerId: %d, heartTime: %s, seq: %d.", t.Name(), workerId, w2.HeartbeatTime, seq)
 
@@ -70,7 +71,9 @@ func TestWorkerHeartbeat(t *testing.T) {
 		t.Fatalf("%s worker heartbeat error. w: %s  w2: %s;", t.Name(), w.HeartbeatTime, w2.HeartbeatTime)
 	}
 
-	raindrop.Init(ctx, conf)
+	if err = g.Restart(ctx); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
 	wId := worker.GetWorkerId(ctx)
 	if wId != workerId+1 {
 		t.Fatalf("%s get next worker error. wId: %d  w2Id: %d;", t.Name(), workerId, wId)
@@ -79,20 +82,49 @@ func TestWorkerHeartbeat(t *testing.T) {
 	t.Logf("%s pass.", t.Name())
 }
 
+// TestWorkerLeaseStolen 租约被其他节点抢占后心跳失败
+func TestWorkerLeaseStolen(t *testing.T) {
+	ctx := getTestContext()
+	conf := getTestMillisecondConfig()
+
+	g := newTestGenerator(t, conf)
+	workerId := worker.GetWorkerId(ctx)
+
+	if _, err := g.StealLease(ctx, "thief"); err != nil {
+		t.Fatalf("%s steal lease error. %s", t.Name(), err.Error())
+	}
+
+	g.Advance(ctx, time.Duration(consts.HeartbeatTimeInterval)*time.Second)
+	err := g.Heartbeat(ctx)
+	if !errors.Is(err, consts.ErrMsgWorkerLeaseLost) {
+		t.Fatalf("%s heartbeat should fail with lease lost, got: %v", t.Name(), err)
+	}
+
+	w, err := g.Db.GetWorkerById(ctx, workerId)
+	if err != nil {
+		t.Fatalf("%s worker %d get by db error. %s", t.Name(), workerId, err.Error())
+	}
+	if w.Code != "thief" {
+		t.Fatalf("%s worker code should be kept by thief, got: %s", t.Name(), w.Code)
+	}
+	t.Logf("%s pass.", t.Name())
+}
+
 // TestMultipleGetWorkerIdDiffServicePort1 不同的ServicePort获取workerId场景
 func TestMultipleGetWorkerIdDiffServicePort1(t *testing.T) {
 	ctx := getTestSkipHeartbeatContext()
 	conf := getTestMillisecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	g := newTestGenerator(t, conf)
 
 	w1 := worker.GetWorkerId(ctx)
 	seq1 := worker.GetNowTimeSeq(ctx)
 
+	g.Advance(ctx, time.Second)
 	conf.ServicePort = 1
-	raindrop.Init(ctx, conf)
+	if err := g.RestartWithConfig(ctx, conf); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
 
 	w2 := worker.GetWorkerId(ctx)
 	seq2 := worker.GetNowTimeSeq(ctx)
@@ -106,9 +138,12 @@ func TestMultipleGetWorkerIdDiffServicePort1(t *testing.T) {
 		t.Fatalf("%s work seq1:%d; seq2:%d error.", t.Name(), seq1, seq2)
 	}
 
+	g.Advance(ctx, time.Second)
 	conf.PriorityEqualCodeWorkId = true
 	conf.ServicePort = 2
-	raindrop.Init(ctx, conf)
+	if err := g.RestartWithConfig(ctx, conf); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
 
 	w3 := worker.GetWorkerId(ctx)
 	seq3 := worker.GetNowTimeSeq(ctx)
@@ -129,14 +164,15 @@ func TestMultipleGetWorkerIdSameServicePort1(t *testing.T) {
 	ctx := getTestSkipHeartbeatContext()
 	conf := getTestMillisecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
-
-	raindrop.Init(ctx, conf)
+	g := newTestGenerator(t, conf)
 
 	w1 := worker.GetWorkerId(ctx)
 	seq1 := worker.GetNowTimeSeq(ctx)
 
-	raindrop.Init(ctx, conf)
+	g.Advance(ctx, time.Second)
+	if err := g.Restart(ctx); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
 
 	w2 := worker.GetWorkerId(ctx)
 	seq2 := worker.GetNowTimeSeq(ctx)
@@ -150,8 +186,11 @@ func TestMultipleGetWorkerIdSameServicePort1(t *testing.T) {
 		t.Fatalf("%s work seq1:%d; seq2:%d error.", t.Name(), seq1, seq2)
 	}
 
+	g.Advance(ctx, time.Second)
 	conf.PriorityEqualCodeWorkId = true
-	raindrop.Init(ctx, conf)
+	if err := g.RestartWithConfig(ctx, conf); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
 
 	w3 := worker.GetWorkerId(ctx)
 	seq3 := worker.GetNowTimeSeq(ctx)
@@ -172,11 +211,15 @@ func TestMultipleGetWorkerIdCountOverflow(t *testing.T) {
 	ctx := getTestSkipHeartbeatContext()
 	conf := getTestMillisecondConfig()
 
-	dropTestWorkerTable(ctx, conf.DbConfig.DbType)
+	g := newTestGenerator(t, conf)
 
 	wIdMap := make(map[int64]bool)
 	for i := conf.ServiceMinWorkId; i <= conf.ServiceMaxWorkId; i++ {
-		raindrop.Init(ctx, conf)
+		if i > conf.ServiceMinWorkId {
+			if err := g.Restart(ctx); err != nil {
+				t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+			}
+		}
 
 		w := worker.GetWorkerId(ctx)
 		seq := worker.GetNowTimeSeq(ctx)
@@ -189,8 +232,66 @@ func TestMultipleGetWorkerIdCountOverflow(t *testing.T) {
 		wIdMap[w] = true
 	}
 
-	// Open the following comment, there should be no worker available, fatal exit
-	//raindrop.Init(ctx, conf)
+	// 所有 worker 均已被占用，再次初始化应失败
+	err := g.Restart(ctx)
+	if !errors.Is(err, consts.ErrMsgWorkersNotAvailable) {
+		t.Fatalf("%s restart should fail with workers not available, got: %v", t.Name(), err)
+	}
+
+	t.Logf("%s pass.", t.Name())
+}
+
+// TestReconcileWorkerRange 调整 worker 范围后重启，补齐缺失的 worker 并标记范围外的 worker
+func TestReconcileWorkerRange(t *testing.T) {
+	ctx := getTestSkipHeartbeatContext()
+	conf := getTestMillisecondConfig()
+
+	g := newTestGenerator(t, conf)
+	if len(g.Db.Workers()) != maxWorkerId-minWorkerId+1 {
+		t.Fatalf("%s init workers count error.", t.Name())
+	}
+
+	// 扩大范围后补齐缺失的 worker
+	conf.ServiceMinWorkId = minWorkerId - 2
+	if err := g.RestartWithConfig(ctx, conf); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
+	if len(g.Db.Workers()) != maxWorkerId-minWorkerId+3 {
+		t.Fatalf("%s reconcile workers count error.", t.Name())
+	}
+	leased := worker.GetWorkerId(ctx)
+
+	// 缩小范围，仍在心跳的 worker 不会被标记
+	conf.ServiceMinWorkId = minWorkerId
+	conf.ServiceMaxWorkId = minWorkerId
+	conf.DisableOutOfRangeWorkers = true
+	g.Advance(ctx, time.Second)
+	if err := g.RestartWithConfig(ctx, conf); err != nil && !errors.Is(err, consts.ErrMsgWorkersNotAvailable) {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
+	for _, w := range g.Db.Workers() {
+		inRange := w.Id == minWorkerId
+		if w.Id == leased && !inRange {
+			if w.DelFlag != 2 {
+				t.Fatalf("%s leased worker %d should not be disabled.", t.Name(), w.Id)
+			}
+			continue
+		}
+		if inRange != (w.DelFlag == 2) {
+			t.Fatalf("%s worker %d del flag error: %d.", t.Name(), w.Id, w.DelFlag)
+		}
+	}
 
-	t.Logf("%s not pass.", t.Name())
+	// 再次扩大范围后恢复已标记的 worker
+	conf.ServiceMinWorkId = minWorkerId - 2
+	conf.ServiceMaxWorkId = maxWorkerId
+	if err := g.RestartWithConfig(ctx, conf); err != nil {
+		t.Fatalf("%s restart error. %s", t.Name(), err.Error())
+	}
+	for _, w := range g.Db.Workers() {
+		if w.DelFlag != 2 {
+			t.Fatalf("%s worker %d should be enabled.", t.Name(), w.Id)
+		}
+	}
+	t.Logf("%s pass.", t.Name())
 }
@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracedDb 为每次IDb调用创建 span，父 span 取自调用方的 ctx，属性包含表名、服务命名空间、数据中心及 worker id
+type TracedDb struct {
+	d      db.IDb
+	tracer trace.Tracer
+
+	// attrs InitSql 时记录的表名、服务命名空间及数据中心
+	attrs atomic.Pointer[[]attribute.KeyValue]
+}
+
+// Unwrap 获取被包装的IDb
+func (t *TracedDb) Unwrap() db.IDb {
+	return t.d
+}
+
+// Close 关闭被包装的IDb
+func (t *TracedDb) Close() error {
+	if c, ok := t.d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// start 创建IDb调用的 span
+func (t *TracedDb) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "raindrop.db."+method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(*t.attrs.Load()...), trace.WithAttributes(attrs...))
+}
+
+// endSpan 结束 span，err 不为空时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *TracedDb) InitSql(schema string, tableName string, service string, datacenterId int64) {
+	table := tableName
+	if schema != "" {
+		table = schema + "." + tableName
+	}
+	t.attrs.Store(&[]attribute.KeyValue{
+		AttrTable.String(table),
+		AttrService.String(service),
+		AttrDatacenterId.Int64(datacenterId),
+	})
+	t.d.InitSql(schema, tableName, service, datacenterId)
+}
+
+func (t *TracedDb) GetNowTime(ctx context.Context) (time.Time, error) {
+	ctx, span := t.start(ctx, "GetNowTime")
+	now, err := t.d.GetNowTime(ctx)
+	endSpan(span, err)
+	return now, err
+}
+
+func (t *TracedDb) ExistTable(ctx context.Context) (bool, error) {
+	ctx, span := t.start(ctx, "ExistTable")
+	exist, err := t.d.ExistTable(ctx)
+	endSpan(span, err)
+	return exist, err
+}
+
+func (t *TracedDb) Migrate(ctx context.Context) error {
+	ctx, span := t.start(ctx, "Migrate")
+	err := t.d.Migrate(ctx)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) SchemaVersion(ctx context.Context) (int, int, error) {
+	ctx, span := t.start(ctx, "SchemaVersion")
+	current, latest, err := t.d.SchemaVersion(ctx)
+	endSpan(span, err)
+	return current, latest, err
+}
+
+func (t *TracedDb) RegisterService(ctx context.Context, idSpace string) error {
+	ctx, span := t.start(ctx, "RegisterService", AttrIdSpace.String(idSpace))
+	err := t.d.RegisterService(ctx, idSpace)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) ReconcileWorkers(ctx context.Context, beginId int64, endId int64, disableBefore time.Time) (*model.WorkerReconcileResult, error) {
+	ctx, span := t.start(ctx, "ReconcileWorkers", AttrMinWorkerId.Int64(beginId), AttrMaxWorkerId.Int64(endId))
+	result, err := t.d.ReconcileWorkers(ctx, beginId, endId, disableBefore)
+	endSpan(span, err)
+	return result, err
+}
+
+func (t *TracedDb) GetBeforeWorker(ctx context.Context, code string) (*model.RaindropWorker, error) {
+	ctx, span := t.start(ctx, "GetBeforeWorker", AttrCode.String(code))
+	w, err := t.d.GetBeforeWorker(ctx, code)
+	if w != nil {
+		span.SetAttributes(AttrWorkerId.Int64(w.Id))
+	}
+	endSpan(span, err)
+	return w, err
+}
+
+func (t *TracedDb) QueryFreeWorkers(ctx context.Context, heartbeatTime time.Time) ([]model.RaindropWorker, error) {
+	ctx, span := t.start(ctx, "QueryFreeWorkers")
+	workers, err := t.d.QueryFreeWorkers(ctx, heartbeatTime)
+	span.SetAttributes(AttrFreeWorkers.Int(len(workers)))
+	endSpan(span, err)
+	return workers, err
+}
+
+func (t *TracedDb) ActivateWorker(ctx context.Context, id int64, code string, timeUnit int, layout string, version int64) (*model.RaindropWorker, error) {
+	ctx, span := t.start(ctx, "ActivateWorker", AttrWorkerId.Int64(id), AttrCode.String(code), AttrVersion.Int64(version))
+	w, err := t.d.ActivateWorker(ctx, id, code, timeUnit, layout, version)
+	endSpan(span, err)
+	return w, err
+}
+
+func (t *TracedDb) ChangeWorkersLayout(ctx context.Context, layout string, heartbeatTime time.Time) (int64, error) {
+	ctx, span := t.start(ctx, "ChangeWorkersLayout", AttrLayout.String(layout))
+	count, err := t.d.ChangeWorkersLayout(ctx, layout, heartbeatTime)
+	endSpan(span, err)
+	return count, err
+}
+
+func (t *TracedDb) HeartbeatWorker(ctx context.Context, worker *model.RaindropWorker) (*model.RaindropWorker, error) {
+	ctx, span := t.start(ctx, "HeartbeatWorker", AttrWorkerId.Int64(worker.Id), AttrVersion.Int64(worker.Version))
+	w, err := t.d.HeartbeatWorker(ctx, worker)
+	endSpan(span, err)
+	return w, err
+}
+
+func (t *TracedDb) GetWorkerById(ctx context.Context, id int64) (*model.RaindropWorker, error) {
+	ctx, span := t.start(ctx, "GetWorkerById", AttrWorkerId.Int64(id))
+	w, err := t.d.GetWorkerById(ctx, id)
+	endSpan(span, err)
+	return w, err
+}
+
+func (t *TracedDb) ListWorkers(ctx context.Context) ([]model.RaindropWorker, error) {
+	ctx, span := t.start(ctx, "ListWorkers")
+	workers, err := t.d.ListWorkers(ctx)
+	endSpan(span, err)
+	return workers, err
+}
+
+func (t *TracedDb) DisableWorker(ctx context.Context, id int64, version int64) error {
+	ctx, span := t.start(ctx, "DisableWorker", AttrWorkerId.Int64(id), AttrVersion.Int64(version))
+	err := t.d.DisableWorker(ctx, id, version)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) EnableWorker(ctx context.Context, id int64, version int64) error {
+	ctx, span := t.start(ctx, "EnableWorker", AttrWorkerId.Int64(id), AttrVersion.Int64(version))
+	err := t.d.EnableWorker(ctx, id, version)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) ReleaseWorker(ctx context.Context, id int64, version int64) error {
+	ctx, span := t.start(ctx, "ReleaseWorker", AttrWorkerId.Int64(id), AttrVersion.Int64(version))
+	err := t.d.ReleaseWorker(ctx, id, version)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) DeleteWorker(ctx context.Context, id int64, version int64) error {
+	ctx, span := t.start(ctx, "DeleteWorker", AttrWorkerId.Int64(id), AttrVersion.Int64(version))
+	err := t.d.DeleteWorker(ctx, id, version)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) AddWorkerHistory(ctx context.Context, history *model.WorkerHistory) error {
+	ctx, span := t.start(ctx, "AddWorkerHistory", AttrWorkerId.Int64(history.WorkerId), AttrVersion.Int64(history.Version))
+	err := t.d.AddWorkerHistory(ctx, history)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) QueryWorkerHistory(ctx context.Context, datacenterId int64, workerId int64) ([]model.WorkerHistory, error) {
+	ctx, span := t.start(ctx, "QueryWorkerHistory", AttrWorkerId.Int64(workerId))
+	histories, err := t.d.QueryWorkerHistory(ctx, datacenterId, workerId)
+	endSpan(span, err)
+	return histories, err
+}
+
+func (t *TracedDb) AddClockIncident(ctx context.Context, incident *model.ClockIncident) error {
+	ctx, span := t.start(ctx, "AddClockIncident", AttrWorkerId.Int64(incident.WorkerId), AttrIncident.String(incident.Kind))
+	err := t.d.AddClockIncident(ctx, incident)
+	endSpan(span, err)
+	return err
+}
+
+func (t *TracedDb) QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error) {
+	ctx, span := t.start(ctx, "QueryClockIncidents")
+	incidents, err := t.d.QueryClockIncidents(ctx, begin, end)
+	endSpan(span, err)
+	return incidents, err
+}
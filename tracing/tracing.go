@@ -0,0 +1,158 @@
+// Package tracing 雨滴的 OpenTelemetry 链路追踪。通过 db.SetInterceptor 为每次数据库调用创建 span，
+// 通过 config.Observer 在生成id等待下一时间戳流水时创建 span，时间回拨位取反及序列号耗尽返回错误时在调用方的 span 上记录事件。
+// 未启用时不包装数据库、不设置观察者，没有额外开销
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ScopeName tracer 的名称
+	ScopeName = "github.com/treeyh/raindrop"
+
+	// SpanSeqWait 序列号耗尽后等待下一时间戳流水的 span
+	SpanSeqWait = "raindrop.NewId.wait"
+
+	// EventTimeBackFlip 时钟回拨导致时间回拨位取反的事件
+	EventTimeBackFlip = "raindrop.time_back_flip"
+
+	// EventSeqExhausted 序列号耗尽且不等待直接返回错误的事件
+	EventSeqExhausted = "raindrop.seq_exhausted"
+)
+
+// span 的属性
+const (
+	AttrTable        = attribute.Key("raindrop.table")
+	AttrService      = attribute.Key("raindrop.service")
+	AttrIdSpace      = attribute.Key("raindrop.id_space")
+	AttrDatacenterId = attribute.Key("raindrop.datacenter_id")
+	AttrWorkerId     = attribute.Key("raindrop.worker_id")
+	AttrMinWorkerId  = attribute.Key("raindrop.min_worker_id")
+	AttrMaxWorkerId  = attribute.Key("raindrop.max_worker_id")
+	AttrVersion      = attribute.Key("raindrop.version")
+	AttrCode         = attribute.Key("raindrop.code")
+	AttrLayout       = attribute.Key("raindrop.layout")
+	AttrFreeWorkers  = attribute.Key("raindrop.free_workers")
+	AttrIncident     = attribute.Key("raindrop.incident")
+	AttrIdCode       = attribute.Key("raindrop.id_code")
+	AttrTimeSeq      = attribute.Key("raindrop.time_seq")
+	AttrLastTimeSeq  = attribute.Key("raindrop.last_time_seq")
+	AttrJump         = attribute.Key("raindrop.jump")
+	AttrTimeBackBit  = attribute.Key("raindrop.time_back_bit")
+)
+
+// Tracing 链路追踪，实现 config.Observer，事件同时转发给 next，next 为空时不转发
+type Tracing struct {
+	next   config.Observer
+	tracer trace.Tracer
+}
+
+// New 创建链路追踪，tp 为空时使用 otel.GetTracerProvider()，next 为原有的观察者，可为空
+func New(tp trace.TracerProvider, next config.Observer) *Tracing {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	if next == nil {
+		next = config.NopObserver{}
+	}
+	return &Tracing{
+		next:   next,
+		tracer: tp.Tracer(ScopeName),
+	}
+}
+
+// Enable 启用链路追踪：设置数据库拦截器并返回需设置为 RainDropConfig.Observer 的观察者，需在 Init 前调用
+func Enable(tp trace.TracerProvider, next config.Observer) *Tracing {
+	t := New(tp, next)
+	db.SetInterceptor(t.WrapDb)
+	return t
+}
+
+// WrapDb 包装IDb，为每次调用创建 span
+func (t *Tracing) WrapDb(d db.IDb) db.IDb {
+	traced := &TracedDb{
+		d:      d,
+		tracer: t.tracer,
+	}
+	traced.attrs.Store(&[]attribute.KeyValue{})
+	return traced
+}
+
+func (t *Tracing) WorkerAcquired(ctx context.Context, worker model.RaindropWorker, event string) {
+	t.next.WorkerAcquired(ctx, worker, event)
+}
+
+func (t *Tracing) WorkerReleased(ctx context.Context, worker model.RaindropWorker) {
+	t.next.WorkerReleased(ctx, worker)
+}
+
+func (t *Tracing) WorkerLost(ctx context.Context, worker model.RaindropWorker, err error) {
+	t.next.WorkerLost(ctx, worker, err)
+}
+
+func (t *Tracing) HeartbeatFailed(ctx context.Context, worker model.RaindropWorker, err error) {
+	t.next.HeartbeatFailed(ctx, worker, err)
+}
+
+func (t *Tracing) HeartbeatCompleted(ctx context.Context, worker model.RaindropWorker, latency time.Duration, err error) {
+	t.next.HeartbeatCompleted(ctx, worker, latency, err)
+}
+
+func (t *Tracing) IdGenerated(ctx context.Context, idMode string, idCode string, latency time.Duration, err error) {
+	t.next.IdGenerated(ctx, idMode, idCode, latency, err)
+}
+
+// ClockBackwards 在调用方的 span 上记录时间回拨位取反的事件
+func (t *Tracing) ClockBackwards(ctx context.Context, incident model.ClockIncident) {
+	trace.SpanFromContext(ctx).AddEvent(EventTimeBackFlip, trace.WithAttributes(
+		AttrIdCode.String(incident.IdCode),
+		AttrWorkerId.Int64(incident.WorkerId),
+		AttrLastTimeSeq.Int64(incident.LastTimeSeq),
+		AttrTimeSeq.Int64(incident.TimeSeq),
+		AttrJump.String(incident.Jump.String()),
+		AttrTimeBackBit.Int64(incident.TimeBackAfter),
+	))
+	t.next.ClockBackwards(ctx, incident)
+}
+
+func (t *Tracing) DriftExceeded(ctx context.Context, incident model.ClockIncident) {
+	t.next.DriftExceeded(ctx, incident)
+}
+
+// SeqExhausted 等待下一时间戳流水时补记等待期间的 span，不等待直接返回错误时在调用方的 span 上记录事件
+func (t *Tracing) SeqExhausted(ctx context.Context, idCode string, timeSeq int64, wait time.Duration) {
+	attrs := []attribute.KeyValue{AttrIdCode.String(idCode), AttrTimeSeq.Int64(timeSeq)}
+	if wait > 0 {
+		now := time.Now()
+		_, span := t.tracer.Start(ctx, SpanSeqWait, trace.WithTimestamp(now.Add(-wait)), trace.WithAttributes(attrs...))
+		span.End(trace.WithTimestamp(now))
+	} else {
+		trace.SpanFromContext(ctx).AddEvent(EventSeqExhausted, trace.WithAttributes(attrs...))
+	}
+	t.next.SeqExhausted(ctx, idCode, timeSeq, wait)
+}
+
+func (t *Tracing) TimestampExpiring(ctx context.Context, overflowTime time.Time, remaining time.Duration) {
+	t.next.TimestampExpiring(ctx, overflowTime, remaining)
+}
+
+func (t *Tracing) DbRetry(ctx context.Context, op string, attempt int, wait time.Duration, err error) {
+	t.next.DbRetry(ctx, op, attempt, wait, err)
+}
+
+func (t *Tracing) DbRetryFailed(ctx context.Context, op string, attempts int, err error) {
+	t.next.DbRetryFailed(ctx, op, attempts, err)
+}
+
+func (t *Tracing) SchemaMigrated(ctx context.Context, table string, version int, description string, err error) {
+	t.next.SchemaMigrated(ctx, table, version, description, err)
+}
@@ -0,0 +1,17 @@
+package utils
+
+import "time"
+
+// Clock 时钟，用于获取当前时间，便于测试时替换
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock 系统时钟
+type SystemClock struct {
+}
+
+// Now 获取系统当前时间
+func (c SystemClock) Now() time.Time {
+	return time.Now()
+}
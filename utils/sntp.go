@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// ntpPacketSize SNTP 报文长度
+	ntpPacketSize = 48
+	// ntpEpochOffset NTP 时间（1900 年起）与 Unix 时间（1970 年起）相差的秒数
+	ntpEpochOffset = 2208988800
+	// ntpDefaultPort NTP 服务默认端口
+	ntpDefaultPort = "123"
+)
+
+// NtpResponse SNTP 查询结果
+type NtpResponse struct {
+	// Offset 服务器时间减去本地时间的偏差，已按往返时间补偿
+	Offset time.Duration
+
+	// Delay 往返时间，不含服务器处理时间
+	Delay time.Duration
+
+	// Stratum 服务器的层级
+	Stratum int
+
+	// RootDelay 服务器到参考时钟的往返时间
+	RootDelay time.Duration
+
+	// RootDispersion 服务器相对参考时钟的最大误差
+	RootDispersion time.Duration
+}
+
+// Distance 同步距离，本次查询偏差的误差上限
+func (r NtpResponse) Distance() time.Duration {
+	return r.RootDispersion + (r.RootDelay+r.Delay)/2
+}
+
+// QueryNtp 按 SNTP（RFC 4330）查询服务器 server（host 或 host:port，默认端口 123）的时间，本地时间取自 clock
+func QueryNtp(ctx context.Context, server string, timeout time.Duration, clock Clock) (*NtpResponse, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, ntpDefaultPort)
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	// LI = 0，VN = 4，Mode = 3（客户端），发送时间作为服务器回传的 originate 时间
+	request := make([]byte, ntpPacketSize)
+	request[0] = 0<<6 | 4<<3 | 3
+	t1 := clock.Now()
+	binary.BigEndian.PutUint64(request[40:], toNtpTime(t1))
+	if _, err = conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, ntpPacketSize)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+	t4 := clock.Now()
+	if n < ntpPacketSize {
+		return nil, fmt.Errorf("ntp response too short: %d bytes", n)
+	}
+	if response[0]>>6 == 3 {
+		return nil, errors.New("ntp server clock is not synchronized")
+	}
+	if mode := response[0] & 0x7; mode != 4 && mode != 5 {
+		return nil, fmt.Errorf("ntp response mode %d is not server", mode)
+	}
+	if stratum := response[1]; stratum == 0 || stratum > 15 {
+		return nil, fmt.Errorf("ntp server rejected request, stratum %d", stratum)
+	}
+	if binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]) {
+		return nil, errors.New("ntp response does not match the request")
+	}
+
+	t2 := fromNtpTime(binary.BigEndian.Uint64(response[32:]))
+	t3 := fromNtpTime(binary.BigEndian.Uint64(response[40:]))
+	return &NtpResponse{
+		Offset:         (t2.Sub(t1) + t3.Sub(t4)) / 2,
+		Delay:          t4.Sub(t1) - t3.Sub(t2),
+		Stratum:        int(response[1]),
+		RootDelay:      fromNtpShort(binary.BigEndian.Uint32(response[4:])),
+		RootDispersion: fromNtpShort(binary.BigEndian.Uint32(response[8:])),
+	}, nil
+}
+
+// toNtpTime 转换为 NTP 64 位时间：高 32 位为 1900 年起的秒数，低 32 位为秒的小数部分
+func toNtpTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// fromNtpTime 将 NTP 64 位时间转换为时间
+func fromNtpTime(v uint64) time.Time {
+	seconds := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
+
+// fromNtpShort 将 NTP 32 位时长（高 16 位为秒，低 16 位为秒的小数部分）转换为时长
+func fromNtpShort(v uint32) time.Duration {
+	return time.Duration(uint64(v) * uint64(time.Second) >> 16)
+}
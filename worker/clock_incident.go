@@ -0,0 +1,144 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/model"
+)
+
+var (
+	// incidentLock 时钟异常记录的锁
+	incidentLock sync.Mutex
+	// incidentRing 最近的时钟异常，环形缓冲
+	incidentRing [consts.ClockIncidentRingSize]model.ClockIncident
+	// incidentNext 下一条时钟异常在环形缓冲中的位置
+	incidentNext int
+	// incidentTotal 记录过的时钟异常数量
+	incidentTotal int64
+	// incidentMemoryOnly 启动时尚未连接数据库，时钟异常仅保留在内存中
+	incidentMemoryOnly atomic.Bool
+
+	// seqExhaustedTime 上次记录序列号耗尽的时间
+	seqExhaustedTime time.Time
+	// seqExhaustedCount 上次记录后序列号耗尽的次数
+	seqExhaustedCount int64
+)
+
+// ClockIncidents 获取当前进程最近的时钟异常，最多 consts.ClockIncidentRingSize 条，按记录顺序排序。
+// 服务命名空间在写入数据库时填充，返回的记录中为空
+func ClockIncidents(ctx context.Context) []model.ClockIncident {
+	incidentLock.Lock()
+	defer incidentLock.Unlock()
+
+	size := int64(consts.ClockIncidentRingSize)
+	if incidentTotal < size {
+		size = incidentTotal
+	}
+	incidents := make([]model.ClockIncident, 0, size)
+	for i := int(size); i > 0; i-- {
+		incidents = append(incidents, incidentRing[(incidentNext-i+consts.ClockIncidentRingSize)%consts.ClockIncidentRingSize])
+	}
+	return incidents
+}
+
+// QueryClockIncidents 查询当前服务命名空间全部节点在 [begin, end) 内记录的时钟异常，按发生时间排序
+func QueryClockIncidents(ctx context.Context, begin time.Time, end time.Time) ([]model.ClockIncident, error) {
+	return db.Db.QueryClockIncidents(ctx, begin, end)
+}
+
+// recordClockBackwards 记录时钟回拨，lastTimeSeq、timestamp 为回拨前后的时间流水
+func recordClockBackwards(ctx context.Context, idCode string, lastTimeSeq int64, timestamp int64, timeBackBefore int64, timeBackAfter int64) {
+	incident := recordClockIncident(ctx, model.ClockIncident{
+		Kind:           consts.ClockIncidentBackwards,
+		IdCode:         idCode,
+		LastTimeSeq:    lastTimeSeq - startTime,
+		TimeSeq:        timestamp - startTime,
+		Jump:           timeSeqToTime(lastTimeSeq, timeUnit).Sub(timeSeqToTime(timestamp, timeUnit)),
+		TimeBackBefore: timeBackBefore,
+		TimeBackAfter:  timeBackAfter,
+	})
+	notify(ctx, "ClockBackwards", func(o config.Observer) {
+		o.ClockBackwards(ctx, incident)
+	})
+}
+
+// recordSeqExhausted 记录序列号耗尽，consts.ClockIncidentBurstInterval 内只记录一次，期间的次数合并到下一条记录
+func recordSeqExhausted(ctx context.Context, idCode string, timestamp int64) {
+	incidentLock.Lock()
+	seqExhaustedCount++
+	now := clock.Now()
+	if !seqExhaustedTime.IsZero() && now.Sub(seqExhaustedTime) < consts.ClockIncidentBurstInterval {
+		incidentLock.Unlock()
+		return
+	}
+	count := seqExhaustedCount
+	seqExhaustedTime = now
+	seqExhaustedCount = 0
+	incidentLock.Unlock()
+
+	recordClockIncident(ctx, model.ClockIncident{
+		Kind:        consts.ClockIncidentSeqExhausted,
+		IdCode:      idCode,
+		LastTimeSeq: timestamp - startTime,
+		TimeSeq:     timestamp - startTime,
+		Count:       count,
+	})
+}
+
+// recordDrift 记录服务器与DB或NTP服务器时间偏差过大，drift 为DB或NTP服务器时间减去服务器时间
+func recordDrift(ctx context.Context, kind string, drift time.Duration) {
+	incident := recordClockIncident(ctx, model.ClockIncident{
+		Kind:  kind,
+		Drift: drift,
+	})
+	notify(ctx, "DriftExceeded", func(o config.Observer) {
+		o.DriftExceeded(ctx, incident)
+	})
+}
+
+// recordClockIncident 将时钟异常写入环形缓冲，并异步写入数据库，避免阻塞id生成，返回补全后的时钟异常。写入数据库失败仅输出错误日志
+func recordClockIncident(ctx context.Context, incident model.ClockIncident) model.ClockIncident {
+	incident.DatacenterId = datacenterId
+	incident.WorkerId = workerId
+	incident.Code = workerCode
+	incident.EventTime = clock.Now()
+	if incident.Count == 0 {
+		incident.Count = 1
+	}
+
+	incidentLock.Lock()
+	incidentRing[incidentNext] = incident
+	incidentNext = (incidentNext + 1) % consts.ClockIncidentRingSize
+	incidentTotal++
+	incidentLock.Unlock()
+
+	if degraded.Load() || incidentMemoryOnly.Load() {
+		// 降级运行时或启动时尚未连接数据库，仅保留在内存中
+		return incident
+	}
+	// 启动协程前读取全局变量，重新初始化时替换的数据库及日志不影响已启动的写入
+	written, d, l := incident, db.Db, limitedLog
+	go func() {
+		err := d.AddClockIncident(context.WithoutCancel(ctx), &written)
+		if err != nil {
+			l.Error(ctx, "record clock incident fail", slog.String("kind", written.Kind), err)
+		}
+	}()
+	return incident
+}
+
+// resetSeqExhausted 重置序列号耗尽的合并状态
+func resetSeqExhausted() {
+	incidentLock.Lock()
+	defer incidentLock.Unlock()
+
+	seqExhaustedTime = time.Time{}
+	seqExhaustedCount = 0
+}
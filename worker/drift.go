@@ -0,0 +1,160 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/model"
+)
+
+var (
+	// driftLock 与DB时间偏差估算状态的锁
+	driftLock sync.Mutex
+	// drift 与DB时间偏差的估算状态
+	drift model.DriftStatus
+	// driftStopped 因偏差超过阈值停止生成id
+	driftStopped atomic.Bool
+	// driftReleased 因偏差超过阈值已释放worker，偏差恢复后也不再生成id
+	driftReleased atomic.Bool
+
+	// ntp 与NTP服务器时间偏差的估算状态
+	ntp model.NtpStatus
+	// ntpServers NTP服务器
+	ntpServers []string
+	// ntpTimeout 查询NTP服务器的超时时间
+	ntpTimeout time.Duration
+)
+
+// GetDriftStatus 获取服务器与DB时间偏差的估算状态
+func GetDriftStatus(ctx context.Context) model.DriftStatus {
+	driftLock.Lock()
+	defer driftLock.Unlock()
+
+	status := drift
+	status.Stopped = driftStopped.Load()
+	return status
+}
+
+// initDrift 初始化偏差估算状态
+func initDrift(conf config.RainDropConfig) {
+	driftLock.Lock()
+	defer driftLock.Unlock()
+
+	drift = model.DriftStatus{Threshold: conf.DriftThreshold, Policy: conf.DriftPolicy}
+	ntp = model.NtpStatus{Servers: make([]model.NtpSample, 0)}
+	ntpServers = conf.NtpServers
+	ntpTimeout = conf.NtpTimeout
+	driftReleased.Store(false)
+	driftStopped.Store(false)
+}
+
+// checkDrift 查询DB时间，按往返时间的一半补偿后更新平滑的偏差，并在偏差超过阈值时执行处理策略。
+// 配置了NTP服务器时以NTP服务器替代DB时间，不查询
+func checkDrift(ctx context.Context) error {
+	if len(ntpServers) > 0 {
+		return nil
+	}
+	begin := clock.Now()
+	dbNow, err := db.Db.GetNowTime(ctx)
+	if err != nil {
+		log.Error(ctx, "sample database time fail", err)
+		return err
+	}
+	end := clock.Now()
+	roundTrip := end.Sub(begin)
+	sample := dbNow.Sub(begin.Add(roundTrip / 2))
+
+	driftLock.Lock()
+	if drift.Samples == 0 {
+		drift.Offset = sample
+	} else {
+		drift.Offset += time.Duration(consts.DriftSmoothingFactor * float64(sample-drift.Offset))
+	}
+	drift.LastSample = sample
+	drift.RoundTrip = roundTrip
+	drift.SampleTime = end
+	drift.Samples++
+	offset := drift.Offset
+	exceeded := offset > drift.Threshold || offset < -drift.Threshold
+	wasExceeded := drift.Exceeded
+	drift.Exceeded = exceeded
+	policy := drift.Policy
+	driftLock.Unlock()
+
+	if !exceeded {
+		if wasExceeded {
+			log.Info(ctx, "clock drift recovered", slog.Duration("offset", offset))
+		}
+		return applyDriftPolicy(ctx)
+	}
+
+	log.Error(ctx, consts.ErrMsgDatabaseServerTimeInterval.Error(), slog.Duration("offset", offset), slog.Duration("sample", sample),
+		slog.Duration("round_trip", roundTrip), slog.String("policy", policy), consts.ErrMsgDatabaseServerTimeInterval)
+	if !wasExceeded {
+		recordDrift(ctx, consts.ClockIncidentDbDrift, offset)
+	}
+	return applyDriftPolicy(ctx)
+}
+
+// applyDriftPolicy 按处理策略更新是否停止生成id，与DB或NTP服务器的偏差任一超过阈值即执行策略
+func applyDriftPolicy(ctx context.Context) error {
+	driftLock.Lock()
+	exceeded := drift.Exceeded || ntp.Exceeded
+	policy := drift.Policy
+	driftLock.Unlock()
+
+	if !exceeded {
+		if !driftReleased.Load() {
+			driftStopped.Store(false)
+		}
+		return nil
+	}
+	switch policy {
+	case consts.DriftPolicyPause:
+		driftStopped.Store(true)
+	case consts.DriftPolicyRelease:
+		driftStopped.Store(true)
+		if !driftReleased.Load() {
+			return releaseForDrift(ctx)
+		}
+	}
+	return nil
+}
+
+// releaseForDrift 偏差超过阈值时释放当前worker，供其他时钟正常的节点分配
+func releaseForDrift(ctx context.Context) error {
+	current := worker.Load()
+	err := db.Db.ReleaseWorker(ctx, current.Id, current.Version)
+	if err != nil {
+		log.Error(ctx, "release worker for clock drift fail", logger.WorkerId(current.Id), err)
+		return err
+	}
+	driftReleased.Store(true)
+	removeLeaseCache(ctx)
+	log.Info(ctx, "release worker for clock drift over", logger.WorkerId(current.Id))
+	released := *current
+	notify(ctx, "WorkerReleased", func(o config.Observer) {
+		o.WorkerReleased(ctx, released)
+	})
+
+	now := clock.Now()
+	err = db.Db.AddWorkerHistory(ctx, &model.WorkerHistory{
+		WorkerId:     current.Id,
+		Version:      current.Version + 1,
+		Event:        consts.WorkerEventRelease,
+		PreviousCode: workerCode,
+		Layout:       layout,
+		EventTime:    now,
+	})
+	if err != nil {
+		log.Error(ctx, "record worker history fail", logger.WorkerId(current.Id), slog.String("event", consts.WorkerEventRelease), err)
+	}
+	return nil
+}
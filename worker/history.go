@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/model"
+)
+
+// recordActivation 记录worker激活历史，previous 为激活前的worker。记录失败不影响激活，仅输出错误日志
+func recordActivation(ctx context.Context, conf config.RainDropConfig, previous model.RaindropWorker, activated *model.RaindropWorker) {
+	event := consts.WorkerEventActivate
+	if previous.Code != "" && previous.Code != activated.Code {
+		event = consts.WorkerEventTakeover
+	}
+	now := clock.Now()
+	err := db.Db.AddWorkerHistory(ctx, &model.WorkerHistory{
+		WorkerId:     activated.Id,
+		Version:      activated.Version,
+		Event:        event,
+		Code:         activated.Code,
+		PreviousCode: previous.Code,
+		Layout:       activated.Layout,
+		TimeSeq:      timeSeqAt(conf, now),
+		EventTime:    now,
+	})
+	if err != nil {
+		log.Error(ctx, "record worker history fail", logger.WorkerId(activated.Id), slog.String("event", event), err)
+	}
+	notify(ctx, "WorkerAcquired", func(o config.Observer) {
+		o.WorkerAcquired(ctx, *activated, event)
+	})
+}
+
+// LookupOrigin 按当前的id结构解析id，根据worker分配历史找到生成该id时持有worker的节点。
+// 仅匹配当前 id 结构下的激活及接管记录，没有匹配的记录时返回 consts.ErrMsgIdOriginNotFound
+func LookupOrigin(ctx context.Context, id int64) (*model.IdOrigin, error) {
+	info := Parse(id)
+	histories, err := db.Db.QueryWorkerHistory(ctx, info.DatacenterId, info.WorkerId)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := -1
+	for i, h := range histories {
+		if (h.Event == consts.WorkerEventActivate || h.Event == consts.WorkerEventTakeover) && h.Layout == layout && h.TimeSeq <= info.TimeSeq {
+			owner = i
+		}
+	}
+	if owner < 0 {
+		return nil, fmt.Errorf("%w. id: %d, datacenterId: %d, workerId: %d", consts.ErrMsgIdOriginNotFound, id, info.DatacenterId, info.WorkerId)
+	}
+
+	origin := &model.IdOrigin{
+		Info:       info,
+		Owner:      histories[owner],
+		EndTimeSeq: -1,
+		InRange:    true,
+	}
+	// 下一条记录发生时节点已失去worker
+	if owner+1 < len(histories) {
+		origin.EndTimeSeq = calcTimestamp(ctx, histories[owner+1].EventTime.UnixMilli(), timeUnit) - startTime
+		origin.InRange = info.TimeSeq <= origin.EndTimeSeq
+	}
+	return origin, nil
+}
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+)
+
+// LayoutFingerprint 根据 id 结构计算指纹：时间单位、起始时间及各部分位长度。
+// 数据中心 id、预留位的值等不影响 id 结构的配置不参与计算
+func LayoutFingerprint(conf config.RainDropConfig) string {
+	s := fmt.Sprintf("%s|%d|%d|%d|%d|%d|%d|%d", consts.IdModeSnowflake, int(conf.TimeUnit),
+		calcTimestamp(context.Background(), conf.StartTimeStamp.UnixMilli(), conf.TimeUnit), conf.TimeStampLength,
+		conf.DatacenterIdLength, conf.WorkIdLength, consts.TimeBackBitLength, conf.EndBitsLength)
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// MinIdAt 按 conf 的 id 结构在 t 时刻可生成的最小 id，t 之后生成的 id 均不小于该值
+func MinIdAt(conf config.RainDropConfig, t time.Time) int64 {
+	return timeSeqAt(conf, t) << (consts.IdBitLength - conf.TimeStampLength)
+}
+
+// MaxIdAt 按 conf 的 id 结构在 t 时刻可生成的最大 id，t 之前生成的 id 均不大于该值
+func MaxIdAt(conf config.RainDropConfig, t time.Time) int64 {
+	if timeSeqAt(conf, t) >= 1<<conf.TimeStampLength-1 {
+		return math.MaxInt64
+	}
+	return (timeSeqAt(conf, t)+1)<<(consts.IdBitLength-conf.TimeStampLength) - 1
+}
+
+// OverflowTime conf 的 id 结构时间戳位耗尽的时间，之后无法再生成 id
+func OverflowTime(conf config.RainDropConfig) time.Time {
+	start := calcTimestamp(context.Background(), conf.StartTimeStamp.UnixMilli(), conf.TimeUnit)
+	return timeSeqToTime(start+(1<<conf.TimeStampLength), conf.TimeUnit)
+}
+
+// PlanLayoutChange 计算从 oldConf 切换到 newConf 的 id 结构变更计划。maxIssuedId 为已生成的最大 id，
+// 旧结构的服务运行到切换时刻为止，切换时刻新结构的最小 id 需大于已生成的 id 及旧结构可生成的最大 id
+func PlanLayoutChange(oldConf config.RainDropConfig, newConf config.RainDropConfig, maxIssuedId int64, now time.Time) model.LayoutPlan {
+	plan := model.LayoutPlan{
+		MaxIssuedId:  maxIssuedId,
+		OldMaxIdNow:  MaxIdAt(oldConf, now),
+		NewMinIdNow:  MinIdAt(newConf, now),
+		OverflowTime: OverflowTime(newConf),
+		Warnings:     make([]string, 0),
+	}
+	safeAt := func(t time.Time) bool {
+		bound := MaxIdAt(oldConf, t)
+		if maxIssuedId > bound {
+			bound = maxIssuedId
+		}
+		return MinIdAt(newConf, t) > bound
+	}
+
+	plan.Safe = safeAt(now)
+	newStart := calcTimestamp(context.Background(), newConf.StartTimeStamp.UnixMilli(), newConf.TimeUnit)
+	unitTime := func(timeSeq int64) time.Time {
+		return timeSeqToTime(newStart+timeSeq, newConf.TimeUnit)
+	}
+	if plan.Safe {
+		plan.CutoverTime = now
+	} else if lo, hi := timeSeqAt(newConf, now), int64(1)<<newConf.TimeStampLength-1; lo < hi && safeAt(unitTime(hi)) {
+		// 新结构的 id 增长快于旧结构时，随时间推移变为安全，按新结构的时间单位二分查找最早的安全时间
+		for hi-lo > 1 {
+			mid := lo + (hi-lo)/2
+			if safeAt(unitTime(mid)) {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		plan.CutoverTime = unitTime(hi)
+	}
+
+	// 立即切换时，新结构当前的时间戳至少需要达到 needTimeSeq
+	shift := consts.IdBitLength - newConf.TimeStampLength
+	bound := plan.OldMaxIdNow
+	if maxIssuedId > bound {
+		bound = maxIssuedId
+	}
+	needTimeSeq := bound>>shift + 1
+	plan.SuggestedStartTimeStamp = newConf.StartTimeStamp
+	if diff := needTimeSeq - timeSeqAt(newConf, now); diff > 0 {
+		plan.EpochAdjustment = diff
+		if needTimeSeq >= 1<<newConf.TimeStampLength {
+			plan.SuggestedStartTimeStamp = time.Time{}
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("new layout cannot generate ids greater than %d by adjusting StartTimeStamp, TimeStampLength is too short", bound))
+		} else {
+			plan.SuggestedStartTimeStamp = timeSeqToTime(newStart-diff, newConf.TimeUnit)
+			adjusted := newConf
+			adjusted.StartTimeStamp = plan.SuggestedStartTimeStamp
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("moving StartTimeStamp to %s overflows at %s",
+				plan.SuggestedStartTimeStamp.Format(time.RFC3339), OverflowTime(adjusted).Format(time.RFC3339)))
+		}
+	}
+
+	if plan.CutoverTime.IsZero() {
+		plan.Warnings = append(plan.Warnings, "new layout never generates ids greater than the old layout before it overflows, adjust StartTimeStamp or stop the old layout first")
+	}
+	if !plan.OverflowTime.After(now) {
+		plan.Warnings = append(plan.Warnings, "new layout has already overflowed at "+plan.OverflowTime.Format(time.RFC3339))
+	} else if plan.OverflowTime.Before(now.Add(consts.LayoutOverflowWarnTime)) {
+		plan.Warnings = append(plan.Warnings, "new layout overflows at "+plan.OverflowTime.Format(time.RFC3339))
+	}
+	return plan
+}
+
+// timeSeqAt conf 的 id 结构在 t 时刻的时间戳位的值
+func timeSeqAt(conf config.RainDropConfig, t time.Time) int64 {
+	return calcTimestamp(context.Background(), t.UnixMilli(), conf.TimeUnit) - calcTimestamp(context.Background(), conf.StartTimeStamp.UnixMilli(), conf.TimeUnit)
+}
+
+// layoutQuarantined worker 最近被其他 id 结构使用，隔离期内不能激活，避免新旧结构生成重复的 id
+func layoutQuarantined(w model.RaindropWorker, layout string, now time.Time, quarantine time.Duration) bool {
+	return w.Layout != "" && w.Layout != layout && w.HeartbeatTime.After(now.Add(-quarantine))
+}
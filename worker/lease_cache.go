@@ -0,0 +1,276 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/db"
+	"github.com/treeyh/raindrop/logger"
+	"github.com/treeyh/raindrop/model"
+)
+
+var (
+	// leaseCacheFile 本地租约缓存文件，为空时不缓存
+	leaseCacheFile string
+	// leaseCacheService 服务命名空间
+	leaseCacheService string
+	// leaseCacheTableName 数据库表名
+	leaseCacheTableName string
+	// leaseMargin 计算租约到期时间时扣除的时钟偏差余量
+	leaseMargin time.Duration
+
+	// degraded 基于本地租约缓存降级运行，尚未通过数据库确认租约
+	degraded atomic.Bool
+	// leaseExpireTime 降级运行时租约的到期时间，毫秒
+	leaseExpireTime atomic.Int64
+	// leaseLost 心跳或数据库恢复后确认租约失败，worker已被停用、释放或被其他节点激活，不再生成id及心跳
+	leaseLost atomic.Bool
+	// issuedTimeSeq 当前租约已生成id的最大时间流水，时钟回拨后仍保留回拨前的值
+	issuedTimeSeq atomic.Int64
+)
+
+// initLeaseCache 初始化租约缓存配置及降级状态
+func initLeaseCache(conf config.RainDropConfig) {
+	leaseCacheFile = conf.LeaseCacheFile
+	leaseCacheService = conf.DbConfig.Service
+	leaseCacheTableName = conf.DbConfig.TableName
+	leaseMargin = conf.DriftThreshold
+	degraded.Store(false)
+	leaseExpireTime.Store(0)
+	leaseLost.Store(false)
+	issuedTimeSeq.Store(0)
+}
+
+// recordIssuedTimeSeq 生成id后更新已生成id的最大时间流水
+func recordIssuedTimeSeq(timestamp int64) {
+	for {
+		issued := issuedTimeSeq.Load()
+		if timestamp <= issued || issuedTimeSeq.CompareAndSwap(issued, timestamp) {
+			return
+		}
+	}
+}
+
+// IsDegraded 是否基于本地租约缓存降级运行
+func IsDegraded(ctx context.Context) bool {
+	return degraded.Load()
+}
+
+// GetLeaseExpireTime 降级运行时缓存的租约到期时间，未降级时为零值
+func GetLeaseExpireTime(ctx context.Context) time.Time {
+	if !degraded.Load() {
+		return time.Time{}
+	}
+	return time.UnixMilli(leaseExpireTime.Load())
+}
+
+// checkIssuable 校验当前能否生成id：时间偏差超过阈值、租约丢失或降级运行时租约已到期均停止生成
+func checkIssuable() error {
+	if driftStopped.Load() {
+		return consts.ErrMsgClockDriftExceeded
+	}
+	if leaseLost.Load() {
+		return consts.ErrMsgWorkerLeaseLost
+	}
+	if degraded.Load() && clock.Now().UnixMilli() >= leaseExpireTime.Load() {
+		return consts.ErrMsgLeaseExpired
+	}
+	return nil
+}
+
+// leaseExpireAt 心跳时间为 heartbeatTime 的租约到期时间。其他节点按 FreeHeartbeatTime 判断worker空闲，
+// 按最短的空闲判定时长计算并扣除时钟偏差余量
+func leaseExpireAt(heartbeatTime time.Time) time.Time {
+	return heartbeatTime.Add(time.Duration(consts.HeartbeatTimeInterval*4)*time.Second - leaseMargin)
+}
+
+// saveLeaseCache 写入本地租约缓存，先写临时文件再重命名，避免进程退出时留下不完整的文件。未配置缓存文件时不写入
+func saveLeaseCache(ctx context.Context, expireTime time.Time) {
+	if leaseCacheFile == "" {
+		return
+	}
+	cache := model.LeaseCache{
+		Service:         leaseCacheService,
+		TableName:       leaseCacheTableName,
+		DatacenterId:    datacenterId,
+		Worker:          *worker.Load(),
+		Layout:          layout,
+		LeaseExpireTime: expireTime,
+		LastTimeSeq:     max(nowTimeSeq.Load(), issuedTimeSeq.Load()),
+		TimeBackValue:   timeBackBitValue.Load(),
+		UpdateTime:      clock.Now(),
+	}
+	err := writeLeaseCache(leaseCacheFile, cache)
+	if err != nil {
+		log.Error(ctx, "save lease cache fail", slog.String("file", leaseCacheFile), err)
+	}
+}
+
+// writeLeaseCache 原子地写入租约缓存文件
+func writeLeaseCache(file string, cache model.LeaseCache) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// removeLeaseCache 删除本地租约缓存，worker已释放或租约丢失时调用，避免下次据此降级启动
+func removeLeaseCache(ctx context.Context) {
+	if leaseCacheFile == "" {
+		return
+	}
+	err := os.Remove(leaseCacheFile)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(ctx, "remove lease cache fail", slog.String("file", leaseCacheFile), err)
+	}
+}
+
+// loadLeaseCache 读取本地租约缓存，校验与配置一致且租约未到期
+func loadLeaseCache(conf config.RainDropConfig, now time.Time) (*model.LeaseCache, error) {
+	data, err := os.ReadFile(conf.LeaseCacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w. %s", consts.ErrMsgLeaseCacheInvalid, err.Error())
+	}
+	var cache model.LeaseCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil {
+		return nil, fmt.Errorf("%w. %s", consts.ErrMsgLeaseCacheInvalid, err.Error())
+	}
+
+	var reason string
+	switch {
+	case cache.Service != conf.DbConfig.Service || cache.TableName != conf.DbConfig.TableName:
+		reason = "service or table name mismatch"
+	case cache.DatacenterId != conf.DatacenterId:
+		reason = "datacenter id mismatch"
+	case cache.Layout != LayoutFingerprint(conf):
+		reason = "layout mismatch"
+	case cache.Worker.Id < conf.ServiceMinWorkId || cache.Worker.Id > conf.ServiceMaxWorkId:
+		reason = "worker id out of range"
+	case now.Before(cache.UpdateTime):
+		// 时钟早于缓存的写入时间，可能发生了回拨
+		reason = "clock is before the cache update time " + cache.UpdateTime.String()
+	case !now.Before(cache.LeaseExpireTime):
+		reason = "lease expired at " + cache.LeaseExpireTime.String()
+	case calcTimestamp(context.Background(), now.UnixMilli(), conf.TimeUnit) < cache.LastTimeSeq:
+		reason = "time seq is before the cached time seq " + strconv.FormatInt(cache.LastTimeSeq, 10)
+	}
+	if reason != "" {
+		return nil, fmt.Errorf("%w. %s", consts.ErrMsgLeaseCacheInvalid, reason)
+	}
+	return &cache, nil
+}
+
+// InitDegraded 数据库不可用时基于本地租约缓存初始化worker，租约到期前可生成id，需调用 RecoverLease 通过数据库确认租约。
+// 需先调用 CheckStartupNtp
+func InitDegraded(ctx context.Context, conf config.RainDropConfig) error {
+	initRuntime(conf)
+	initLeaseCache(conf)
+	cache, err := loadLeaseCache(conf, clock.Now())
+	if err != nil {
+		log.Error(ctx, err.Error(), err)
+		return err
+	}
+	degraded.Store(true)
+	leaseExpireTime.Store(cache.LeaseExpireTime.UnixMilli())
+	// 缓存之前生成的id的时间戳位不大于缓存的时间流水，降级运行期间再次重启时同样校验
+	issuedTimeSeq.Store(cache.LastTimeSeq)
+
+	w := cache.Worker
+	worker.Store(&w)
+	layout = cache.Layout
+	workerCode = cache.Worker.Code
+	initParams(ctx, conf)
+	// 缓存写入后到进程退出前生成的id可能与当前的时间戳位相同，时间回拨位取反避免重复
+	timeBackInitValue = cache.TimeBackValue ^ 1
+	timeBackBitValue.Store(timeBackInitValue)
+
+	err = calcNowTimeSeq(ctx)
+	if err != nil {
+		degraded.Store(false)
+		return err
+	}
+	// 记录取反后的时间回拨位，降级运行期间再次重启时再次取反
+	saveLeaseCache(ctx, cache.LeaseExpireTime)
+	log.Info(ctx, "worker degraded start with lease cache", logger.WorkerId(w.Id), logger.Version(w.Version),
+		slog.Time("lease_expire_time", cache.LeaseExpireTime))
+
+	if v := ctx.Value(consts.ProjectName); v != nil {
+		// 支持单元测试，跳过启动时间戳流水线程
+		if consts.SkipHeartbeat == v.(string) {
+			return nil
+		}
+	}
+	go startCalcNowTimeSeq(ctx)
+	return nil
+}
+
+// markLeaseLost 数据库确认租约已丢失：停止生成id及心跳、退出降级并删除本地租约缓存，每个租约只回调一次 WorkerLost
+func markLeaseLost(ctx context.Context, w model.RaindropWorker, err error) {
+	if !leaseLost.CompareAndSwap(false, true) {
+		return
+	}
+	degraded.Store(false)
+	removeLeaseCache(ctx)
+	log.Error(ctx, "worker lease lost, stop generating ids", logger.WorkerId(w.Id), logger.Version(w.Version), err)
+	notify(ctx, "WorkerLost", func(o config.Observer) {
+		o.WorkerLost(ctx, w, err)
+	})
+}
+
+// RecoverLease 降级运行时通过数据库心跳确认缓存的租约，成功后退出降级并启动心跳；
+// 版本号已变化说明worker已被其他节点激活，停止生成id并返回 consts.ErrMsgWorkerLeaseLost。未降级时直接返回
+func RecoverLease(ctx context.Context) error {
+	if !degraded.Load() {
+		return nil
+	}
+	current := worker.Load()
+	w, err := db.Db.HeartbeatWorker(ctx, current)
+	if err != nil {
+		if errors.Is(err, consts.ErrMsgWorkerLeaseLost) {
+			markLeaseLost(ctx, *current, err)
+		}
+		return err
+	}
+	worker.Store(w)
+	degraded.Store(false)
+	recordHeartbeat(nil)
+	saveLeaseCache(ctx, leaseExpireAt(w.HeartbeatTime))
+	log.Info(ctx, "recover lease over", logger.WorkerId(w.Id), logger.Version(w.Version))
+
+	err = checkDrift(ctx)
+	if v := ctx.Value(consts.ProjectName); v != nil {
+		if consts.SkipHeartbeat == v.(string) {
+			return err
+		}
+	}
+	go startHeartbeat(ctx)
+	return err
+}
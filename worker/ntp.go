@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/treeyh/raindrop/consts"
+	"github.com/treeyh/raindrop/model"
+	"github.com/treeyh/raindrop/utils"
+)
+
+// GetNtpStatus 获取服务器与NTP服务器时间偏差的估算状态
+func GetNtpStatus(ctx context.Context) model.NtpStatus {
+	driftLock.Lock()
+	defer driftLock.Unlock()
+
+	status := ntp
+	status.Servers = append([]model.NtpSample(nil), ntp.Servers...)
+	return status
+}
+
+// checkNtp 查询NTP服务器更新偏差，并在偏差超过阈值时执行处理策略。未配置NTP服务器时不查询
+func checkNtp(ctx context.Context) error {
+	if len(ntpServers) == 0 {
+		return nil
+	}
+	_, err := sampleNtp(ctx)
+	if err != nil {
+		return err
+	}
+	return applyDriftPolicy(ctx)
+}
+
+// sampleNtp 并发查询全部NTP服务器，以偏差的中位数作为估算值，返回偏差是否超过阈值。
+// 全部查询失败时返回 consts.ErrMsgNtpUnavailable
+func sampleNtp(ctx context.Context) (bool, error) {
+	samples := queryNtpServers(ctx)
+	offsets := make([]model.NtpSample, 0, len(samples))
+	for _, s := range samples {
+		if s.Error == "" {
+			offsets = append(offsets, s)
+		}
+	}
+
+	driftLock.Lock()
+	ntp.Servers = samples
+	if len(offsets) == 0 {
+		driftLock.Unlock()
+		log.Error(ctx, consts.ErrMsgNtpUnavailable.Error(), slog.Any("servers", samples), consts.ErrMsgNtpUnavailable)
+		return false, consts.ErrMsgNtpUnavailable
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].Offset < offsets[j].Offset
+	})
+	offset := offsets[len(offsets)/2].Offset
+	if len(offsets)%2 == 0 {
+		offset = (offsets[len(offsets)/2-1].Offset + offset) / 2
+	}
+	dispersion := offsets[0].Distance
+	spread := offset - offsets[0].Offset
+	for _, s := range offsets {
+		if s.Distance < dispersion {
+			dispersion = s.Distance
+		}
+		if d := s.Offset - offset; d > spread {
+			spread = d
+		}
+	}
+	ntp.Offset = offset
+	ntp.Dispersion = spread + dispersion
+	ntp.Samples++
+	ntp.SampleTime = clock.Now()
+	exceeded := offset > drift.Threshold || offset < -drift.Threshold
+	wasExceeded := ntp.Exceeded
+	ntp.Exceeded = exceeded
+	policy := drift.Policy
+	driftLock.Unlock()
+
+	if exceeded {
+		log.Error(ctx, consts.ErrMsgNtpOffsetExceeded.Error(), slog.Duration("offset", offset), slog.Duration("dispersion", spread+dispersion),
+			slog.String("policy", policy), consts.ErrMsgNtpOffsetExceeded)
+		if !wasExceeded {
+			recordDrift(ctx, consts.ClockIncidentNtpDrift, offset)
+		}
+	} else if wasExceeded {
+		log.Info(ctx, "ntp clock offset recovered", slog.Duration("offset", offset))
+	}
+	return exceeded, nil
+}
+
+// queryNtpServers 并发查询全部NTP服务器，结果与配置的顺序一致
+func queryNtpServers(ctx context.Context) []model.NtpSample {
+	samples := make([]model.NtpSample, len(ntpServers))
+	var wg sync.WaitGroup
+	for i, server := range ntpServers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			samples[i].Server = server
+			r, err := utils.QueryNtp(ctx, server, ntpTimeout, clock)
+			if err != nil {
+				samples[i].Error = err.Error()
+				return
+			}
+			samples[i].Offset = r.Offset
+			samples[i].Delay = r.Delay
+			samples[i].Stratum = r.Stratum
+			samples[i].Distance = r.Distance()
+		}(i, server)
+	}
+	wg.Wait()
+	return samples
+}
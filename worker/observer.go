@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/consts"
+)
+
+var (
+	// observer 租约、时钟及容量事件的观察者
+	observer config.Observer
+)
+
+// notify 同步回调观察者，回调 panic 时恢复并输出错误日志，未配置观察者时不回调
+func notify(ctx context.Context, event string, f func(o config.Observer)) {
+	o := observer
+	if o == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			limitedLog.Error(ctx, "observer panic", slog.String("event", event), slog.Any("panic", r))
+		}
+	}()
+	f(o)
+}
+
+// checkTimestampExpiring 时间戳位将在 consts.LayoutOverflowWarnTime 内耗尽时回调观察者
+func checkTimestampExpiring(ctx context.Context) {
+	remaining := overflowTime.Sub(clock.Now())
+	if remaining >= consts.LayoutOverflowWarnTime {
+		return
+	}
+	log.Error(ctx, "timestamp bits expiring", slog.Time("overflow_time", overflowTime), slog.Duration("remaining", remaining))
+	notify(ctx, "TimestampExpiring", func(o config.Observer) {
+		o.TimestampExpiring(ctx, overflowTime, remaining)
+	})
+}
+
+// notifySeqExhausted 序列号耗尽时回调观察者，begin 为开始等待的时间，不等待时为零值
+func notifySeqExhausted(ctx context.Context, idCode string, timestamp int64, begin time.Time) {
+	var wait time.Duration
+	if !begin.IsZero() {
+		wait = time.Since(begin)
+	}
+	notify(ctx, "SeqExhausted", func(o config.Observer) {
+		o.SeqExhausted(ctx, idCode, timestamp-startTime, wait)
+	})
+}
+
+// notifyIdGenerated 生成id结束时回调观察者，begin 为开始生成的时间
+func notifyIdGenerated(ctx context.Context, idCode string, begin time.Time, err error) {
+	latency := time.Since(begin)
+	notify(ctx, "IdGenerated", func(o config.Observer) {
+		o.IdGenerated(ctx, idMode, idCode, latency, err)
+	})
+}
@@ -0,0 +1,123 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/treeyh/raindrop/config"
+	"github.com/treeyh/raindrop/model"
+)
+
+var (
+	// issuedIds 初始化后生成的id数量
+	issuedIds atomic.Int64
+	// exhaustionWaits 序列号耗尽后等待下一时间戳流水的次数
+	exhaustionWaits atomic.Int64
+	// exhaustionErrors 序列号耗尽后返回错误的次数
+	exhaustionErrors atomic.Int64
+	// overflowTime 时间戳位耗尽的时间
+	overflowTime time.Time
+
+	// heartbeatLock 最近一次心跳结果的锁
+	heartbeatLock sync.Mutex
+	// lastHeartbeatTime 最近一次心跳的时间
+	lastHeartbeatTime time.Time
+	// lastHeartbeatErr 最近一次心跳的错误
+	lastHeartbeatErr error
+)
+
+// initStatus 重置运行状态
+func initStatus(conf config.RainDropConfig) {
+	issuedIds.Store(0)
+	exhaustionWaits.Store(0)
+	exhaustionErrors.Store(0)
+	overflowTime = OverflowTime(conf)
+
+	heartbeatLock.Lock()
+	defer heartbeatLock.Unlock()
+	lastHeartbeatTime = time.Time{}
+	lastHeartbeatErr = nil
+}
+
+// recordHeartbeat 记录心跳结果
+func recordHeartbeat(err error) {
+	heartbeatLock.Lock()
+	defer heartbeatLock.Unlock()
+	lastHeartbeatTime = clock.Now()
+	lastHeartbeatErr = err
+}
+
+// GetStatus 获取生成器的运行状态及存活、就绪检查结果
+func GetStatus(ctx context.Context) model.Status {
+	w := worker.Load()
+	if w == nil {
+		return model.Status{Live: true, Problems: []string{"not initialized"}}
+	}
+	now := clock.Now()
+	status := model.Status{
+		Initialized:       true,
+		DatacenterId:      datacenterId,
+		WorkerId:          w.Id,
+		Code:              workerCode,
+		Version:           w.Version,
+		HeartbeatTime:     w.HeartbeatTime,
+		LeaseAge:          now.Sub(w.HeartbeatTime),
+		LeaseExpireTime:   leaseExpireAt(w.HeartbeatTime),
+		Degraded:          degraded.Load(),
+		LeaseLost:         leaseLost.Load(),
+		Drift:             GetDriftStatus(ctx),
+		Ntp:               GetNtpStatus(ctx),
+		TimeBackBit:       timeBackBitValue.Load(),
+		TimeSeq:           nowTimeSeq.Load(),
+		SeqCapacity:       maxIdSeq + 1,
+		IssuedIds:         issuedIds.Load(),
+		ExhaustionWaits:   exhaustionWaits.Load(),
+		ExhaustionErrors:  exhaustionErrors.Load(),
+		OverflowTime:      overflowTime,
+		RemainingLifetime: overflowTime.Sub(now),
+	}
+	if status.Degraded {
+		status.LeaseExpireTime = GetLeaseExpireTime(ctx)
+	}
+	if newIdLastTimeSeq.Load() == status.TimeSeq {
+		status.SeqUsed = min(newIdSeq.Load()+1, status.SeqCapacity)
+	}
+	status.SeqUtilization = float64(status.SeqUsed) / float64(status.SeqCapacity)
+
+	heartbeatLock.Lock()
+	status.LastHeartbeatTime = lastHeartbeatTime
+	if lastHeartbeatErr != nil {
+		status.LastHeartbeatError = lastHeartbeatErr.Error()
+	}
+	heartbeatLock.Unlock()
+
+	problems := make([]string, 0)
+	if status.LeaseLost {
+		problems = append(problems, "lease lost")
+	}
+	if driftReleased.Load() {
+		problems = append(problems, "worker released for clock drift")
+	}
+	if !now.Before(status.LeaseExpireTime) {
+		problems = append(problems, "lease expired at "+status.LeaseExpireTime.String())
+	}
+	if status.RemainingLifetime <= 0 {
+		problems = append(problems, "timestamp bits exhausted")
+	}
+	status.Live = len(problems) == 0
+
+	if status.Drift.Exceeded {
+		problems = append(problems, "clock drift from database exceeds threshold: "+status.Drift.Offset.String())
+	}
+	if status.Ntp.Exceeded {
+		problems = append(problems, "clock offset from ntp servers exceeds threshold: "+status.Ntp.Offset.String())
+	}
+	if status.Drift.Stopped && !driftReleased.Load() {
+		problems = append(problems, "id generation paused for clock drift")
+	}
+	status.Ready = len(problems) == 0
+	status.Problems = problems
+	return status
+}
@@ -2,11 +2,12 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"github.com/treeyh/raindrop/config"
 	"github.com/treeyh/raindrop/consts"
 	"github.com/treeyh/raindrop/db"
 	"github.com/treeyh/raindrop/logger"
-	"github.com/treeyh/raindrop/utils"
-	"strconv"
+	"log/slog"
 	"time"
 )
 
@@ -37,7 +38,7 @@ func (t *Ticket) Start(ctx context.Context) {
 
 // calcNowTimeSeq 计算当前时间戳流水
 func calcNowTimeSeq(ctx context.Context) error {
-	seq := calcTimestamp(ctx, time.Now().UnixMilli(), timeUnit)
+	seq := calcTimestamp(ctx, clock.Now().UnixMilli(), timeUnit)
 	nowTimeSeq.Store(seq)
 	return nil
 }
@@ -52,25 +53,79 @@ func startCalcNowTimeSeq(ctx context.Context) {
 	}
 }
 
+// RefreshNowTimeSeq 立即按时钟刷新当前时间戳流水，用于手动时钟场景
+func RefreshNowTimeSeq(ctx context.Context) error {
+	return calcNowTimeSeq(ctx)
+}
+
+// Heartbeat 立即执行一次心跳，用于手动驱动心跳的场景
+func Heartbeat(ctx context.Context) error {
+	return heartbeat(ctx)
+}
+
 // startHeartbeat 启动心跳
 func startHeartbeat(ctx context.Context) {
 	ticket := NewTicket(time.Duration(consts.HeartbeatTimeInterval)*time.Second, heartbeat)
 	ticket.Start(ctx)
 }
 
+// heartbeat 心跳续约并记录结果，同时输出上次心跳后被限流的日志汇总。租约丢失后不再心跳
 func heartbeat(ctx context.Context) error {
-	log.Info(ctx, "worker heartbeat. workerId: "+strconv.FormatInt(worker.Id, 10))
-	w, err := db.Db.HeartbeatWorker(ctx, worker)
+	limitedLog.Flush(ctx)
+	if leaseLost.Load() {
+		return consts.ErrMsgWorkerLeaseLost
+	}
+	begin := time.Now()
+	err := renewLease(ctx)
+	latency := time.Since(begin)
+	recordHeartbeat(err)
+	w := *worker.Load()
+	notify(ctx, "HeartbeatCompleted", func(o config.Observer) {
+		o.HeartbeatCompleted(ctx, w, latency, err)
+	})
+	if err != nil {
+		notify(ctx, "HeartbeatFailed", func(o config.Observer) {
+			o.HeartbeatFailed(ctx, w, err)
+		})
+		if errors.Is(err, consts.ErrMsgWorkerLeaseLost) {
+			markLeaseLost(ctx, w, err)
+		}
+		return err
+	}
+	checkTimestampExpiring(ctx)
+	return nil
+}
+
+// renewLease 查询NTP服务器，通过DB心跳续约并估算与DB时间的偏差
+func renewLease(ctx context.Context) error {
+	if driftReleased.Load() {
+		// 已因时间偏差释放worker，不再续约
+		return consts.ErrMsgClockDriftExceeded
+	}
+	if degraded.Load() {
+		// 降级运行时由 RecoverLease 通过数据库确认租约
+		return consts.ErrMsgDatabaseInitFail
+	}
+	// 先于DB心跳查询NTP服务器，DB不可用时仍能发现时钟偏差
+	ntpErr := checkNtp(ctx)
+	if driftReleased.Load() {
+		return consts.ErrMsgClockDriftExceeded
+	}
+	current := worker.Load()
+	log.Info(ctx, "worker heartbeat", logger.WorkerId(current.Id))
+	w, err := db.Db.HeartbeatWorker(ctx, current)
 	if err != nil {
-		log.Error(ctx, err.Error(), err)
+		log.Error(ctx, "worker heartbeat fail", logger.WorkerId(current.Id), logger.Version(current.Version), err)
+		return err
 	}
 	if logLevel <= logger.Debug {
-		log.Debug(ctx, "worker heartbeat worker: "+utils.ToJsonIgnoreError(w))
+		log.Debug(ctx, "worker heartbeat over", slog.Any("worker", w))
 	}
-	if w.UpdateTime.Unix() > w.HeartbeatTime.Unix()+consts.HeartbeatTimeInterval ||
-		w.UpdateTime.Unix() < w.HeartbeatTime.Unix()-consts.HeartbeatTimeInterval {
-		log.Error(ctx, consts.ErrMsgDatabaseServerTimeInterval.Error()+". worker:"+utils.ToJsonIgnoreError(w))
+	worker.Store(w)
+	saveLeaseCache(ctx, leaseExpireAt(w.HeartbeatTime))
+	err = checkDrift(ctx)
+	if err == nil {
+		err = ntpErr
 	}
-	worker = w
 	return err
 }
@@ -2,13 +2,14 @@ package worker
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/treeyh/raindrop/config"
 	"github.com/treeyh/raindrop/consts"
 	"github.com/treeyh/raindrop/db"
 	"github.com/treeyh/raindrop/logger"
 	"github.com/treeyh/raindrop/model"
 	"github.com/treeyh/raindrop/utils"
+	"log/slog"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,7 +22,12 @@ var (
 	timeUnit   consts.TimeUnit
 	logLevel   logger.LogLevel
 	log        logger.ILogger
-	worker     *model.RaindropWorker
+
+	// worker 当前持有的worker，心跳续约时替换，GetStatus 等在其他协程中并发读取
+	worker atomic.Pointer[model.RaindropWorker]
+
+	// limitedLog 限流的日志，用于生成id等高频路径，避免时钟回拨或序列号耗尽时大量输出重复日志
+	limitedLog *logger.RateLimitedLogger
 
 	// idMode id模式
 	idMode   string
@@ -32,8 +38,15 @@ var (
 	// endBitsValue 最后预留位bit值
 	endBitsValue int64
 
+	// datacenterId 数据中心 id
+	datacenterId int64
+	// layout id 结构指纹
+	layout string
+
 	// timeStampShift 时间戳位移位数
 	timeStampShift int
+	// datacenterIdShift 数据中心位移位数
+	datacenterIdShift int
 	// workerIdShift 位移位数
 	workerIdShift int
 	// timeBackShift 时间回拨位移位数
@@ -43,6 +56,12 @@ var (
 
 	// maxIdSeq 最大的id序列值
 	maxIdSeq int64
+	// maxWorkerId 最大的工作节点 id 值
+	maxWorkerId int64
+	// maxDatacenterId 最大的数据中心 id 值
+	maxDatacenterId int64
+	// maxEndBitsValue 预留位的最大值
+	maxEndBitsValue int64
 	// startTime 开始计算时间戳，毫秒
 	startTime int64
 
@@ -58,32 +77,62 @@ var (
 	// newIdSeq 获取新id同一时间的自增序列
 	newIdSeq atomic.Int64
 
-	// newCodeLockLock 基于code获取新id的生成code锁的锁
-	newCodeLockLock sync.Mutex
-	// newIdByCodeLockMap 获取基于code新id的锁
-	newIdByCodeLockMap = make(map[string]sync.Mutex)
-	// newIdByCodeTimeSeqMap 上次的获取基于code新id时间序列
-	newIdByCodeTimeSeqMap = make(map[string]atomic.Int64)
-	// newIdByCodeTimeBackValueMap 基于code 时间回拨值
-	newIdByCodeTimeBackValueMap = make(map[string]atomic.Int64)
-	// newIdByCodeSeqMap 获取基于code新id同一时间的自增序列
-	newIdByCodeSeqMap = make(map[string]atomic.Int64)
+	// newCodeLockLock 基于code获取新id的生成code状态的锁
+	newCodeLockLock sync.RWMutex
+	// newIdByCodeStateMap 基于code获取新id的状态
+	newIdByCodeStateMap = make(map[string]*codeState)
+
+	// clock 时钟
+	clock utils.Clock = utils.SystemClock{}
 )
 
-// Init 初始化worker
-func Init(ctx context.Context, conf config.RainDropConfig) error {
+// codeState 基于code获取新id的状态
+type codeState struct {
+	// lock 获取基于code新id的锁
+	lock sync.Mutex
+	// lastTimeSeq 上次的获取基于code新id时间序列
+	lastTimeSeq int64
+	// timeBackValue 基于code 时间回拨值
+	timeBackValue int64
+	// seq 获取基于code新id同一时间的自增序列
+	seq int64
+}
+
+// initRuntime 初始化日志、观察者及时钟
+func initRuntime(conf config.RainDropConfig) {
 	log = conf.Logger
+	limitedLog = logger.NewRateLimited(log, conf.LogRateLimit)
+	observer = conf.Observer
 	logLevel = log.GetLogLevel()
+	if conf.Clock != nil {
+		clock = conf.Clock
+	} else {
+		clock = utils.SystemClock{}
+	}
+}
+
+// Init 初始化worker，需先调用 CheckStartupNtp
+func Init(ctx context.Context, conf config.RainDropConfig) error {
+	initRuntime(conf)
+	initLeaseCache(conf)
 
 	w, err := activateWorker(ctx, conf)
 	if w == nil {
-		if err != nil {
-			return err
+		if err == nil {
+			log.Error(ctx, consts.ErrMsgWorkersNotAvailable.Error())
+			err = consts.ErrMsgWorkersNotAvailable
 		}
-		log.Error(ctx, consts.ErrMsgWorkersNotAvailable.Error())
-		return consts.ErrMsgWorkersNotAvailable
+		if !errors.Is(err, consts.ErrMsgWorkersNotAvailable) && !errors.Is(err, consts.ErrMsgWorkerLayoutMismatch) {
+			var dbErr *consts.DbError
+			if errors.As(err, &dbErr) {
+				return err
+			}
+			return &consts.DbError{Op: "activate worker", Err: err}
+		}
+		return &consts.LeaseError{DatacenterId: conf.DatacenterId, MinWorkerId: conf.ServiceMinWorkId, MaxWorkerId: conf.ServiceMaxWorkId,
+			TimeUnit: conf.TimeUnit, Err: err}
 	}
-	worker = w
+	worker.Store(w)
 
 	initParams(ctx, conf)
 
@@ -91,6 +140,8 @@ func Init(ctx context.Context, conf config.RainDropConfig) error {
 	if err != nil {
 		return err
 	}
+	saveLeaseCache(ctx, leaseExpireAt(w.HeartbeatTime))
+	checkTimestampExpiring(ctx)
 
 	if v := ctx.Value(consts.ProjectName); v != nil {
 		// 支持单元测试，跳过启动心跳线程
@@ -105,9 +156,34 @@ func Init(ctx context.Context, conf config.RainDropConfig) error {
 	return nil
 }
 
+// CheckStartupNtp 连接数据库前重置时间偏差的估算状态并查询NTP服务器，未配置NTP服务器时不查询。
+// 偏差超过阈值且处理策略不是 warn 时返回 consts.ClockError，NTP服务器均不可用时仅输出错误日志
+func CheckStartupNtp(ctx context.Context, conf config.RainDropConfig) error {
+	initRuntime(conf)
+	initDrift(conf)
+	if len(ntpServers) == 0 {
+		return nil
+	}
+	// 尚未连接数据库及分配worker，时钟异常仅保留在内存中，记录的 worker id 为 0
+	workerId = 0
+	incidentMemoryOnly.Store(true)
+	defer incidentMemoryOnly.Store(false)
+	exceeded, _ := sampleNtp(ctx)
+	if exceeded && conf.DriftPolicy != consts.DriftPolicyWarn {
+		return &consts.ClockError{Source: consts.ClockIncidentNtpDrift, Offset: GetNtpStatus(ctx).Offset, Threshold: conf.DriftThreshold,
+			TimeUnit: conf.TimeUnit, Err: consts.ErrMsgNtpOffsetExceeded}
+	}
+	return nil
+}
+
 // GetWorkerId 获得WorkerId
 func GetWorkerId(ctx context.Context) int64 {
-	return worker.Id
+	return worker.Load().Id
+}
+
+// GetDatacenterId 获得DatacenterId
+func GetDatacenterId(ctx context.Context) int64 {
+	return datacenterId
 }
 
 // GetNowTimeSeq 获得NowTimeSeq
@@ -115,6 +191,11 @@ func GetNowTimeSeq(ctx context.Context) int64 {
 	return nowTimeSeq.Load()
 }
 
+// GetTimeBackBitValue 获得当前时间回拨位的值
+func GetTimeBackBitValue(ctx context.Context) int64 {
+	return timeBackBitValue.Load()
+}
+
 // calcTimestamp 计算时间戳
 func calcTimestamp(ctx context.Context, timestampMilli int64, timeUnit consts.TimeUnit) int64 {
 	st := timestampMilli
@@ -135,29 +216,39 @@ func calcTimestamp(ctx context.Context, timestampMilli int64, timeUnit consts.Ti
 // initParams 初始化参数
 func initParams(ctx context.Context, conf config.RainDropConfig) {
 	idMode = strings.ToLower(conf.IdMode)
-	workerId = worker.Id
+	workerId = worker.Load().Id
 	timeUnit = conf.TimeUnit
 
 	timeBackInitValue = int64(conf.TimeBackBitValue)
 	timeBackBitValue.Store(timeBackInitValue)
 	endBitsValue = int64(conf.EndBitsValue)
+	datacenterId = conf.DatacenterId
+	resetSeqExhausted()
+	initStatus(conf)
 
 	startTime = calcTimestamp(ctx, conf.StartTimeStamp.UnixMilli(), conf.TimeUnit)
 
-	seqLength := consts.IdBitLength - conf.TimeStampLength - conf.WorkIdLength - consts.TimeBackBitLength - conf.EndBitsLength
+	seqLength := consts.IdBitLength - conf.TimeStampLength - conf.DatacenterIdLength - conf.WorkIdLength - consts.TimeBackBitLength - conf.EndBitsLength
 
 	// 计算同一时刻最大流水号
 	maxIdSeq = (1 << seqLength) - 1
+	maxWorkerId = (1 << conf.WorkIdLength) - 1
+	maxDatacenterId = (1 << conf.DatacenterIdLength) - 1
+	maxEndBitsValue = (1 << conf.EndBitsLength) - 1
 
+	// 从高位到低位依次为：时间戳、数据中心、工作节点、时间回拨、流水号、预留位
 	seqShift = conf.EndBitsLength
 	timeBackShift = seqLength + seqShift
 	workerIdShift = timeBackShift + consts.TimeBackBitLength
-	timeStampShift = workerIdShift + conf.WorkIdLength
-
-	log.Info(ctx, fmt.Sprintf("idMode:%s, timeBackBitValue:%d, endBitsValue:%d, workerId:%d, seqLength:%d, "+
-		"workerLength:%d, timeLength:%d, maxIdSeq:%d, seqShift: %d, timeBackShift: %d, workerIdShift: %d, timeStampShift:%d",
-		idMode, timeBackBitValue.Load(), endBitsValue, workerId, seqLength,
-		conf.WorkIdLength, conf.TimeStampLength, maxIdSeq, seqShift, timeBackShift, workerIdShift, timeStampShift))
+	datacenterIdShift = workerIdShift + conf.WorkIdLength
+	timeStampShift = datacenterIdShift + conf.DatacenterIdLength
+
+	log.Info(ctx, "worker params initialized", slog.String("id_mode", idMode), slog.Int64("time_back_bit", timeBackBitValue.Load()),
+		slog.Int64("end_bits", endBitsValue), logger.DatacenterId(datacenterId), logger.WorkerId(workerId),
+		slog.Int("seq_length", seqLength), slog.Int("datacenter_length", conf.DatacenterIdLength),
+		slog.Int("worker_length", conf.WorkIdLength), slog.Int("time_length", conf.TimeStampLength), slog.Int64("max_seq", maxIdSeq),
+		slog.Int("seq_shift", seqShift), slog.Int("time_back_shift", timeBackShift), slog.Int("worker_id_shift", workerIdShift),
+		slog.Int("datacenter_id_shift", datacenterIdShift), slog.Int("time_stamp_shift", timeStampShift))
 }
 
 // activateWorker 激活worker
@@ -165,37 +256,30 @@ func activateWorker(ctx context.Context, conf config.RainDropConfig) (*model.Rai
 
 	ip, err := utils.GetLocalIP()
 	if err != nil {
-		log.Error(ctx, "get local ip fail: "+err.Error(), err)
+		log.Error(ctx, "get local ip fail", err)
 		return nil, err
 	}
 	timeUnit = conf.TimeUnit
+	layout = LayoutFingerprint(conf)
 	workerCode = ip + "#" + strconv.Itoa(conf.ServicePort) + "#" + strconv.Itoa(int(timeUnit)) + "#" + utils.GetFirstMacAddr()
 
 	if conf.PriorityEqualCodeWorkId && (timeUnit == consts.TimeUnitMillisecond || timeUnit == consts.TimeUnitSecond) {
 		w, e := db.Db.GetBeforeWorker(ctx, workerCode)
 
 		if e != nil {
-			return nil, err
+			log.Error(ctx, "get before worker fail", e)
+			return nil, &consts.DbError{Op: "get before worker", Err: e}
 		}
-		if w != nil {
-			w, e = db.Db.ActivateWorker(ctx, w.Id, workerCode, int(timeUnit), w.Version)
-			if w != nil {
-				return w, nil
+		if w != nil && !layoutQuarantined(*w, layout, clock.Now(), conf.LayoutQuarantineTime) {
+			w2, _ := db.Db.ActivateWorker(ctx, w.Id, workerCode, int(timeUnit), layout, w.Version)
+			if w2 != nil {
+				recordActivation(ctx, conf, *w, w2)
+				return w2, nil
 			}
 		}
 	}
 
-	heartbeatMaxTime := time.Now().Add(time.Duration(consts.HeartbeatTimeInterval*-4) * time.Second)
-
-	if timeUnit == consts.TimeUnitMinute {
-		heartbeatMaxTime = heartbeatMaxTime.Add(time.Duration(-1) * time.Minute)
-	} else if timeUnit == consts.TimeUnitHour {
-		heartbeatMaxTime = heartbeatMaxTime.Add(time.Duration(-1) * time.Hour)
-	} else if timeUnit == consts.TimeUnitDay {
-		heartbeatMaxTime = heartbeatMaxTime.Add(time.Duration(-24) * time.Hour)
-	}
-
-	workers, err := db.Db.QueryFreeWorkers(ctx, heartbeatMaxTime)
+	workers, err := db.Db.QueryFreeWorkers(ctx, FreeHeartbeatTime(clock.Now(), timeUnit))
 
 	if err != nil {
 		log.Error(ctx, err.Error(), err)
@@ -206,16 +290,41 @@ func activateWorker(ctx context.Context, conf config.RainDropConfig) (*model.Rai
 		return nil, consts.ErrMsgWorkersNotAvailable
 	}
 
+	quarantined := 0
 	for _, w := range workers {
-		w2, e := db.Db.ActivateWorker(ctx, w.Id, workerCode, int(timeUnit), w.Version)
+		if layoutQuarantined(w, layout, clock.Now(), conf.LayoutQuarantineTime) {
+			quarantined++
+			continue
+		}
+		w2, e := db.Db.ActivateWorker(ctx, w.Id, workerCode, int(timeUnit), layout, w.Version)
 		if w2 != nil {
+			recordActivation(ctx, conf, w, w2)
 			return w2, e
 		}
 	}
+	if quarantined == len(workers) {
+		log.Error(ctx, consts.ErrMsgWorkerLayoutMismatch.Error(), slog.String("layout", layout), slog.Int("quarantined", quarantined))
+		return nil, consts.ErrMsgWorkerLayoutMismatch
+	}
 	return nil, nil
 }
 
+// NewId 生成id，配置了观察者时回调生成耗时
 func NewId(ctx context.Context) (int64, error) {
+	if observer == nil {
+		return newId(ctx)
+	}
+	begin := time.Now()
+	id, err := newId(ctx)
+	notifyIdGenerated(ctx, "", begin, err)
+	return id, err
+}
+
+// newId 生成id
+func newId(ctx context.Context) (int64, error) {
+	if err := checkIssuable(); err != nil {
+		return 0, err
+	}
 	newIdLock.Lock()
 	defer newIdLock.Unlock()
 
@@ -229,32 +338,27 @@ func NewId(ctx context.Context) (int64, error) {
 		seq = newIdSeq.Add(1)
 		if seq > maxIdSeq {
 			// 超过了序列最大值
+			recordSeqExhausted(ctx, "", timestamp)
 			// 毫秒，秒还能抢救一下
-			if timeUnit == consts.TimeUnitMillisecond {
+			if timeUnit == consts.TimeUnitMillisecond || timeUnit == consts.TimeUnitSecond {
 				if logLevel <= logger.Debug {
-					log.Debug(ctx, fmt.Sprintf("millisecond unit sleep %d, seq: %d, maxIdSeq: %d", timestamp, seq, maxIdSeq))
+					limitedLog.Debug(ctx, "seq exhausted, wait for next time seq", logger.TimeSeq(timestamp), slog.Int64("seq", seq),
+						slog.Int64("max_seq", maxIdSeq), slog.Int("time_unit", int(timeUnit)))
 				}
-				for {
-					timestamp = nowTimeSeq.Load()
-					if timestamp > lastTimeSeq {
-						break
-					}
-				}
-			} else if timeUnit == consts.TimeUnitSecond {
-				if logLevel <= logger.Debug {
-					log.Debug(ctx, fmt.Sprintf("second unit sleep %d, seq: %d, maxIdSeq: %d", timestamp, seq, maxIdSeq))
-				}
-				for {
-					time.Sleep(time.Duration(10) * time.Millisecond)
-					timestamp = nowTimeSeq.Load()
-					if timestamp > lastTimeSeq {
-						break
-					}
+				exhaustionWaits.Add(1)
+				begin := time.Now()
+				var err error
+				timestamp, err = waitNextTimeSeq(ctx, lastTimeSeq)
+				if err != nil {
+					return 0, err
 				}
+				notifySeqExhausted(ctx, "", lastTimeSeq, begin)
 			} else {
 				// 不是毫秒或秒时间单位，不等待直接返回错误
-				log.Error(ctx, fmt.Sprintf("timeUnit: %d, timeSeq: %d, seq: %d, maxIdSeq: %d",
-					int(timeUnit), timestamp, seq, maxIdSeq))
+				limitedLog.Error(ctx, consts.ErrMsgIdSeqReachesMaxValueError.Error(), logger.TimeSeq(timestamp), slog.Int64("seq", seq),
+					slog.Int64("max_seq", maxIdSeq), slog.Int("time_unit", int(timeUnit)))
+				exhaustionErrors.Add(1)
+				notifySeqExhausted(ctx, "", timestamp, time.Time{})
 				return 0, consts.ErrMsgIdSeqReachesMaxValueError
 			}
 			seq = 0
@@ -266,11 +370,12 @@ func NewId(ctx context.Context) (int64, error) {
 	}
 
 	if lastTimeSeq > timestamp {
-		log.Error(ctx, fmt.Sprintf("timeUnit:%d, lastTimeSeq: %d, timestamp: %d ", int(timeUnit), lastTimeSeq, timestamp),
-			consts.ErrMsgServerClockBackwardsError)
+		limitedLog.Error(ctx, "server clock backwards", slog.Int64("last_time_seq", lastTimeSeq), logger.TimeSeq(timestamp),
+			slog.Int("time_unit", int(timeUnit)), consts.ErrMsgServerClockBackwardsError)
 		// timeBackValue 取反，避免重复
 		timeBackValue = timeBackValue ^ 1
 		timeBackBitValue.Store(timeBackValue)
+		recordClockBackwards(ctx, "", lastTimeSeq, timestamp, timeBackValue^1, timeBackValue)
 	}
 	if lastTimeSeq != timestamp {
 		newIdLastTimeSeq.Store(timestamp)
@@ -282,121 +387,189 @@ func NewId(ctx context.Context) (int64, error) {
 	//log.Debug(ctx, fmt.Sprintf("seq:%d, seqShift:%d\n", seq, seqShift))
 	//log.Debug(ctx, fmt.Sprintf("endBitsValue：%d\n", endBitsValue))
 
+	recordIssuedTimeSeq(timestamp)
+	issuedIds.Add(1)
 	return ((timestamp - startTime) << timeStampShift) |
+		(datacenterId << datacenterIdShift) |
 		(workerId << workerIdShift) |
 		(timeBackValue << timeBackShift) |
 		(seq << seqShift) |
 		endBitsValue, nil
 }
 
+// NewIdByCode 基于code生成id，配置了观察者时回调生成耗时
 func NewIdByCode(ctx context.Context, code string) (int64, error) {
+	if observer == nil {
+		return newIdByCode(ctx, code)
+	}
+	begin := time.Now()
+	id, err := newIdByCode(ctx, code)
+	notifyIdGenerated(ctx, code, begin, err)
+	return id, err
+}
 
-	if lock, ok := newIdByCodeLockMap[code]; ok {
-		lock.Lock()
-		lock.Unlock()
-	} else {
-		generateCodeLock(ctx, code)
-		if lock, ok = newIdByCodeLockMap[code]; ok {
-			lock.Lock()
-			lock.Unlock()
-		} else {
-			return 0, consts.ErrMsgGetCodeLockFail
-		}
+// newIdByCode 基于code生成id
+func newIdByCode(ctx context.Context, code string) (int64, error) {
+	if err := checkIssuable(); err != nil {
+		return 0, err
+	}
+	state := getCodeState(ctx, code)
+	if state == nil {
+		return 0, consts.ErrMsgGetCodeLockFail
 	}
+	state.lock.Lock()
+	defer state.lock.Unlock()
 
-	timeBack, _ := newIdByCodeTimeBackValueMap[code]
-	timeBackValue := timeBack.Load()
+	timeBackValue := state.timeBackValue
 	timestamp := nowTimeSeq.Load()
-
-	codeIdSeq, _ := newIdByCodeSeqMap[code]
-
-	lastTime, _ := newIdByCodeTimeSeqMap[code]
-	lastTimeSeq := lastTime.Load()
+	lastTimeSeq := state.lastTimeSeq
 
 	var seq int64
 	if lastTimeSeq == timestamp {
-		// 时间戳未发生变化，需要增加newIdSeq
-		seq = codeIdSeq.Add(1)
+		// 时间戳未发生变化，需要增加seq
+		state.seq++
+		seq = state.seq
 		if seq > maxIdSeq {
 			// 超过了序列最大值
-
+			recordSeqExhausted(ctx, code, timestamp)
 			// 毫秒，秒还能抢救一下
-			if timeUnit == consts.TimeUnitMillisecond {
+			if timeUnit == consts.TimeUnitMillisecond || timeUnit == consts.TimeUnitSecond {
 				if logLevel <= logger.Debug {
-					log.Debug(ctx, fmt.Sprintf("code:%s, millisecond unit sleep %d, seq: %d, maxIdSeq: %d", code, timestamp, seq, maxIdSeq))
+					limitedLog.Debug(ctx, "seq exhausted, wait for next time seq", logger.IdCode(code), logger.TimeSeq(timestamp),
+						slog.Int64("seq", seq), slog.Int64("max_seq", maxIdSeq), slog.Int("time_unit", int(timeUnit)))
 				}
-				for {
-					timestamp = nowTimeSeq.Load()
-					if timestamp != lastTimeSeq {
-						break
-					}
-				}
-			} else if timeUnit == consts.TimeUnitSecond {
-				if logLevel <= logger.Debug {
-					log.Debug(ctx, fmt.Sprintf("code:%s, second unit sleep %d, seq: %d, maxIdSeq: %d", code, timestamp, seq, maxIdSeq))
-				}
-				for {
-					time.Sleep(time.Duration(10) * time.Millisecond)
-					timestamp = nowTimeSeq.Load()
-					if timestamp != lastTimeSeq {
-						break
-					}
+				exhaustionWaits.Add(1)
+				begin := time.Now()
+				var err error
+				timestamp, err = waitNextTimeSeq(ctx, lastTimeSeq)
+				if err != nil {
+					return 0, err
 				}
+				notifySeqExhausted(ctx, code, lastTimeSeq, begin)
 			} else {
 				// 不是毫秒或秒时间单位，不等待直接返回错误
-				log.Error(ctx, fmt.Sprintf("code:%s, timeUnit: %d, timeSeq: %d, seq: %d, maxIdSeq: %d",
-					code, int(timeUnit), timestamp, seq, maxIdSeq))
+				limitedLog.Error(ctx, consts.ErrMsgIdSeqReachesMaxValueError.Error(), logger.IdCode(code), logger.TimeSeq(timestamp),
+					slog.Int64("seq", seq), slog.Int64("max_seq", maxIdSeq), slog.Int("time_unit", int(timeUnit)))
+				exhaustionErrors.Add(1)
+				notifySeqExhausted(ctx, code, timestamp, time.Time{})
 				return 0, consts.ErrMsgIdSeqReachesMaxValueError
 			}
 			seq = 0
-			codeIdSeq.Store(0)
+			state.seq = 0
 		}
 	} else {
 		seq = 0
-		codeIdSeq.Store(0)
+		state.seq = 0
 	}
-	newIdByCodeSeqMap[code] = codeIdSeq
 
 	if lastTimeSeq > timestamp {
-		log.Error(ctx, fmt.Sprintf("timeUnit:%d, lastTimeSeq: %d, timestamp: %d ", int(timeUnit), lastTimeSeq, timestamp),
-			consts.ErrMsgServerClockBackwardsError)
+		limitedLog.Error(ctx, "server clock backwards", logger.IdCode(code), slog.Int64("last_time_seq", lastTimeSeq),
+			logger.TimeSeq(timestamp), slog.Int("time_unit", int(timeUnit)), consts.ErrMsgServerClockBackwardsError)
 		// timeBackValue 取反，避免重复
 		timeBackValue = timeBackValue ^ 1
-		timeBackBitValue.Store(timeBackValue)
-		newIdByCodeTimeBackValueMap[code] = timeBackBitValue
-	}
-	if lastTimeSeq != timestamp {
-		lastTime.Store(timestamp)
-		newIdByCodeTimeSeqMap[code] = lastTime
+		state.timeBackValue = timeBackValue
+		recordClockBackwards(ctx, code, lastTimeSeq, timestamp, timeBackValue^1, timeBackValue)
 	}
+	state.lastTimeSeq = timestamp
 
+	recordIssuedTimeSeq(timestamp)
+	issuedIds.Add(1)
 	return ((timestamp - startTime) << timeStampShift) |
+		(datacenterId << datacenterIdShift) |
 		(workerId << workerIdShift) |
 		(timeBackValue << timeBackShift) |
 		(seq << seqShift) |
 		endBitsValue, nil
-
-	return 0, nil
 }
 
-func generateCodeLock(ctx context.Context, code string) {
+// getCodeState 获取code对应的状态，不存在则创建
+func getCodeState(ctx context.Context, code string) *codeState {
+	newCodeLockLock.RLock()
+	state, ok := newIdByCodeStateMap[code]
+	newCodeLockLock.RUnlock()
+	if ok {
+		return state
+	}
+
 	newCodeLockLock.Lock()
 	defer newCodeLockLock.Unlock()
 
-	if _, ok := newIdByCodeTimeSeqMap[code]; ok {
-		return
+	if state, ok = newIdByCodeStateMap[code]; ok {
+		return state
+	}
+	state = &codeState{
+		timeBackValue: timeBackInitValue,
+	}
+	newIdByCodeStateMap[code] = state
+	return state
+}
+
+// waitNextTimeSeq 等待时间序列发生变化，ctx 结束时返回错误
+func waitNextTimeSeq(ctx context.Context, lastTimeSeq int64) (int64, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		default:
+		}
+		if timeUnit == consts.TimeUnitSecond {
+			time.Sleep(time.Duration(10) * time.Millisecond)
+		}
+		timestamp := nowTimeSeq.Load()
+		if timestamp != lastTimeSeq {
+			return timestamp, nil
+		}
+	}
+}
+
+// FreeHeartbeatTime 心跳时间早于返回值的worker视为空闲，可被其他节点激活
+func FreeHeartbeatTime(now time.Time, timeUnit consts.TimeUnit) time.Time {
+	heartbeatMaxTime := now.Add(time.Duration(consts.HeartbeatTimeInterval*-4) * time.Second)
+
+	if timeUnit == consts.TimeUnitMinute {
+		heartbeatMaxTime = heartbeatMaxTime.Add(time.Duration(-1) * time.Minute)
+	} else if timeUnit == consts.TimeUnitHour {
+		heartbeatMaxTime = heartbeatMaxTime.Add(time.Duration(-1) * time.Hour)
+	} else if timeUnit == consts.TimeUnitDay {
+		heartbeatMaxTime = heartbeatMaxTime.Add(time.Duration(-24) * time.Hour)
 	}
+	return heartbeatMaxTime
+}
 
-	var lastTime atomic.Int64
-	var seq atomic.Int64
-	var timeBackValue atomic.Int64
-	var codeLock sync.Mutex
-	lastTime.Store(0)
-	seq.Store(0)
-	timeBackValue.Store(timeBackInitValue)
-
-	newIdByCodeTimeBackValueMap[code] = timeBackBitValue
-	newIdByCodeLockMap[code] = codeLock
-	newIdByCodeTimeSeqMap[code] = lastTime
-	newIdByCodeSeqMap[code] = seq
+// CountFreeWorkers 查询当前数据中心可被激活的空闲worker数量，包含 id 结构不一致被隔离的worker
+func CountFreeWorkers(ctx context.Context) (int, error) {
+	workers, err := db.Db.QueryFreeWorkers(ctx, FreeHeartbeatTime(clock.Now(), timeUnit))
+	if err != nil {
+		return 0, err
+	}
+	return len(workers), nil
+}
+
+// Parse 按当前的id结构解析id，id 需由相同结构配置的服务生成
+func Parse(id int64) model.IdInfo {
+	timeSeq := id >> timeStampShift
+	return model.IdInfo{
+		Time:         timeSeqToTime(timeSeq+startTime, timeUnit),
+		TimeSeq:      timeSeq,
+		DatacenterId: (id >> datacenterIdShift) & maxDatacenterId,
+		WorkerId:     (id >> workerIdShift) & maxWorkerId,
+		TimeBack:     (id >> timeBackShift) & 1,
+		Seq:          (id >> seqShift) & maxIdSeq,
+		EndBits:      id & maxEndBitsValue,
+	}
+}
+
+// timeSeqToTime 将时间单位的时间戳转换为时间，calcTimestamp 的逆运算
+func timeSeqToTime(timeSeq int64, timeUnit consts.TimeUnit) time.Time {
+	switch timeUnit {
+	case consts.TimeUnitSecond:
+		return time.Unix(timeSeq, 0)
+	case consts.TimeUnitMinute:
+		return time.Unix(timeSeq*60, 0)
+	case consts.TimeUnitHour:
+		return time.Unix(timeSeq*60*60, 0)
+	case consts.TimeUnitDay:
+		return time.Unix(timeSeq*60*60*24, 0)
+	}
+	return time.UnixMilli(timeSeq)
 }